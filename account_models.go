@@ -0,0 +1,192 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrock"
+	"github.com/aws/aws-sdk-go-v2/service/bedrock/types"
+)
+
+// Access of an account to a model, see modelAccess
+const (
+	accessAvailable        = "available"
+	accessNotAuthorized    = "not authorized"
+	accessNotEntitled      = "not entitled"
+	accessAgreementPending = "agreement pending"
+	accessNoAgreement      = "no agreement"
+	accessNotInRegion      = "not in region"
+	accessUnknown          = "unknown"
+)
+
+// bedrockModelsAPI is the part of the bedrock API used to list the models of an
+// account, implemented by *bedrock.Client.
+type bedrockModelsAPI interface {
+	ListFoundationModels(ctx context.Context, params *bedrock.ListFoundationModelsInput, optFns ...func(*bedrock.Options)) (*bedrock.ListFoundationModelsOutput, error)
+	ListInferenceProfiles(ctx context.Context, params *bedrock.ListInferenceProfilesInput, optFns ...func(*bedrock.Options)) (*bedrock.ListInferenceProfilesOutput, error)
+	GetFoundationModelAvailability(ctx context.Context, params *bedrock.GetFoundationModelAvailabilityInput, optFns ...func(*bedrock.Options)) (*bedrock.GetFoundationModelAvailabilityOutput, error)
+}
+
+// AccountModel is a Claude model offered in the region of the account.
+type AccountModel struct {
+	ModelID      string   `json:"model_id"`
+	Name         string   `json:"name"`
+	Aliases      []string `json:"aliases,omitempty"`
+	Lifecycle    string   `json:"lifecycle"` // ACTIVE or LEGACY
+	Access       string   `json:"access"`    // e.g. available or not authorized
+	OnDemand     bool     `json:"on_demand"` // can be invoked with the model id, not only with an inference profile
+	Profiles     []string `json:"inference_profiles,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"` // as known to the model registry
+	Known        bool     `json:"known"`                  // in the model registry
+}
+
+// Usable reports whether the model can be invoked, either with its id or with one
+// of its inference profiles.
+func (m *AccountModel) Usable() bool {
+	return (m.Access == accessAvailable || m.Access == accessUnknown) && (m.OnDemand || len(m.Profiles) > 0)
+}
+
+// listAccountModels combines the Anthropic foundation models, their availability
+// to the account and the system-defined inference profiles of the region with
+// the capabilities of the model registry. Known models come first, newest first.
+func listAccountModels(ctx context.Context, client bedrockModelsAPI) ([]AccountModel, error) {
+	foundationModels, err := client.ListFoundationModels(ctx, &bedrock.ListFoundationModelsInput{ByProvider: aws.String("Anthropic")})
+	if err != nil {
+		return nil, err
+	}
+
+	profiles := make(map[string][]string) // by model id
+	paginator := bedrock.NewListInferenceProfilesPaginator(client, &bedrock.ListInferenceProfilesInput{
+		MaxResults: aws.Int32(1000),
+		TypeEquals: types.InferenceProfileTypeSystemDefined,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range page.InferenceProfileSummaries {
+			if p.Status != types.InferenceProfileStatusActive {
+				continue
+			}
+			id := aws.ToString(p.InferenceProfileId)
+			modelID := normalizeToModelID(id)
+			profiles[modelID] = append(profiles[modelID], id)
+		}
+	}
+
+	aliases := make(map[string][]string) // by model id
+	for _, a := range modelRegistry.Aliases() {
+		aliases[a.ModelID] = append(aliases[a.ModelID], a.Alias)
+	}
+
+	models := make([]AccountModel, len(foundationModels.ModelSummaries))
+	var wg sync.WaitGroup
+	for i, summary := range foundationModels.ModelSummaries {
+		modelID := aws.ToString(summary.ModelId)
+		info, known := modelRegistry.Lookup(modelID)
+		m := AccountModel{
+			ModelID:      modelID,
+			Name:         cmp.Or(info.Name, aws.ToString(summary.ModelName)),
+			Aliases:      aliases[modelID],
+			OnDemand:     slices.Contains(summary.InferenceTypesSupported, types.InferenceTypeOnDemand),
+			Profiles:     profiles[modelID],
+			Capabilities: modelCapabilities(info),
+			Known:        known,
+		}
+		if summary.ModelLifecycle != nil {
+			m.Lifecycle = string(summary.ModelLifecycle.Status)
+		}
+		slices.Sort(m.Profiles)
+		models[i] = m
+
+		wg.Go(func() {
+			availability, err := client.GetFoundationModelAvailability(ctx, &bedrock.GetFoundationModelAvailabilityInput{ModelId: aws.String(modelID)})
+			if err != nil {
+				logger.Printf("GetFoundationModelAvailability(%s): %v\n", modelID, err)
+				models[i].Access = accessUnknown
+				return
+			}
+			models[i].Access = modelAccess(availability)
+		})
+	}
+	wg.Wait()
+
+	registryIndex := func(m AccountModel) int {
+		return slices.IndexFunc(modelRegistry.Models, func(info ModelInfo) bool { return info.ID == m.ModelID })
+	}
+	slices.SortStableFunc(models, func(a, b AccountModel) int {
+		if c := cmp.Compare(registryIndex(b), registryIndex(a)); c != 0 { // unknown models (-1) last
+			return c
+		}
+		return strings.Compare(a.ModelID, b.ModelID)
+	})
+
+	return models, nil
+}
+
+// modelAccess returns whether the account has access to a model, or why not.
+func modelAccess(a *bedrock.GetFoundationModelAvailabilityOutput) string {
+	switch {
+	case a.AuthorizationStatus == types.AuthorizationStatusNotAuthorized:
+		return accessNotAuthorized
+	case a.EntitlementAvailability == types.EntitlementAvailabilityNotAvailable:
+		return accessNotEntitled
+	case a.RegionAvailability == types.RegionAvailabilityNotAvailable:
+		return accessNotInRegion
+	case a.AgreementAvailability != nil && a.AgreementAvailability.Status == types.AgreementStatusPending:
+		return accessAgreementPending
+	case a.AgreementAvailability != nil && a.AgreementAvailability.Status != types.AgreementStatusAvailable:
+		return accessNoAgreement
+	}
+	return accessAvailable
+}
+
+// modelCapabilities describes the capabilities of a model of the model registry.
+func modelCapabilities(m ModelInfo) []string {
+	var capabilities []string
+	add := func(supported bool, capability string) {
+		if supported {
+			capabilities = append(capabilities, capability)
+		}
+	}
+	add(m.Vision, "vision")
+	add(m.PDF, "pdf")
+	add(m.Caching, "caching")
+	add(m.Thinking != "", m.Thinking+" thinking")
+	add(m.SupportsEffort(""), "effort")
+	add(m.TextEditor != "", "text editor")
+	return capabilities
+}
+
+const accountModelsCacheKey = "models"
+
+// cachedAccountModels returns the models of the account from the cache, which is
+// updated by 'bods models'.
+func cachedAccountModels(region string) ([]AccountModel, bool) {
+	value, err := getCache(region, accountModelsCacheKey)
+	if err != nil || value == "" {
+		return nil, false
+	}
+	var models []AccountModel
+	if err := json.Unmarshal([]byte(value), &models); err != nil {
+		logger.Println("cachedAccountModels() json.Unmarshal - ", err)
+		return nil, false
+	}
+	return models, true
+}
+
+func cacheAccountModels(region string, models []AccountModel) {
+	value, err := json.Marshal(models)
+	if err == nil {
+		err = updateCache(region, accountModelsCacheKey, string(value))
+	}
+	if err != nil {
+		logger.Println("cacheAccountModels() - ", err)
+	}
+}
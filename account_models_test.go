@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrock"
+	"github.com/aws/aws-sdk-go-v2/service/bedrock/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeBedrockModels struct {
+	models       []types.FoundationModelSummary
+	profiles     []types.InferenceProfileSummary
+	availability map[string]*bedrock.GetFoundationModelAvailabilityOutput
+}
+
+func (f *fakeBedrockModels) ListFoundationModels(_ context.Context, _ *bedrock.ListFoundationModelsInput, _ ...func(*bedrock.Options)) (*bedrock.ListFoundationModelsOutput, error) {
+	return &bedrock.ListFoundationModelsOutput{ModelSummaries: f.models}, nil
+}
+
+func (f *fakeBedrockModels) ListInferenceProfiles(_ context.Context, _ *bedrock.ListInferenceProfilesInput, _ ...func(*bedrock.Options)) (*bedrock.ListInferenceProfilesOutput, error) {
+	return &bedrock.ListInferenceProfilesOutput{InferenceProfileSummaries: f.profiles}, nil
+}
+
+func (f *fakeBedrockModels) GetFoundationModelAvailability(_ context.Context, params *bedrock.GetFoundationModelAvailabilityInput, _ ...func(*bedrock.Options)) (*bedrock.GetFoundationModelAvailabilityOutput, error) {
+	if a, ok := f.availability[*params.ModelId]; ok {
+		return a, nil
+	}
+	return nil, errors.New("AccessDeniedException")
+}
+
+func TestListAccountModels(t *testing.T) {
+	available := &bedrock.GetFoundationModelAvailabilityOutput{
+		AgreementAvailability:   &types.AgreementAvailability{Status: types.AgreementStatusAvailable},
+		AuthorizationStatus:     types.AuthorizationStatusAuthorized,
+		EntitlementAvailability: types.EntitlementAvailabilityAvailable,
+		RegionAvailability:      types.RegionAvailabilityAvailable,
+	}
+	pending := *available
+	pending.AgreementAvailability = &types.AgreementAvailability{Status: types.AgreementStatusPending}
+
+	model := func(id string, inferenceTypes ...types.InferenceType) types.FoundationModelSummary {
+		return types.FoundationModelSummary{
+			ModelId:                 aws.String(id),
+			ModelName:               aws.String(id),
+			InferenceTypesSupported: inferenceTypes,
+			ModelLifecycle:          &types.FoundationModelLifecycle{Status: types.FoundationModelLifecycleStatusActive},
+		}
+	}
+	profile := func(id string) types.InferenceProfileSummary {
+		return types.InferenceProfileSummary{InferenceProfileId: aws.String(id), Status: types.InferenceProfileStatusActive}
+	}
+
+	client := &fakeBedrockModels{
+		models: []types.FoundationModelSummary{
+			model(ClaudeV3Haiku.String(), types.InferenceTypeOnDemand),
+			model("anthropic.claude-future-9"),
+			model("anthropic.claude-opus-4-8", "INFERENCE_PROFILE"),
+			model(ClaudeV46Sonnet.String(), "INFERENCE_PROFILE"),
+		},
+		profiles: []types.InferenceProfileSummary{
+			profile("us.anthropic.claude-opus-4-8"),
+			profile("global.anthropic.claude-opus-4-8"),
+		},
+		availability: map[string]*bedrock.GetFoundationModelAvailabilityOutput{
+			ClaudeV3Haiku.String():      available,
+			"anthropic.claude-opus-4-8": available,
+			ClaudeV46Sonnet.String():    &pending,
+		},
+	}
+
+	models, err := listAccountModels(context.Background(), client)
+	require.NoError(t, err)
+	require.Len(t, models, 4)
+
+	var ids []string
+	for _, m := range models {
+		ids = append(ids, m.ModelID)
+	}
+	assert.Equal(t, []string{"anthropic.claude-opus-4-8", ClaudeV46Sonnet.String(), ClaudeV3Haiku.String(), "anthropic.claude-future-9"}, ids)
+
+	opus := models[0]
+	assert.Equal(t, "Claude Opus 4.8", opus.Name)
+	assert.Equal(t, []string{"global.anthropic.claude-opus-4-8", "us.anthropic.claude-opus-4-8"}, opus.Profiles)
+	assert.Contains(t, opus.Aliases, "opus")
+	assert.Contains(t, opus.Capabilities, "adaptive thinking")
+	assert.True(t, opus.Usable())
+
+	assert.Equal(t, accessAgreementPending, models[1].Access)
+	assert.False(t, models[1].Usable())
+
+	assert.True(t, models[2].Usable(), "on-demand model")
+
+	future := models[3]
+	assert.False(t, future.Known)
+	assert.Equal(t, accessUnknown, future.Access)
+	assert.False(t, future.Usable(), "neither on-demand nor with an inference profile")
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+)
+
+// Bedrock APIs to invoke models with, see --api
+const (
+	apiInvoke   = "invoke"   // InvokeModelWithResponseStream with the Anthropic Messages API request body
+	apiConverse = "converse" // ConverseStream
+)
+
+var apis = []string{apiInvoke, apiConverse}
+
+// modelRequest is a request to a model, independent of the provider and API used.
+type modelRequest struct {
+	ModelID   string
+	Params    *AnthropicClaudeMessagesInferenceParameters
+	Latency   types.PerformanceConfigLatency // empty for the default latency
+	Guardrail *guardrailConfig               // Bedrock guardrail, nil for none
+}
+
+// backend invokes a model with one of the Bedrock APIs or the Anthropic API. The
+// response is streamed as events of the Anthropic Messages API, so
+// receiveStreamingMessagesCmd handles the responses of all backends the same way.
+type backend interface {
+	Stream(ctx context.Context, request modelRequest) (responseStream, error)
+}
+
+// responseStream is the streamed response of a model.
+type responseStream interface {
+	// Events returns the events of the response; the channel is closed at the end
+	// of the stream.
+	Events() <-chan AnthropicClaudeMessagesResponse
+	// Err returns the error that ended the stream early, if any. It is only valid
+	// after the events channel is closed.
+	Err() error
+	Close() error
+}
+
+// newBackend returns the backend for the given provider and, for Bedrock, the given
+// API, e.g. apiConverse.
+func newBackend(provider, api string, client *bedrockruntime.Client) (backend, error) {
+	switch strings.ToLower(provider) {
+	case providerBedrock, "":
+	case providerAnthropic:
+		return newAnthropicBackend()
+	default:
+		return nil, fmt.Errorf("unknown provider '%s', supported are: %s", provider, strings.Join(providers, ", "))
+	}
+
+	switch strings.ToLower(api) {
+	case apiInvoke, "":
+		return &invokeBackend{client: client}, nil
+	case apiConverse:
+		return &converseBackend{client: client}, nil
+	default:
+		return nil, fmt.Errorf("unknown api '%s', supported are: %s", api, strings.Join(apis, ", "))
+	}
+}
+
+// eventStream implements responseStream; a backend specific goroutine sends the
+// converted events and sets err before the events channel is closed.
+type eventStream struct {
+	events    chan AnthropicClaudeMessagesResponse
+	done      chan struct{}
+	closeOnce sync.Once
+	closer    func() error
+	err       error
+}
+
+func newEventStream(closer func() error) *eventStream {
+	return &eventStream{
+		events: make(chan AnthropicClaudeMessagesResponse),
+		done:   make(chan struct{}),
+		closer: closer,
+	}
+}
+
+func (s *eventStream) Events() <-chan AnthropicClaudeMessagesResponse { return s.events }
+
+func (s *eventStream) Err() error { return s.err }
+
+func (s *eventStream) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		err = s.closer()
+	})
+	return err
+}
+
+// send passes an event to the receiver; it returns false if the stream was closed.
+func (s *eventStream) send(event AnthropicClaudeMessagesResponse) bool {
+	select {
+	case s.events <- event:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+// invokeBackend uses InvokeModelWithResponseStream; requests and responses are in
+// the format of the Anthropic Messages API.
+type invokeBackend struct {
+	client *bedrockruntime.Client
+}
+
+func (i *invokeBackend) Stream(ctx context.Context, request modelRequest) (responseStream, error) {
+	body, err := json.Marshal(request.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &bedrockruntime.InvokeModelWithResponseStreamInput{
+		Body:                     body,
+		ModelId:                  aws.String(request.ModelID),
+		ContentType:              aws.String("application/json"),
+		Accept:                   aws.String("application/json"),
+		PerformanceConfigLatency: request.Latency,
+	}
+	if g := request.Guardrail; g != nil {
+		input.GuardrailIdentifier = aws.String(g.ID)
+		input.GuardrailVersion = aws.String(g.Version)
+		input.Trace = types.TraceEnabled
+	}
+	output, err := i.client.InvokeModelWithResponseStream(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	stream := output.GetStream()
+	s := newEventStream(stream.Close)
+	go func() {
+		defer close(s.events)
+		for event := range stream.Events() {
+			chunk, ok := event.(*types.ResponseStreamMemberChunk)
+			if !ok {
+				logger.Printf("invokeBackend: ignoring stream event %T\n", event)
+				continue
+			}
+			var response AnthropicClaudeMessagesResponse
+			if err := json.Unmarshal(chunk.Value.Bytes, &response); err != nil {
+				s.err = fmt.Errorf("invalid response event %s: %w", chunk.Value.Bytes, err)
+				return
+			}
+			if !s.send(response) {
+				return
+			}
+		}
+		s.err = stream.Err()
+	}()
+	return s, nil
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Providers of Claude models, see --provider
+const (
+	providerBedrock   = "bedrock"
+	providerAnthropic = "anthropic" // the Anthropic API, with ANTHROPIC_API_KEY
+)
+
+var providers = []string{providerBedrock, providerAnthropic}
+
+const (
+	anthropicAPIKeyEnv      = "ANTHROPIC_API_KEY"
+	anthropicBaseURLEnv     = "ANTHROPIC_BASE_URL" // e.g. for a proxy; default is defaultAnthropicBaseURL
+	anthropicAPIVersion     = "2023-06-01"
+	defaultAnthropicBaseURL = "https://api.anthropic.com"
+)
+
+// anthropicAPIError is an error response of the Anthropic API.
+type anthropicAPIError struct {
+	StatusCode int    // 0 for an error event of the stream
+	Type       string `json:"type"` // e.g. rate_limit_error or overloaded_error
+	Message    string `json:"message"`
+}
+
+func (e *anthropicAPIError) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("anthropic api: %s: %s", e.Type, e.Message)
+	}
+	return fmt.Sprintf("anthropic api: %s: %s (status %d)", e.Type, e.Message, e.StatusCode)
+}
+
+// Throttled reports whether the request was rejected because of rate limits or
+// because the API is overloaded, so it can be retried later.
+func (e *anthropicAPIError) Throttled() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode == 529 ||
+		e.Type == "rate_limit_error" || e.Type == "overloaded_error"
+}
+
+// anthropicMessagesRequest is a streamed request of the Anthropic Messages API. The
+// Bedrock specific fields of the embedded parameters are shadowed by empty fields,
+// the API version and beta features are sent as headers instead.
+type anthropicMessagesRequest struct {
+	*AnthropicClaudeMessagesInferenceParameters
+	Model            string   `json:"model"`
+	Stream           bool     `json:"stream"`
+	AnthropicVersion string   `json:"anthropic_version,omitempty"`
+	AnthropicBeta    []string `json:"anthropic_beta,omitempty"`
+}
+
+// anthropicBackend uses the Messages API of Anthropic with server-sent events.
+type anthropicBackend struct {
+	apiKey  string
+	baseURL string
+	client  *http.Client
+}
+
+func newAnthropicBackend() (*anthropicBackend, error) {
+	apiKey := os.Getenv(anthropicAPIKeyEnv)
+	if apiKey == "" {
+		return nil, fmt.Errorf("%s is not set, it is required for the %s provider", anthropicAPIKeyEnv, providerAnthropic)
+	}
+	baseURL := os.Getenv(anthropicBaseURLEnv)
+	if baseURL == "" {
+		baseURL = defaultAnthropicBaseURL
+	}
+	return &anthropicBackend{apiKey: apiKey, baseURL: strings.TrimSuffix(baseURL, "/"), client: http.DefaultClient}, nil
+}
+
+func (a *anthropicBackend) Stream(ctx context.Context, request modelRequest) (responseStream, error) {
+	if request.Guardrail != nil {
+		return nil, fmt.Errorf("guardrail %s: guardrails are only available with the %s provider", request.Guardrail, providerBedrock)
+	}
+	body, err := json.Marshal(anthropicMessagesRequest{
+		AnthropicClaudeMessagesInferenceParameters: request.Params,
+		Model:  anthropicModelID(request.ModelID),
+		Stream: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := a.post(ctx, "/v1/messages", body, request.Params.AnthropicBeta)
+	if err != nil {
+		return nil, err
+	}
+
+	s := newEventStream(resp.Body.Close)
+	go func() {
+		defer close(s.events)
+		var eventErr error
+		err := readServerSentEvents(resp.Body, func(event string, data []byte) bool {
+			if event == "error" {
+				var e struct {
+					Error anthropicAPIError `json:"error"`
+				}
+				if err := json.Unmarshal(data, &e); err != nil {
+					eventErr = fmt.Errorf("invalid error event %s: %w", data, err)
+				} else {
+					eventErr = &e.Error
+				}
+				return false
+			}
+			var response AnthropicClaudeMessagesResponse
+			if err := json.Unmarshal(data, &response); err != nil {
+				eventErr = fmt.Errorf("invalid %s event %s: %w", event, data, err)
+				return false
+			}
+			return s.send(response)
+		})
+		select {
+		case <-s.done: // closed by the receiver, reading the body fails
+		default:
+			s.err = errors.Join(eventErr, err)
+		}
+	}()
+	return s, nil
+}
+
+// anthropicCountTokensRequest is a request of the token counting endpoint, which
+// takes the fields of the Messages API that make up the input.
+type anthropicCountTokensRequest struct {
+	Model      string          `json:"model"`
+	Messages   []Message       `json:"messages"`
+	System     SystemPrompt    `json:"system,omitzero"`
+	Tools      []any           `json:"tools,omitempty"`
+	ToolChoice *ToolChoice     `json:"tool_choice,omitempty"`
+	Thinking   *ThinkingConfig `json:"thinking,omitempty"`
+}
+
+func (a *anthropicBackend) CountTokens(ctx context.Context, request modelRequest) (int, error) {
+	params := request.Params
+	body, err := json.Marshal(anthropicCountTokensRequest{
+		Model:      anthropicModelID(request.ModelID),
+		Messages:   params.Messages,
+		System:     params.System,
+		Tools:      params.Tools,
+		ToolChoice: params.ToolChoice,
+		Thinking:   params.Thinking,
+	})
+	if err != nil {
+		return 0, err
+	}
+	resp, err := a.post(ctx, "/v1/messages/count_tokens", body, params.AnthropicBeta)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var count struct {
+		InputTokens int `json:"input_tokens"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&count); err != nil {
+		return 0, fmt.Errorf("invalid count_tokens response: %w", err)
+	}
+	return count.InputTokens, nil
+}
+
+// post sends a request to the Anthropic API; responses other than 200 OK are
+// returned as anthropicAPIError.
+func (a *anthropicBackend) post(ctx context.Context, path string, body []byte, betas []string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("content-type", "application/json")
+	req.Header.Set("x-api-key", a.apiKey)
+	req.Header.Set("anthropic-version", anthropicAPIVersion)
+	if len(betas) > 0 {
+		req.Header.Set("anthropic-beta", strings.Join(betas, ","))
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, readAnthropicAPIError(resp)
+	}
+	return resp, nil
+}
+
+func readAnthropicAPIError(resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	var e struct {
+		Error anthropicAPIError `json:"error"`
+	}
+	if err := json.Unmarshal(data, &e); err != nil || e.Error.Type == "" {
+		e.Error = anthropicAPIError{Type: "http_error", Message: strings.TrimSpace(string(data))}
+	}
+	e.Error.StatusCode = resp.StatusCode
+	return &e.Error
+}
+
+// readServerSentEvents calls handle for each event of the stream until it returns
+// false or the stream ends; comments and retry fields are ignored.
+func readServerSentEvents(r io.Reader, handle func(event string, data []byte) bool) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	var event string
+	var data []byte
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if len(data) > 0 {
+				if !handle(event, data) {
+					return nil
+				}
+			}
+			event, data = "", nil
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			if len(data) > 0 {
+				data = append(data, '\n')
+			}
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(data) > 0 {
+		handle(event, data)
+	}
+	return nil
+}
+
+var bedrockModelVersionSuffix = regexp.MustCompile(`-v\d+(:\d+)?$`)
+
+// anthropicModelID returns the Anthropic API model id of a Bedrock model or
+// inference profile id, e.g. claude-sonnet-4-5-20250929 for
+// us.anthropic.claude-sonnet-4-5-20250929-v1:0.
+func anthropicModelID(id string) string {
+	id = strings.TrimPrefix(normalizeToModelID(id), "anthropic.")
+	return bedrockModelVersionSuffix.ReplaceAllString(id, "")
+}
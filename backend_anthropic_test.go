@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnthropicBackend(t *testing.T) {
+	var header http.Header
+	var body map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		data, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(data, &body)
+		if body["max_tokens"] == float64(0) {
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"type":"error","error":{"type":"rate_limit_error","message":"slow down"}}`)
+			return
+		}
+		w.Header().Set("content-type", "text/event-stream")
+		fmt.Fprint(w, "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"usage\":{\"input_tokens\":10}}}\n\n")
+		fmt.Fprint(w, ": keep-alive comment\n\nevent: ping\ndata: {\"type\": \"ping\"}\n\n")
+		fmt.Fprint(w, "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"Hi\"}}\n\n")
+		fmt.Fprint(w, "event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\",\"message\":\"Overloaded\"}}\n\n")
+	}))
+	defer server.Close()
+
+	t.Setenv(anthropicAPIKeyEnv, "secret")
+	t.Setenv(anthropicBaseURLEnv, server.URL+"/")
+	b, err := newBackend(providerAnthropic, apiConverse, nil) // the api is ignored
+	require.NoError(t, err)
+
+	params := NewAnthropicClaudeMessagesInferenceParameters()
+	params.MaxTokens = 100
+	params.AnthropicBeta = []string{"a-2025", "b-2026"}
+	params.Messages = []Message{{Role: MessageRoleUser, Content: []Content{{Type: MessageContentTypeText, Text: "Hello"}}}}
+	stream, err := b.Stream(context.Background(), modelRequest{ModelID: "us.anthropic.claude-sonnet-4-5-20250929-v1:0", Params: params})
+	require.NoError(t, err)
+	defer stream.Close()
+
+	var eventTypes []string
+	for e := range stream.Events() {
+		eventTypes = append(eventTypes, e.Type)
+	}
+	assert.Equal(t, []string{"message_start", "ping", "content_block_delta"}, eventTypes)
+	var apiErr *anthropicAPIError
+	require.ErrorAs(t, stream.Err(), &apiErr)
+	assert.Equal(t, "overloaded_error", apiErr.Type)
+
+	assert.Equal(t, "secret", header.Get("x-api-key"))
+	assert.Equal(t, anthropicAPIVersion, header.Get("anthropic-version"))
+	assert.Equal(t, "a-2025,b-2026", header.Get("anthropic-beta"))
+	assert.Equal(t, "claude-sonnet-4-5-20250929", body["model"])
+	assert.Equal(t, true, body["stream"])
+	assert.NotContains(t, body, "anthropic_version")
+	assert.NotContains(t, body, "anthropic_beta")
+
+	params.MaxTokens = 0
+	_, err = b.Stream(context.Background(), modelRequest{ModelID: "anthropic.claude-opus-4-8", Params: params})
+	require.True(t, errors.As(err, &apiErr))
+	assert.True(t, apiErr.Throttled())
+	assert.Equal(t, "anthropic api: rate_limit_error: slow down (status 429)", err.Error())
+}
+
+func TestAnthropicBackendWithoutAPIKey(t *testing.T) {
+	t.Setenv(anthropicAPIKeyEnv, "")
+	_, err := newBackend(providerAnthropic, apiInvoke, nil)
+	assert.ErrorContains(t, err, anthropicAPIKeyEnv)
+}
+
+func TestAnthropicModelID(t *testing.T) {
+	for id, want := range map[string]string{
+		"anthropic.claude-opus-4-8":                    "claude-opus-4-8",
+		"global.anthropic.claude-opus-4-6-v1":          "claude-opus-4-6",
+		"us.anthropic.claude-3-5-sonnet-20241022-v2:0": "claude-3-5-sonnet-20241022",
+		"claude-haiku-4-5":                             "claude-haiku-4-5",
+	} {
+		assert.Equal(t, want, anthropicModelID(id), id)
+	}
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/document"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+)
+
+// converseBackend uses the ConverseStream API. The Anthropic Messages API request
+// is converted to a Converse request; parameters Converse has no field for, e.g.
+// thinking or top_k, are passed as additional model request fields. The events of
+// the response are converted to Anthropic Messages API events.
+type converseBackend struct {
+	client *bedrockruntime.Client
+}
+
+func (c *converseBackend) Stream(ctx context.Context, request modelRequest) (responseStream, error) {
+	input, err := converseStreamInput(request)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := c.client.ConverseStream(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	stream := output.GetStream()
+	s := newEventStream(stream.Close)
+	go func() {
+		defer close(s.events)
+		converter := newConverseEventConverter()
+		for event := range stream.Events() {
+			for _, response := range converter.convert(event) {
+				if !s.send(response) {
+					return
+				}
+			}
+		}
+		for _, response := range converter.flush() {
+			if !s.send(response) {
+				return
+			}
+		}
+		s.err = stream.Err()
+	}()
+	return s, nil
+}
+
+// converseStreamInput converts a request in the format of the Anthropic Messages
+// API to a ConverseStream request.
+func converseStreamInput(request modelRequest) (*bedrockruntime.ConverseStreamInput, error) {
+	params := request.Params
+	input := &bedrockruntime.ConverseStreamInput{
+		ModelId: aws.String(request.ModelID),
+		InferenceConfig: &types.InferenceConfiguration{
+			MaxTokens: aws.Int32(int32(params.MaxTokens)), // #nosec G115 - max tokens are validated by the API
+		},
+	}
+	if params.Temperature != nil {
+		input.InferenceConfig.Temperature = aws.Float32(float32(*params.Temperature))
+	}
+	if params.TopP != nil {
+		input.InferenceConfig.TopP = aws.Float32(float32(*params.TopP))
+	}
+	if len(params.StopSequences) > 0 {
+		input.InferenceConfig.StopSequences = params.StopSequences
+	}
+	if request.Latency != "" {
+		input.PerformanceConfig = &types.PerformanceConfiguration{Latency: request.Latency}
+	}
+	if g := request.Guardrail; g != nil {
+		input.GuardrailConfig = &types.GuardrailStreamConfiguration{
+			GuardrailIdentifier: aws.String(g.ID),
+			GuardrailVersion:    aws.String(g.Version),
+			Trace:               types.GuardrailTraceEnabled,
+		}
+	}
+
+	if params.System.Text != "" {
+		input.System = []types.SystemContentBlock{&types.SystemContentBlockMemberText{Value: params.System.Text}}
+		if cc := params.System.CacheControl; cc != nil {
+			input.System = append(input.System, &types.SystemContentBlockMemberCachePoint{Value: converseCachePoint(cc)})
+		}
+	}
+
+	documents := 0 // document names must be unique within the request
+	for _, m := range params.Messages {
+		message, err := converseMessage(m, &documents)
+		if err != nil {
+			return nil, err
+		}
+		if len(message.Content) > 0 {
+			input.Messages = append(input.Messages, message)
+		}
+	}
+
+	// Converse has no Anthropic-defined tools, all tools are passed with their input schema
+	var tools []types.Tool
+	for _, t := range toolRegistry.Specs(request.ModelID) {
+		var schema any
+		if err := json.Unmarshal(t.InputSchema, &schema); err != nil {
+			return nil, fmt.Errorf("invalid input schema of tool %s: %w", t.Name, err)
+		}
+		spec := types.ToolSpecification{
+			Name:        aws.String(t.Name),
+			InputSchema: &types.ToolInputSchemaMemberJson{Value: document.NewLazyDocument(schema)},
+		}
+		if t.Description != "" {
+			spec.Description = aws.String(t.Description)
+		}
+		tools = append(tools, &types.ToolMemberToolSpec{Value: spec})
+	}
+	if n := len(params.Tools); n > 0 && len(tools) > 0 {
+		if def, ok := params.Tools[n-1].(ToolDefinition); ok && def.CacheControl != nil {
+			tools = append(tools, &types.ToolMemberCachePoint{Value: converseCachePoint(def.CacheControl)})
+		}
+	}
+	if len(tools) > 0 {
+		input.ToolConfig = &types.ToolConfiguration{Tools: tools}
+		if choice := params.ToolChoice; choice != nil && choice.Type == ToolChoiceTool {
+			input.ToolConfig.ToolChoice = &types.ToolChoiceMemberTool{Value: types.SpecificToolChoice{Name: aws.String(choice.Name)}}
+		}
+	}
+
+	additionalFields := make(map[string]any)
+	if params.TopK > 0 {
+		additionalFields["top_k"] = params.TopK
+	}
+	if params.Thinking != nil {
+		additionalFields["thinking"] = params.Thinking
+	}
+	if oc := params.OutputConfig; oc != nil {
+		if oc.Effort != "" {
+			additionalFields["output_config"] = OutputConfig{Effort: oc.Effort}
+		}
+		if oc.Format != nil { // structured outputs are part of Converse
+			input.OutputConfig = &types.OutputConfig{TextFormat: &types.OutputFormat{
+				Type: types.OutputFormatTypeJsonSchema,
+				Structure: &types.OutputFormatStructureMemberJsonSchema{Value: types.JsonSchemaDefinition{
+					Schema: aws.String(string(oc.Format.Schema)),
+					Name:   aws.String(schemaToolName),
+				}},
+			}}
+		}
+	}
+	if len(params.AnthropicBeta) > 0 {
+		additionalFields["anthropic_beta"] = params.AnthropicBeta
+	}
+	if len(additionalFields) > 0 {
+		input.AdditionalModelRequestFields = document.NewLazyDocument(additionalFields)
+	}
+
+	return input, nil
+}
+
+// converseMessage converts a message to a Converse message. Blocks with cache_control
+// are followed by a cache point; empty text blocks are left out. Documents are named
+// by the number of documents of the request so far, counted in documents.
+func converseMessage(m Message, documents *int) (types.Message, error) {
+	message := types.Message{Role: types.ConversationRole(m.Role)}
+	for _, c := range m.Content {
+		var block types.ContentBlock
+		switch c.Type {
+		case MessageContentTypeText:
+			if strings.TrimSpace(c.Text) == "" {
+				continue
+			}
+			block = &types.ContentBlockMemberText{Value: c.Text}
+
+		case MessageContentTypeImage, MessageContentTypeDocument:
+			if c.Source == nil {
+				return message, fmt.Errorf("%s content without source", c.Type)
+			}
+			data, err := base64.StdEncoding.DecodeString(c.Source.Data)
+			if err != nil {
+				return message, fmt.Errorf("invalid base64 data of %s content: %w", c.Type, err)
+			}
+			format := c.Source.MediaType[strings.LastIndex(c.Source.MediaType, "/")+1:] // e.g. image/png
+			if c.Type == MessageContentTypeImage {
+				block = &types.ContentBlockMemberImage{Value: types.ImageBlock{
+					Format: types.ImageFormat(format),
+					Source: &types.ImageSourceMemberBytes{Value: data},
+				}}
+			} else {
+				*documents++
+				block = &types.ContentBlockMemberDocument{Value: types.DocumentBlock{
+					Name:   aws.String(fmt.Sprintf("document-%d", *documents)),
+					Format: types.DocumentFormat(format),
+					Source: &types.DocumentSourceMemberBytes{Value: data},
+				}}
+			}
+
+		case MessageContentTypeToolUse:
+			var input any = map[string]any{}
+			if len(c.Input) > 0 {
+				if err := json.Unmarshal(c.Input, &input); err != nil {
+					return message, fmt.Errorf("invalid input of tool_use %s: %w", c.ID, err)
+				}
+			}
+			block = &types.ContentBlockMemberToolUse{Value: types.ToolUseBlock{
+				ToolUseId: aws.String(c.ID),
+				Name:      aws.String(c.Name),
+				Input:     document.NewLazyDocument(input),
+			}}
+
+		case MessageContentTypeToolResult:
+			result := types.ToolResultBlock{ToolUseId: aws.String(c.ToolUseID), Status: types.ToolResultStatusSuccess}
+			if c.Content != "" {
+				result.Content = []types.ToolResultContentBlock{&types.ToolResultContentBlockMemberText{Value: c.Content}}
+			}
+			if c.IsError {
+				result.Status = types.ToolResultStatusError
+			}
+			block = &types.ContentBlockMemberToolResult{Value: result}
+
+		case MessageContentTypeThinking:
+			block = &types.ContentBlockMemberReasoningContent{Value: &types.ReasoningContentBlockMemberReasoningText{
+				Value: types.ReasoningTextBlock{Text: aws.String(c.Thinking), Signature: aws.String(c.Signature)},
+			}}
+
+		default:
+			return message, fmt.Errorf("content type '%s' is not supported by the converse api", c.Type)
+		}
+
+		message.Content = append(message.Content, block)
+		if c.CacheControl != nil {
+			message.Content = append(message.Content, &types.ContentBlockMemberCachePoint{Value: converseCachePoint(c.CacheControl)})
+		}
+	}
+	return message, nil
+}
+
+// converseCachePoint converts cache_control to a Converse cache point.
+func converseCachePoint(cc *CacheControl) types.CachePointBlock {
+	return types.CachePointBlock{Type: types.CachePointTypeDefault, Ttl: types.CacheTTL(cc.TTL)}
+}
+
+// converseEventConverter converts ConverseStream events to Anthropic Messages API
+// events. Converse starts text and reasoning blocks implicitly with their first
+// delta and reports the usage in a metadata event after the message stop, so the
+// message_delta and message_stop events are sent when the metadata arrives.
+type converseEventConverter struct {
+	started    map[int32]bool // content block indexes
+	stopReason string
+	stopped    bool
+}
+
+func newConverseEventConverter() *converseEventConverter {
+	return &converseEventConverter{started: make(map[int32]bool)}
+}
+
+func (c *converseEventConverter) convert(event types.ConverseStreamOutput) []AnthropicClaudeMessagesResponse {
+	switch e := event.(type) {
+	case *types.ConverseStreamOutputMemberMessageStart:
+		return []AnthropicClaudeMessagesResponse{{
+			Type:    EventMessageStart.String(),
+			Message: &ResponseMessage{Role: string(e.Value.Role), Type: "message"},
+		}}
+
+	case *types.ConverseStreamOutputMemberContentBlockStart:
+		index := aws.ToInt32(e.Value.ContentBlockIndex)
+		if toolUse, ok := e.Value.Start.(*types.ContentBlockStartMemberToolUse); ok {
+			c.started[index] = true
+			return []AnthropicClaudeMessagesResponse{{
+				Type:  EventContentBlockStart.String(),
+				Index: int(index),
+				ContentBlock: &ResponseContentBlock{
+					Type: MessageContentTypeToolUse,
+					ID:   aws.ToString(toolUse.Value.ToolUseId),
+					Name: aws.ToString(toolUse.Value.Name),
+				},
+			}}
+		}
+		logger.Printf("converse: ignoring content block start %T\n", e.Value.Start)
+
+	case *types.ConverseStreamOutputMemberContentBlockDelta:
+		index := aws.ToInt32(e.Value.ContentBlockIndex)
+		switch delta := e.Value.Delta.(type) {
+		case *types.ContentBlockDeltaMemberText:
+			return c.delta(index, MessageContentTypeText, ResponseDelta{Type: "text_delta", Text: delta.Value})
+		case *types.ContentBlockDeltaMemberToolUse:
+			return c.delta(index, MessageContentTypeToolUse, ResponseDelta{Type: "input_json_delta", PartialJSON: aws.ToString(delta.Value.Input)})
+		case *types.ContentBlockDeltaMemberReasoningContent:
+			switch reasoning := delta.Value.(type) {
+			case *types.ReasoningContentBlockDeltaMemberText:
+				return c.delta(index, MessageContentTypeThinking, ResponseDelta{Type: "thinking_delta", Thinking: reasoning.Value})
+			case *types.ReasoningContentBlockDeltaMemberSignature:
+				return c.delta(index, MessageContentTypeThinking, ResponseDelta{Type: "signature_delta", Signature: reasoning.Value})
+			default:
+				logger.Printf("converse: ignoring reasoning delta %T\n", reasoning)
+			}
+		default:
+			logger.Printf("converse: ignoring content block delta %T\n", delta)
+		}
+
+	case *types.ConverseStreamOutputMemberContentBlockStop:
+		return []AnthropicClaudeMessagesResponse{{
+			Type:  EventContentBlockStop.String(),
+			Index: int(aws.ToInt32(e.Value.ContentBlockIndex)),
+		}}
+
+	case *types.ConverseStreamOutputMemberMessageStop:
+		c.stopReason = string(e.Value.StopReason)
+		c.stopped = true
+
+	case *types.ConverseStreamOutputMemberMetadata:
+		usage := &ResponseUsage{}
+		metrics := &InvocationMetrics{}
+		if u := e.Value.Usage; u != nil {
+			usage.InputTokens = int(aws.ToInt32(u.InputTokens))
+			usage.OutputTokens = int(aws.ToInt32(u.OutputTokens))
+			usage.CacheReadInputTokens = int(aws.ToInt32(u.CacheReadInputTokens))
+			usage.CacheCreationInputTokens = int(aws.ToInt32(u.CacheWriteInputTokens))
+			metrics.InputTokenCount, metrics.OutputTokenCount = usage.InputTokens, usage.OutputTokens
+		}
+		if m := e.Value.Metrics; m != nil {
+			metrics.InvocationLatency = int(aws.ToInt64(m.LatencyMs))
+		}
+		var trace json.RawMessage
+		if t := e.Value.Trace; t != nil && t.Guardrail != nil {
+			trace, _ = json.Marshal(t)
+		}
+		return c.stop(usage, metrics, trace)
+
+	default:
+		logger.Printf("converse: ignoring stream event %T\n", event)
+	}
+	return nil
+}
+
+// delta returns a content_block_delta event, preceded by a content_block_start
+// event if it is the first delta of the block.
+func (c *converseEventConverter) delta(index int32, blockType string, delta ResponseDelta) []AnthropicClaudeMessagesResponse {
+	var events []AnthropicClaudeMessagesResponse
+	if !c.started[index] {
+		c.started[index] = true
+		events = append(events, AnthropicClaudeMessagesResponse{
+			Type:         EventContentBlockStart.String(),
+			Index:        int(index),
+			ContentBlock: &ResponseContentBlock{Type: blockType},
+		})
+	}
+	return append(events, AnthropicClaudeMessagesResponse{Type: EventContentBlockDelta.String(), Index: int(index), Delta: &delta})
+}
+
+// stop returns the message_delta and message_stop events; a guardrail intervention
+// is reported with the message_stop event as by InvokeModel.
+func (c *converseEventConverter) stop(usage *ResponseUsage, metrics *InvocationMetrics, trace json.RawMessage) []AnthropicClaudeMessagesResponse {
+	if !c.stopped {
+		return nil
+	}
+	c.stopped = false
+	messageStop := AnthropicClaudeMessagesResponse{Type: EventMessageStop.String(), AmazonBedrockInvocationMetrics: metrics}
+	if c.stopReason == string(types.StopReasonGuardrailIntervened) {
+		messageStop.AmazonBedrockGuardrailAction = guardrailActionIntervened
+		messageStop.AmazonBedrockTrace = trace
+	}
+	return []AnthropicClaudeMessagesResponse{
+		{Type: EventMessageDelta.String(), Delta: &ResponseDelta{StopReason: c.stopReason}, Usage: usage},
+		messageStop,
+	}
+}
+
+// flush returns the message_delta and message_stop events if the stream ended
+// without metadata.
+func (c *converseEventConverter) flush() []AnthropicClaudeMessagesResponse {
+	return c.stop(&ResponseUsage{}, &InvocationMetrics{}, nil)
+}
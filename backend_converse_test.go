@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConverseMessage(t *testing.T) {
+	var documents int
+	message, err := converseMessage(Message{
+		Role: MessageRoleAssistant,
+		Content: []Content{
+			{Type: MessageContentTypeThinking, Thinking: "hmm", Signature: "sig"},
+			{Type: MessageContentTypeText, Text: " "}, // empty block of a streamed response
+			{Type: MessageContentTypeText, Text: "long document", CacheControl: &CacheControl{Type: "ephemeral", TTL: cacheTTL1h}},
+			{Type: MessageContentTypeImage, Source: &Source{Type: "base64", MediaType: MessageContentTypeMediaTypePNG, Data: "aGk="}},
+			{Type: MessageContentTypeToolUse, ID: "toolu_1", Name: "bash", Input: json.RawMessage(`{"command":"ls"}`)},
+		},
+	}, &documents)
+	require.NoError(t, err)
+	assert.Equal(t, types.ConversationRoleAssistant, message.Role)
+	require.Len(t, message.Content, 5)
+
+	reasoning := message.Content[0].(*types.ContentBlockMemberReasoningContent).Value.(*types.ReasoningContentBlockMemberReasoningText)
+	assert.Equal(t, "sig", aws.ToString(reasoning.Value.Signature))
+	assert.Equal(t, "long document", message.Content[1].(*types.ContentBlockMemberText).Value)
+	assert.Equal(t, types.CacheTTLOneHour, message.Content[2].(*types.ContentBlockMemberCachePoint).Value.Ttl)
+	image := message.Content[3].(*types.ContentBlockMemberImage).Value
+	assert.Equal(t, types.ImageFormatPng, image.Format)
+	assert.Equal(t, []byte("hi"), image.Source.(*types.ImageSourceMemberBytes).Value)
+	assert.Equal(t, "bash", aws.ToString(message.Content[4].(*types.ContentBlockMemberToolUse).Value.Name))
+
+	message, err = converseMessage(Message{
+		Role:    MessageRoleUser,
+		Content: []Content{{Type: MessageContentTypeToolResult, ToolUseID: "toolu_1", Content: "denied", IsError: true}},
+	}, &documents)
+	require.NoError(t, err)
+	result := message.Content[0].(*types.ContentBlockMemberToolResult).Value
+	assert.Equal(t, types.ToolResultStatusError, result.Status)
+	assert.Equal(t, "toolu_1", aws.ToString(result.ToolUseId))
+}
+
+func TestConverseDocumentNames(t *testing.T) {
+	pdf := func(text string) Message {
+		return Message{Role: MessageRoleUser, Content: []Content{
+			{Type: MessageContentTypeDocument, Source: &Source{Type: "base64", MediaType: MessageContentTypeMediaTypePDF, Data: "aGk="}},
+			{Type: MessageContentTypeText, Text: text},
+		}}
+	}
+	params := &AnthropicClaudeMessagesInferenceParameters{MaxTokens: 100, Messages: []Message{
+		pdf("summarize"),
+		{Role: MessageRoleAssistant, Content: []Content{{Type: MessageContentTypeText, Text: "a summary"}}},
+		pdf("compare"),
+	}}
+	input, err := converseStreamInput(modelRequest{ModelID: "anthropic.claude-sonnet-4-6", Params: params})
+	require.NoError(t, err)
+	require.Len(t, input.Messages, 3)
+	first := input.Messages[0].Content[0].(*types.ContentBlockMemberDocument).Value
+	second := input.Messages[2].Content[0].(*types.ContentBlockMemberDocument).Value
+	assert.Equal(t, "document-1", aws.ToString(first.Name))
+	assert.Equal(t, "document-2", aws.ToString(second.Name), "names are unique within the request")
+}
+
+func TestConverseEventConverter(t *testing.T) {
+	c := newConverseEventConverter()
+	var events []AnthropicClaudeMessagesResponse
+	for _, e := range []types.ConverseStreamOutput{
+		&types.ConverseStreamOutputMemberMessageStart{Value: types.MessageStartEvent{Role: types.ConversationRoleAssistant}},
+		&types.ConverseStreamOutputMemberContentBlockDelta{Value: types.ContentBlockDeltaEvent{
+			ContentBlockIndex: aws.Int32(0), Delta: &types.ContentBlockDeltaMemberText{Value: "Hel"},
+		}},
+		&types.ConverseStreamOutputMemberContentBlockDelta{Value: types.ContentBlockDeltaEvent{
+			ContentBlockIndex: aws.Int32(0), Delta: &types.ContentBlockDeltaMemberText{Value: "lo"},
+		}},
+		&types.ConverseStreamOutputMemberContentBlockStop{Value: types.ContentBlockStopEvent{ContentBlockIndex: aws.Int32(0)}},
+		&types.ConverseStreamOutputMemberContentBlockStart{Value: types.ContentBlockStartEvent{
+			ContentBlockIndex: aws.Int32(1),
+			Start:             &types.ContentBlockStartMemberToolUse{Value: types.ToolUseBlockStart{ToolUseId: aws.String("tooluse_1"), Name: aws.String("bash")}},
+		}},
+		&types.ConverseStreamOutputMemberContentBlockDelta{Value: types.ContentBlockDeltaEvent{
+			ContentBlockIndex: aws.Int32(1), Delta: &types.ContentBlockDeltaMemberToolUse{Value: types.ToolUseBlockDelta{Input: aws.String(`{"command":"ls"}`)}},
+		}},
+		&types.ConverseStreamOutputMemberContentBlockStop{Value: types.ContentBlockStopEvent{ContentBlockIndex: aws.Int32(1)}},
+		&types.ConverseStreamOutputMemberMessageStop{Value: types.MessageStopEvent{StopReason: types.StopReasonToolUse}},
+		&types.ConverseStreamOutputMemberMetadata{Value: types.ConverseStreamMetadataEvent{
+			Usage: &types.TokenUsage{InputTokens: aws.Int32(12), OutputTokens: aws.Int32(8)},
+		}},
+	} {
+		events = append(events, c.convert(e)...)
+	}
+	assert.Empty(t, c.flush())
+
+	var eventTypes []string
+	for _, e := range events {
+		eventTypes = append(eventTypes, e.Type)
+	}
+	assert.Equal(t, []string{
+		"message_start",
+		"content_block_start", "content_block_delta", "content_block_delta", "content_block_stop",
+		"content_block_start", "content_block_delta", "content_block_stop",
+		"message_delta", "message_stop",
+	}, eventTypes)
+
+	assert.Equal(t, MessageContentTypeText, events[1].ContentBlock.Type)
+	assert.Equal(t, "lo", events[3].Delta.Text)
+	assert.Equal(t, "tooluse_1", events[5].ContentBlock.ID)
+	assert.Equal(t, `{"command":"ls"}`, events[6].Delta.PartialJSON)
+	assert.Equal(t, MessageContentTypeToolUse, events[8].Delta.StopReason)
+	assert.Equal(t, ResponseUsage{InputTokens: 12, OutputTokens: 8}, *events[8].Usage)
+}
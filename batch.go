@@ -0,0 +1,617 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const batchManifestFile = "manifest.json"
+
+// Status of an input of a batch, see batchItem
+const (
+	batchStatusPending   = "pending" // not run yet, e.g. the batch was interrupted
+	batchStatusOK        = "ok"
+	batchStatusFailed    = "failed"
+	batchStatusSubmitted = "submitted" // a record of a batch inference job, see bods batch submit
+)
+
+var (
+	batchInputs      []string
+	batchOut         string
+	batchConcurrency int
+	batchRPM         int
+	batchResume      bool
+
+	batchCmd = &cobra.Command{
+		Use:   "batch [prompt]",
+		Short: "Run a prompt over many input files, one model invocation per file",
+		Long: `Build a request for every input file like 'cat <file> | bods [prompt]' does and
+invoke the model for several files concurrently with one client. The response to
+each input is written to a file in the --out directory, together with a
+manifest.json of the status, usage and cost of every input. Throttled requests are
+retried with backoff (see --retries); run the batch again with --resume to retry
+the failed inputs.`,
+		Example: `  bods batch -p summarize --inputs 'docs/*.pdf' --concurrency 4 --out results/
+  bods batch --resume --out results/`,
+		Args: cobra.ArbitraryArgs,
+		RunE: func(_ *cobra.Command, args []string) error {
+			config.Prefix = strings.Join(args, " ")
+			if config.DryRun {
+				return bodsError{errors.New("--dry-run is not supported by bods batch"), "Invalid batch."}
+			}
+			if config.VariableInputRaw != "" { // the values of {{.VAR}} in the user prompt of the template
+				inputs, err := parseVarMap(config.VariableInputRaw)
+				if err != nil {
+					return bodsError{err, "Input variables parsing failed."}
+				}
+				config.UserPromptInputs = inputs
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+			defer stop()
+			return runBatch(ctx, &config, batchOptions{
+				Inputs:      batchInputs,
+				Out:         batchOut,
+				Concurrency: batchConcurrency,
+				RPM:         batchRPM,
+				Resume:      batchResume,
+			})
+		},
+	}
+)
+
+func initBatchCommands() {
+	batchCmd.PersistentFlags().StringSliceVar(&batchInputs, "inputs", nil, "Input files as glob patterns, e.g. 'docs/*.pdf' (repeatable); with --resume the inputs of the manifest")
+	batchCmd.PersistentFlags().StringVar(&batchOut, "out", "", "Directory for the responses and the manifest.json")
+	batchCmd.Flags().IntVar(&batchConcurrency, "concurrency", 4, "Number of inputs processed at the same time")
+	batchCmd.Flags().IntVar(&batchRPM, "rpm", 0, "Maximum model invocations per minute, 0 means no limit")
+	batchCmd.Flags().BoolVar(&batchResume, "resume", false, "Retry the inputs of the manifest in --out that failed or did not run")
+	_ = batchCmd.MarkPersistentFlagDirname("out")
+	initBatchJobCommands()
+	rootCmd.AddCommand(batchCmd)
+}
+
+// batchOptions are the flags of bods batch.
+type batchOptions struct {
+	Inputs      []string // glob patterns
+	Out         string
+	Concurrency int
+	RPM         int // model invocations per minute, 0 for no limit
+	Resume      bool
+}
+
+// batchManifest is the manifest.json of a batch in the --out directory.
+type batchManifest struct {
+	Prompt   string       `json:"prompt,omitempty"` // the prompt template
+	ModelID  string       `json:"model_id"`
+	Started  time.Time    `json:"started"`
+	Finished time.Time    `json:"finished,omitzero"`
+	Usage    Usage        `json:"usage"`         // of the results of all inputs, also of earlier runs with --resume
+	Cost     float64      `json:"cost"`          // estimated, in USD
+	Job      *batchJob    `json:"job,omitempty"` // the batch inference job, see bods batch submit
+	Items    []*batchItem `json:"items"`
+}
+
+// batchItem is an input file of a batch and the result of its model invocation.
+type batchItem struct {
+	Input         string   `json:"input"`
+	Output        string   `json:"output"` // file in the --out directory
+	Status        string   `json:"status"`
+	RecordID      string   `json:"record_id,omitempty"` // in the batch inference job
+	Error         string   `json:"error,omitempty"`
+	StopReason    string   `json:"stop_reason,omitempty"`
+	Usage         Usage    `json:"usage"`
+	LatencyMillis int64    `json:"latency_ms,omitempty"`
+	Cost          *float64 `json:"cost,omitempty"` // nil if the model has no price
+}
+
+// batchResponse is a response collected from the stream without the user interface.
+type batchResponse struct {
+	Message    Message
+	Usage      Usage
+	StopReason string
+	TTFB       time.Duration
+	Metrics    *InvocationMetrics
+}
+
+// batchRun is the state shared by the workers of a batch.
+type batchRun struct {
+	b        *Bods
+	opts     batchOptions
+	manifest *batchManifest
+	total    int              // inputs to run
+	limiter  <-chan time.Time // nil without a rate limit, see --rpm
+
+	buildMu sync.Mutex // buildRequest uses the global messages and parameters
+
+	mu     sync.Mutex // guards the fields below and the manifest
+	done   int
+	failed int
+	rounds []roundUsage
+}
+
+// runBatch runs the prompt over the input files and writes the responses and the
+// manifest to the out directory.
+func runBatch(ctx context.Context, cfg *Config, opts batchOptions) error {
+	manifestPath := filepath.Join(opts.Out, batchManifestFile)
+	var previous *batchManifest
+	if opts.Resume {
+		var err error
+		if previous, err = readBatchManifest(manifestPath); err != nil {
+			return bodsError{err, "Could not read the manifest of the batch to resume."}
+		}
+	}
+	if opts.Concurrency < 1 {
+		return bodsError{fmt.Errorf("--concurrency must be at least 1, got %d", opts.Concurrency), "Invalid batch."}
+	}
+
+	b, manifest, pending, err := prepareBatch(ctx, cfg, opts, previous)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(opts.Out, 0o755); err != nil {
+		return bodsError{err, "Could not create the output directory."}
+	}
+	r := &batchRun{b: b, opts: opts, manifest: manifest, total: len(pending)}
+	if opts.RPM > 0 {
+		ticker := time.NewTicker(time.Minute / time.Duration(opts.RPM))
+		defer ticker.Stop()
+		r.limiter = ticker.C
+	}
+
+	s := stderrStyles()
+	_, _ = fmt.Fprintf(os.Stderr, "%s\n", s.Comment.Render(fmt.Sprintf("%s: %d input(s), %d to run, writing to %s",
+		shortModelName(b.Config.ModelID), len(manifest.Items), len(pending), opts.Out)))
+
+	jobs := make(chan *batchItem)
+	var wg sync.WaitGroup
+	for range min(opts.Concurrency, max(len(pending), 1)) {
+		wg.Go(func() {
+			for item := range jobs {
+				r.run(ctx, item)
+			}
+		})
+	}
+queue:
+	for _, item := range pending {
+		select {
+		case jobs <- item:
+		case <-ctx.Done():
+			break queue
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err := recordUsage(cfg, r.rounds); err != nil {
+		logger.Println("could not record usage:", err)
+	}
+	manifest.Finished = time.Now()
+	if err := r.writeManifest(); err != nil {
+		return bodsError{err, "Could not write the manifest of the batch."}
+	}
+
+	_, _ = fmt.Fprintf(os.Stderr, "\n%s\n", s.ConversationList.Render(r.summary()))
+	switch {
+	case ctx.Err() != nil:
+		return bodsError{errContextCanceled, "The batch was interrupted. Run it again with --resume to process the remaining inputs."}
+	case r.failed > 0:
+		return bodsError{fmt.Errorf("%d of %d inputs failed, see %s", r.failed, r.total, manifestPath),
+			"The batch is incomplete. Run it again with --resume to retry the failed inputs."}
+	}
+	return nil
+}
+
+// prepareBatch validates the configuration of a batch, once instead of for every
+// input, and returns the manifest with an item for every input and the items to
+// run; inputs with a response in the previous manifest are not run again.
+func prepareBatch(ctx context.Context, cfg *Config, opts batchOptions, previous *batchManifest) (*Bods, *batchManifest, []*batchItem, error) {
+	if opts.Out == "" {
+		return nil, nil, nil, bodsError{errors.New("no output directory given"), "Set the output directory with --out, e.g. --out results/."}
+	}
+	if previous != nil {
+		if len(opts.Inputs) == 0 {
+			for _, item := range previous.Items {
+				opts.Inputs = append(opts.Inputs, item.Input)
+			}
+		}
+		if cfg.PromptTemplate == "" {
+			cfg.PromptTemplate = previous.Prompt
+		}
+	}
+	if len(opts.Inputs) == 0 {
+		return nil, nil, nil, bodsError{errors.New("no inputs given"), "Set the input files with --inputs, e.g. --inputs 'docs/*.pdf'."}
+	}
+
+	inputs, err := expandBatchInputs(opts.Inputs)
+	if err != nil {
+		return nil, nil, nil, bodsError{err, "Invalid batch inputs."}
+	}
+	if err := checkTemplateInputs(cfg); err != nil {
+		return nil, nil, nil, bodsError{err, "Set the input variables of the prompt template with --variable-input."}
+	}
+
+	b := initialBodsModel(stderrRenderer(), cfg)
+	b.context = &ctx
+	if err := b.initBedrockClients(); err != nil {
+		return nil, nil, nil, bodsError{err, "Could not load the AWS configuration."}
+	}
+	messages = []Message{{Role: MessageRoleUser}}
+	if _, err := b.configureInferenceParameters(); err != nil {
+		return nil, nil, nil, err
+	}
+	if b.Config.EnableBash || b.Config.EnableTextEditor {
+		return nil, nil, nil, bodsError{errors.New("tools like --bash and --text-editor are not supported by bods batch"), "Invalid batch."}
+	}
+
+	ext := ".md"
+	if b.schema != nil {
+		ext = ".json"
+	}
+	names := batchOutputNames(inputs, ext)
+	manifest := &batchManifest{Prompt: cfg.PromptTemplate, ModelID: b.Config.ModelID, Started: time.Now()}
+	if previous != nil {
+		manifest.Job = previous.Job
+	}
+	var pending []*batchItem
+	for _, input := range inputs {
+		item := &batchItem{Input: input, Output: names[input], Status: batchStatusPending}
+		if prev := previous.item(input); prev != nil && prev.Status == batchStatusOK {
+			if _, err := os.Stat(filepath.Join(opts.Out, prev.Output)); err == nil {
+				item = prev // done in an earlier run
+			}
+		}
+		manifest.Items = append(manifest.Items, item)
+		if item.Status != batchStatusOK {
+			pending = append(pending, item)
+		}
+	}
+	return b, manifest, pending, nil
+}
+
+// run builds the request for an input, invokes the model and writes the response
+// to the output file of the input.
+func (r *batchRun) run(ctx context.Context, item *batchItem) {
+	if ctx.Err() != nil {
+		return // interrupted, the input stays pending
+	}
+	data, err := os.ReadFile(item.Input)
+	if err != nil {
+		r.finish(item, nil, err)
+		return
+	}
+
+	r.buildMu.Lock()
+	messages = []Message{{Role: MessageRoleUser}}
+	request, err := r.b.buildRequest(string(data))
+	backend, schema := r.b.backend, r.b.schema
+	r.buildMu.Unlock()
+	if err != nil {
+		r.finish(item, nil, err)
+		return
+	}
+
+	if r.limiter != nil {
+		select {
+		case <-r.limiter:
+		case <-ctx.Done():
+			return
+		}
+	}
+	stream, start, err := newInvoker(backend, r.b.Config).Stream(ctx, request)
+	if err != nil {
+		r.finish(item, nil, err)
+		return
+	}
+	response, err := collectResponse(ctx, stream, start)
+	round := &roundUsage{
+		ModelID: request.ModelID,
+		Usage:   response.Usage,
+		TTFB:    response.TTFB,
+		Latency: time.Since(start),
+		Metrics: response.Metrics,
+		start:   start,
+	}
+	if err != nil {
+		r.finish(item, round, err)
+		return
+	}
+	item.StopReason = response.StopReason
+
+	output := responseText(response.Message) + "\n"
+	if schema != nil {
+		structured, _ := structuredOutput(response.Message)
+		if violations := schema.Validate(structured); len(violations) > 0 {
+			r.finish(item, round, fmt.Errorf("the response does not match the schema: %s", strings.Join(violations, "; ")))
+			return
+		}
+		output = string(structured) + "\n"
+	}
+	err = os.WriteFile(filepath.Join(r.opts.Out, item.Output), []byte(output), 0o600)
+	r.finish(item, round, err)
+}
+
+// finish records the result of an input, writes the manifest and prints the progress.
+func (r *batchRun) finish(item *batchItem, round *roundUsage, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	item.Status, item.Error = batchStatusOK, ""
+	if err != nil {
+		item.Status, item.Error = batchStatusFailed, err.Error()
+		r.failed++
+	}
+	if round != nil {
+		r.rounds = append(r.rounds, *round)
+		r.manifest.ModelID = round.ModelID // e.g. the inference profile
+		item.Usage = round.Usage
+		item.LatencyMillis = round.Latency.Milliseconds()
+		item.Cost = nil
+		if cost, ok := round.Cost(); ok {
+			item.Cost = &cost
+		}
+	}
+	r.done++
+	if err := r.writeManifest(); err != nil {
+		logger.Println("could not write the batch manifest:", err)
+	}
+
+	s := stderrStyles()
+	progress := s.Comment.Render(fmt.Sprintf("[%d/%d]", r.done, r.total))
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "%s %s %s %s\n", progress, item.Input, s.ErrorHeader.SetString("FAILED").String(), s.Comment.Render(err.Error()))
+		return
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "%s %s → %s %s\n", progress, item.Input, item.Output,
+		s.Comment.Render(formatSeconds(time.Duration(item.LatencyMillis)*time.Millisecond)))
+}
+
+// writeManifest writes the manifest to the out directory of the batch.
+func (r *batchRun) writeManifest() error {
+	return writeBatchManifest(r.opts.Out, r.manifest)
+}
+
+// summary returns the counts of the inputs and the usage of this run.
+func (r *batchRun) summary() string {
+	var usage Usage
+	var cost float64
+	for _, round := range r.rounds {
+		usage.Add(round.Usage)
+		if c, ok := round.Cost(); ok {
+			cost += c
+		}
+	}
+	skipped := len(r.manifest.Items) - r.total
+	text := fmt.Sprintf("%d succeeded, %d failed", r.done-r.failed, r.failed)
+	if pending := r.total - r.done; pending > 0 {
+		text += fmt.Sprintf(", %d pending", pending)
+	}
+	if skipped > 0 {
+		text += fmt.Sprintf(", %d done before", skipped)
+	}
+	return text + fmt.Sprintf(" · %s input, %s output tokens · $%.4f · %s",
+		formatTokens(usage.InputTokens+usage.CacheReadInputTokens+usage.CacheWriteInputTokens),
+		formatTokens(usage.OutputTokens), cost, filepath.Join(r.opts.Out, batchManifestFile))
+}
+
+// item returns the item of the input, nil if there is none or no manifest.
+func (m *batchManifest) item(input string) *batchItem {
+	if m == nil {
+		return nil
+	}
+	for _, item := range m.Items {
+		if item.Input == input {
+			return item
+		}
+	}
+	return nil
+}
+
+// writeBatchManifest writes the manifest with the usage and cost of all inputs to
+// the out directory; the manifest is replaced, not written in place, to not leave a
+// partial file behind.
+func writeBatchManifest(out string, m *batchManifest) error {
+	m.Usage, m.Cost = Usage{}, 0
+	for _, item := range m.Items {
+		m.Usage.Add(item.Usage)
+		if item.Cost != nil {
+			m.Cost += *item.Cost
+		}
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(out, batchManifestFile)
+	if err := os.WriteFile(path+".tmp", data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func readBatchManifest(path string) (*batchManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m batchManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &m, nil
+}
+
+// expandBatchInputs returns the files matching the glob patterns, sorted and
+// without duplicates; directories are skipped.
+func expandBatchInputs(patterns []string) ([]string, error) {
+	var inputs []string
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no input files match '%s'", pattern)
+		}
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && !info.IsDir() {
+				inputs = append(inputs, filepath.Clean(match))
+			}
+		}
+	}
+	slices.Sort(inputs)
+	return slices.Compact(inputs), nil
+}
+
+// batchOutputNames maps the inputs to the names of their output files: the base
+// name with the extension ext, e.g. report.pdf.md, or the path with the separators
+// replaced if inputs in different directories have the same base name.
+func batchOutputNames(inputs []string, ext string) map[string]string {
+	count := make(map[string]int)
+	for _, input := range inputs {
+		count[filepath.Base(input)]++
+	}
+	names := make(map[string]string, len(inputs))
+	for _, input := range inputs {
+		name := filepath.Base(input)
+		if count[name] > 1 {
+			name = strings.ReplaceAll(filepath.ToSlash(strings.TrimPrefix(input, string(filepath.Separator))), "/", "_")
+		}
+		names[input] = name + ext
+	}
+	return names
+}
+
+// checkTemplateInputs returns an error if the user prompt of the prompt template
+// has input variables without a value; batches cannot ask for them interactively.
+func checkTemplateInputs(cfg *Config) error {
+	for _, p := range cfg.Prompts {
+		if p.Name != cfg.PromptTemplate {
+			continue
+		}
+		for _, match := range regexp.MustCompile(`\{\{\.([a-zA-Z_]+)\}\}`).FindAllStringSubmatch(p.User, -1) {
+			if _, ok := cfg.UserPromptInputs[match[1]]; !ok {
+				return fmt.Errorf("no value for the input variable %s of the prompt template '%s'", match[1], p.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// responseText returns the text of the assistant message, without thinking.
+func responseText(m Message) string {
+	var texts []string
+	for _, c := range m.Content {
+		if text := strings.TrimSpace(c.Text); c.Type == MessageContentTypeText && text != "" {
+			texts = append(texts, text)
+		}
+	}
+	return strings.Join(texts, "\n\n")
+}
+
+// collectResponse reads the response from the stream into one assistant message,
+// the counterpart of receiveStreamingMessagesCmd without the user interface.
+func collectResponse(ctx context.Context, stream responseStream, start time.Time) (batchResponse, error) {
+	defer func() { _ = stream.Close() }()
+
+	r := batchResponse{Message: Message{Role: MessageRoleAssistant, Content: []Content{}}}
+	blockIdx := make(map[int]int)     // content block index to position in the message content
+	toolInput := make(map[int]string) // partial JSON input of tool_use blocks by index
+	inputTokensReported := false
+	block := func(index int) *Content {
+		i, ok := blockIdx[index]
+		if !ok {
+			i = len(r.Message.Content) - 1
+		}
+		return &r.Message.Content[i]
+	}
+
+	for {
+		select {
+		case e, ok := <-stream.Events():
+			if !ok {
+				if err := stream.Err(); err != nil {
+					return r, err
+				}
+				return r, errEmptyResponseStream
+			}
+			if r.TTFB == 0 {
+				r.TTFB = time.Since(start)
+			}
+
+			switch e.Type {
+			case EventRetry.String(): // the response is invoked again, its usage still counts
+				r.Message.Content = []Content{}
+				r.StopReason = ""
+				blockIdx = make(map[int]int)
+				toolInput = make(map[int]string)
+			case EventMessageStart.String():
+				if e.Message != nil {
+					u := e.Message.Usage
+					r.Usage.Add(Usage{InputTokens: u.InputTokens, CacheReadInputTokens: u.CacheReadInputTokens, CacheWriteInputTokens: u.CacheCreationInputTokens})
+					inputTokensReported = u.InputTokens > 0
+				}
+			case EventContentBlockStart.String():
+				if e.ContentBlock == nil {
+					continue
+				}
+				c := Content{Type: e.ContentBlock.Type}
+				if c.Type == MessageContentTypeToolUse {
+					c.ID, c.Name = e.ContentBlock.ID, e.ContentBlock.Name
+					toolInput[e.Index] = ""
+				}
+				r.Message.Content = append(r.Message.Content, c)
+				blockIdx[e.Index] = len(r.Message.Content) - 1
+			case EventContentBlockDelta.String():
+				if e.Delta == nil || len(r.Message.Content) == 0 {
+					continue
+				}
+				switch e.Delta.Type {
+				case "text_delta":
+					block(e.Index).Text += e.Delta.Text
+				case "thinking_delta":
+					block(e.Index).Thinking += e.Delta.Thinking
+				case "signature_delta":
+					block(e.Index).Signature += e.Delta.Signature
+				case "input_json_delta":
+					toolInput[e.Index] += e.Delta.PartialJSON
+				}
+			case EventContentBlockStop.String():
+				if input, ok := toolInput[e.Index]; ok && len(r.Message.Content) > 0 {
+					if strings.TrimSpace(input) == "" {
+						input = "{}" // tool called without parameters
+					}
+					block(e.Index).Input = json.RawMessage(input)
+				}
+			case EventMessageDelta.String():
+				if e.Delta != nil {
+					r.StopReason = e.Delta.StopReason
+				}
+				if u := e.Usage; u != nil {
+					usage := Usage{OutputTokens: u.OutputTokens}
+					if !inputTokensReported {
+						usage.InputTokens, usage.CacheReadInputTokens, usage.CacheWriteInputTokens = u.InputTokens, u.CacheReadInputTokens, u.CacheCreationInputTokens
+					}
+					r.Usage.Add(usage)
+				}
+			case EventMessageStop.String():
+				r.Metrics = e.AmazonBedrockInvocationMetrics
+				return r, nil
+			}
+		case <-ctx.Done():
+			return r, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,587 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	sdkconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/bedrock"
+	"github.com/aws/aws-sdk-go-v2/service/bedrock/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// batchJobPriceFactor is the price of batch inference relative to on-demand
+	// invocations, see https://aws.amazon.com/bedrock/pricing/
+	batchJobPriceFactor = 0.5
+	// batchJobMinRecords is the default quota of the minimum number of records of a
+	// batch inference job
+	batchJobMinRecords = 100
+
+	batchJobRecordsFile = "records.jsonl"
+)
+
+var (
+	batchS3URI   string
+	batchRoleARN string
+	batchJobName string
+	batchWait    bool
+	batchPoll    time.Duration
+	batchJSON    bool
+
+	batchSubmitCmd = &cobra.Command{
+		Use:   "submit [prompt]",
+		Short: "Submit the inputs as a Bedrock batch inference job, at half the price",
+		Long: `Build the request for every input file like 'bods batch' does and submit them as
+records of a Bedrock batch inference job: the records are uploaded to S3 as JSONL and
+the job writes the responses next to them, usually within hours. The job is saved
+in the manifest.json of the --out directory; check it with 'bods batch status' and
+download the responses with 'bods batch fetch'.`,
+		Example: `  bods batch submit -p summarize --inputs 'docs/*.pdf' --out results/ --s3 s3://my-bucket/bods --role arn:aws:iam::123456789012:role/BedrockBatch
+  bods batch status --out results/ --wait
+  bods batch fetch --out results/`,
+		Args: cobra.ArbitraryArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config.Prefix = strings.Join(args, " ")
+			if config.VariableInputRaw != "" {
+				inputs, err := parseVarMap(config.VariableInputRaw)
+				if err != nil {
+					return bodsError{err, "Input variables parsing failed."}
+				}
+				config.UserPromptInputs = inputs
+			}
+			if batchS3URI != "" {
+				config.Batch.S3URI = batchS3URI
+			}
+			if batchRoleARN != "" {
+				config.Batch.RoleARN = batchRoleARN
+			}
+
+			jobs, store, err := newBatchJobClients(cmd.Context())
+			if err != nil {
+				return bodsError{err, "Could not load the AWS configuration."}
+			}
+			job, err := submitBatchJob(cmd.Context(), &config, batchOptions{Inputs: batchInputs, Out: batchOut}, batchJobName, jobs, store)
+			if err != nil {
+				return err
+			}
+			fmt.Println(job.ARN)
+			_, _ = fmt.Fprintf(os.Stderr, "%s\n", stderrStyles().Comment.Render(fmt.Sprintf("Submitted job %s, check it with 'bods batch status --out %s'.", job.Name, batchOut)))
+			return nil
+		},
+	}
+
+	batchStatusCmd = &cobra.Command{
+		Use:   "status [job-arn]",
+		Short: "Show the status of the batch inference job of the --out directory",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			jobs, _, err := newBatchJobClients(cmd.Context())
+			if err != nil {
+				return bodsError{err, "Could not load the AWS configuration."}
+			}
+
+			var manifest *batchManifest
+			arn := ""
+			if len(args) > 0 {
+				arn = args[0]
+			} else {
+				if manifest, err = readBatchJobManifest(batchOut); err != nil {
+					return err
+				}
+				arn = manifest.Job.ARN
+			}
+
+			poll := batchPoll
+			if !batchWait {
+				poll = 0
+			}
+			job, err := waitForBatchJob(cmd.Context(), jobs, arn, poll)
+			if err != nil {
+				return bodsError{err, "Could not get the batch inference job."}
+			}
+			if manifest != nil {
+				manifest.Job.Status, manifest.Job.Message = string(job.Status), aws.ToString(job.Message)
+				if err := writeBatchManifest(batchOut, manifest); err != nil {
+					return bodsError{err, "Could not write the manifest of the batch."}
+				}
+			}
+
+			if batchJSON {
+				return printJSON(job)
+			}
+			printBatchJobStatus(job)
+			return nil
+		},
+	}
+
+	batchFetchCmd = &cobra.Command{
+		Use:   "fetch",
+		Short: "Download the responses of the batch inference job of the --out directory",
+		Long: `Download the results of the finished batch inference job from S3 and write the
+response to each input to its file in the --out directory, like 'bods batch' does.
+Records the job failed on are marked as failed in the manifest.json; run
+'bods batch --resume' to retry them on demand, or submit them again.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			jobs, store, err := newBatchJobClients(cmd.Context())
+			if err != nil {
+				return bodsError{err, "Could not load the AWS configuration."}
+			}
+			return fetchBatchJob(cmd.Context(), batchOut, jobs, store)
+		},
+	}
+)
+
+func initBatchJobCommands() {
+	batchSubmitCmd.Flags().StringVar(&batchS3URI, "s3", "", "S3 location for the records and the results of the job, e.g. s3://my-bucket/bods (see 'batch' in bods.yaml)")
+	batchSubmitCmd.Flags().StringVar(&batchRoleARN, "role", "", "ARN of the service role Bedrock uses to access the S3 location (see 'batch' in bods.yaml)")
+	batchSubmitCmd.Flags().StringVar(&batchJobName, "job-name", "", "Name of the job (default is bods-<prompt>-<time>)")
+	batchStatusCmd.Flags().BoolVar(&batchWait, "wait", false, "Poll the job until it has finished")
+	batchStatusCmd.Flags().DurationVar(&batchPoll, "interval", time.Minute, "Time between polls with --wait")
+	batchStatusCmd.Flags().BoolVar(&batchJSON, "json", false, "Print the job as JSON")
+	batchCmd.AddCommand(batchSubmitCmd, batchStatusCmd, batchFetchCmd)
+}
+
+// BatchJobConfig are the settings of batch inference jobs ('batch' section of bods.yaml).
+type BatchJobConfig struct {
+	S3URI        string `koanf:"s3_uri"`        // e.g. s3://my-bucket/bods
+	RoleARN      string `koanf:"role_arn"`      // service role of Bedrock to access S3URI
+	TimeoutHours int    `koanf:"timeout_hours"` // of the job, 0 for the default of Bedrock
+}
+
+// batchJob is a batch inference job, saved in the manifest of the batch.
+type batchJob struct {
+	ARN       string `json:"arn"`
+	Name      string `json:"name"`
+	ModelID   string `json:"model_id"`
+	InputURI  string `json:"input_uri"`  // the JSONL records
+	OutputURI string `json:"output_uri"` // where the job writes the results
+	Status    string `json:"status"`     // as of the last bods batch status
+	Message   string `json:"message,omitempty"`
+}
+
+// batchJobsAPI is the part of the bedrock API used for batch inference jobs,
+// implemented by *bedrock.Client.
+type batchJobsAPI interface {
+	CreateModelInvocationJob(ctx context.Context, params *bedrock.CreateModelInvocationJobInput, optFns ...func(*bedrock.Options)) (*bedrock.CreateModelInvocationJobOutput, error)
+	GetModelInvocationJob(ctx context.Context, params *bedrock.GetModelInvocationJobInput, optFns ...func(*bedrock.Options)) (*bedrock.GetModelInvocationJobOutput, error)
+}
+
+// s3ObjectsAPI is the part of the S3 API used for the records and results of batch
+// inference jobs, implemented by *s3.Client.
+type s3ObjectsAPI interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+}
+
+// batchJobRecord is a line of the JSONL input of a batch inference job; the model
+// input is the request body of InvokeModel.
+type batchJobRecord struct {
+	RecordID   string                                      `json:"recordId"`
+	ModelInput *AnthropicClaudeMessagesInferenceParameters `json:"modelInput"`
+}
+
+// batchJobResult is a line of the output of a batch inference job: the record with
+// the response of the model, or the error.
+type batchJobResult struct {
+	RecordID    string `json:"recordId"`
+	ModelOutput *struct {
+		Content    []Content     `json:"content"`
+		StopReason string        `json:"stop_reason"`
+		Usage      ResponseUsage `json:"usage"`
+	} `json:"modelOutput"`
+	Error *struct {
+		ErrorCode    any    `json:"errorCode"`
+		ErrorMessage string `json:"errorMessage"`
+	} `json:"error"`
+}
+
+func newBatchJobClients(ctx context.Context) (*bedrock.Client, *s3.Client, error) {
+	awsConfig, err := sdkconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return bedrock.NewFromConfig(awsConfig), s3.NewFromConfig(awsConfig), nil
+}
+
+// submitBatchJob builds the request for every input, uploads them as the records of
+// a batch inference job to S3, creates the job and saves it in the manifest.
+func submitBatchJob(ctx context.Context, cfg *Config, opts batchOptions, name string, jobs batchJobsAPI, store s3ObjectsAPI) (*batchJob, error) {
+	if cfg.Provider == providerAnthropic {
+		return nil, bodsError{errors.New("batch inference jobs are a feature of Bedrock"), "Use --provider bedrock to submit a batch inference job."}
+	}
+	bucket, prefix, err := parseS3URI(cfg.Batch.S3URI)
+	if err != nil {
+		return nil, bodsError{err, "Set the S3 location of the job with --s3 or 'batch.s3_uri' in bods.yaml."}
+	}
+	if cfg.Batch.RoleARN == "" {
+		return nil, bodsError{errors.New("no service role given"), "Set the service role of the job with --role or 'batch.role_arn' in bods.yaml."}
+	}
+
+	previous, err := readBatchManifest(filepath.Join(opts.Out, batchManifestFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, bodsError{err, "Could not read the manifest of the batch."}
+	}
+	if previous != nil && previous.Job != nil && slices.ContainsFunc(previous.Items, func(item *batchItem) bool { return item.Status == batchStatusSubmitted }) {
+		return nil, bodsError{fmt.Errorf("job %s has not been fetched", previous.Job.Name), "Fetch the results of the job with 'bods batch fetch' first."}
+	}
+	b, manifest, pending, err := prepareBatch(ctx, cfg, opts, previous)
+	if err != nil {
+		return nil, err
+	}
+	if len(pending) == 0 {
+		return nil, bodsError{errors.New("all inputs have a response"), "There is nothing to submit."}
+	}
+	if b.guardrail != nil {
+		return nil, bodsError{errors.New("guardrails are not supported by batch inference jobs"), "Invalid batch."}
+	}
+
+	var records bytes.Buffer
+	enc := json.NewEncoder(&records)
+	modelID := ""
+	for i, item := range pending {
+		data, err := os.ReadFile(item.Input)
+		if err != nil {
+			return nil, bodsError{err, "Could not read the input."}
+		}
+		messages = []Message{{Role: MessageRoleUser}}
+		request, err := b.buildRequest(string(data))
+		if err != nil {
+			return nil, err
+		}
+		modelID = request.ModelID
+		item.RecordID = fmt.Sprintf("REC%08d", i+1)
+		if err := enc.Encode(batchJobRecord{RecordID: item.RecordID, ModelInput: request.Params}); err != nil {
+			return nil, bodsError{err, "Could not encode the record."}
+		}
+	}
+	if len(pending) < batchJobMinRecords {
+		_, _ = fmt.Fprintf(os.Stderr, "%s\n", stderrStyles().ErrPadding.Render(stderrStyles().ErrorHeader.SetString("WARNING").String(),
+			fmt.Sprintf("%d records are less than the minimum of %d records of a job by default, see the Bedrock quotas", len(pending), batchJobMinRecords)))
+	}
+
+	if name == "" {
+		name = batchJobDefaultName(cfg.PromptTemplate, time.Now())
+	}
+	jobPrefix := path.Join(prefix, name)
+	job := &batchJob{
+		Name:      name,
+		ModelID:   modelID,
+		InputURI:  fmt.Sprintf("s3://%s/%s", bucket, path.Join(jobPrefix, batchJobRecordsFile)),
+		OutputURI: fmt.Sprintf("s3://%s/%s/", bucket, path.Join(jobPrefix, "output")),
+	}
+	_, err = store.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(path.Join(jobPrefix, batchJobRecordsFile)),
+		Body:        bytes.NewReader(records.Bytes()),
+		ContentType: aws.String("application/jsonl"),
+	})
+	if err != nil {
+		return nil, bodsError{err, "Could not upload the records to " + job.InputURI + "."}
+	}
+
+	input := &bedrock.CreateModelInvocationJobInput{
+		JobName: aws.String(name),
+		ModelId: aws.String(modelID),
+		RoleArn: aws.String(cfg.Batch.RoleARN),
+		InputDataConfig: &types.ModelInvocationJobInputDataConfigMemberS3InputDataConfig{Value: types.ModelInvocationJobS3InputDataConfig{
+			S3Uri:         aws.String(job.InputURI),
+			S3InputFormat: types.S3InputFormatJsonl,
+		}},
+		OutputDataConfig: &types.ModelInvocationJobOutputDataConfigMemberS3OutputDataConfig{Value: types.ModelInvocationJobS3OutputDataConfig{
+			S3Uri: aws.String(job.OutputURI),
+		}},
+	}
+	if hours := cfg.Batch.TimeoutHours; hours > 0 {
+		input.TimeoutDurationInHours = aws.Int32(int32(min(hours, 168))) // #nosec G115 - at most a week
+	}
+	output, err := jobs.CreateModelInvocationJob(ctx, input)
+	if err != nil {
+		return nil, bodsError{err, "Could not create the batch inference job. Does the role have access to the S3 location and the model?"}
+	}
+	job.ARN, job.Status = aws.ToString(output.JobArn), string(types.ModelInvocationJobStatusSubmitted)
+
+	for _, item := range pending {
+		item.Status, item.Error = batchStatusSubmitted, ""
+	}
+	manifest.ModelID, manifest.Job = modelID, job
+	if err := os.MkdirAll(opts.Out, 0o755); err != nil {
+		return nil, bodsError{err, "Could not create the output directory."}
+	}
+	if err := writeBatchManifest(opts.Out, manifest); err != nil {
+		return nil, bodsError{err, "Could not write the manifest of the batch."}
+	}
+	return job, nil
+}
+
+// waitForBatchJob returns the job; with a poll interval, once it has finished. The
+// progress is printed on stderr.
+func waitForBatchJob(ctx context.Context, jobs batchJobsAPI, arn string, poll time.Duration) (*bedrock.GetModelInvocationJobOutput, error) {
+	for {
+		job, err := jobs.GetModelInvocationJob(ctx, &bedrock.GetModelInvocationJobInput{JobIdentifier: aws.String(arn)})
+		if err != nil || poll == 0 || batchJobFinished(job.Status) {
+			return job, err
+		}
+		_, _ = fmt.Fprintf(os.Stderr, "%s\n", stderrStyles().Comment.Render(fmt.Sprintf("%s %s: %s, %d of %d records processed",
+			time.Now().Format(time.TimeOnly), aws.ToString(job.JobName), job.Status, aws.ToInt64(job.ProcessedRecordCount), aws.ToInt64(job.TotalRecordCount))))
+		select {
+		case <-time.After(poll):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// fetchBatchJob downloads the results of the finished job of the manifest in the
+// out directory and writes the response to each input to its output file.
+func fetchBatchJob(ctx context.Context, out string, jobs batchJobsAPI, store s3ObjectsAPI) error {
+	manifest, err := readBatchJobManifest(out)
+	if err != nil {
+		return err
+	}
+	job, err := jobs.GetModelInvocationJob(ctx, &bedrock.GetModelInvocationJobInput{JobIdentifier: aws.String(manifest.Job.ARN)})
+	if err != nil {
+		return bodsError{err, "Could not get the batch inference job."}
+	}
+	manifest.Job.Status, manifest.Job.Message = string(job.Status), aws.ToString(job.Message)
+	if !batchJobFinished(job.Status) {
+		return bodsError{fmt.Errorf("job %s is %s", manifest.Job.Name, job.Status), "The job has not finished yet, wait for it with 'bods batch status --wait'."}
+	}
+
+	results, err := readBatchJobResults(ctx, store, manifest.Job)
+	if err != nil {
+		return bodsError{err, "Could not download the results of the job from " + manifest.Job.OutputURI + "."}
+	}
+
+	schema, err := batchJobSchema(manifest.Prompt)
+	if err != nil {
+		return bodsError{err, "Invalid response schema."}
+	}
+	end := aws.ToTime(job.EndTime)
+	var rounds []roundUsage
+	fetched, failed := 0, 0
+	for i, item := range manifest.Items {
+		if item.Status != batchStatusSubmitted {
+			continue
+		}
+		result, ok := results[item.RecordID]
+		var round *roundUsage
+		switch {
+		case !ok:
+			err = fmt.Errorf("no result for record %s, the job is %s", item.RecordID, job.Status)
+		case result.Error != nil:
+			err = fmt.Errorf("%v: %s", result.Error.ErrorCode, result.Error.ErrorMessage)
+		case result.ModelOutput == nil:
+			err = fmt.Errorf("no model output for record %s", item.RecordID)
+		default:
+			u := result.ModelOutput.Usage
+			round = &roundUsage{
+				ModelID: manifest.Job.ModelID,
+				Usage:   Usage{InputTokens: u.InputTokens, OutputTokens: u.OutputTokens, CacheReadInputTokens: u.CacheReadInputTokens, CacheWriteInputTokens: u.CacheCreationInputTokens},
+				Batch:   true,
+				start:   end.Add(time.Duration(i)), // keeps the ledger entries of the records apart
+			}
+			err = writeBatchJobOutput(out, item, Message{Role: MessageRoleAssistant, Content: result.ModelOutput.Content}, schema)
+			item.StopReason = result.ModelOutput.StopReason
+		}
+
+		item.Status, item.Error = batchStatusOK, ""
+		if err != nil {
+			item.Status, item.Error = batchStatusFailed, err.Error()
+			failed++
+		}
+		if round != nil {
+			rounds = append(rounds, *round)
+			item.Usage = round.Usage
+			if cost, ok := round.Cost(); ok {
+				item.Cost = &cost
+			}
+		}
+		fetched++
+	}
+
+	if err := recordUsage(&Config{PromptTemplate: manifest.Prompt, Provider: providerBedrock}, rounds); err != nil {
+		logger.Println("could not record usage:", err)
+	}
+	manifest.Finished = end
+	if err := writeBatchManifest(out, manifest); err != nil {
+		return bodsError{err, "Could not write the manifest of the batch."}
+	}
+
+	r := &batchRun{opts: batchOptions{Out: out}, manifest: manifest, total: fetched, done: fetched, failed: failed, rounds: rounds}
+	_, _ = fmt.Fprintf(os.Stderr, "%s\n", stderrStyles().ConversationList.Render(r.summary()))
+	if failed > 0 {
+		return bodsError{fmt.Errorf("%d of %d records failed, see %s", failed, fetched, filepath.Join(out, batchManifestFile)),
+			"The job is incomplete. Run 'bods batch --resume' to retry the failed inputs on demand."}
+	}
+	return nil
+}
+
+// writeBatchJobOutput writes the response of a record to the output file of the
+// item, like batchRun.run.
+func writeBatchJobOutput(out string, item *batchItem, m Message, schema *responseSchema) error {
+	output := responseText(m) + "\n"
+	if schema != nil {
+		structured, _ := structuredOutput(m)
+		if violations := schema.Validate(structured); len(violations) > 0 {
+			return fmt.Errorf("the response does not match the schema: %s", strings.Join(violations, "; "))
+		}
+		output = string(structured) + "\n"
+	}
+	return os.WriteFile(filepath.Join(out, item.Output), []byte(output), 0o600)
+}
+
+// batchJobSchema returns the response schema of the prompt template, nil if it has
+// none; the --schema of a submitted job is not known when it is fetched, the
+// responses are then written as they are.
+func batchJobSchema(prompt string) (*responseSchema, error) {
+	for _, p := range config.Prompts {
+		if p.Name == prompt && p.Schema != nil {
+			return loadResponseSchema(p.Schema, p.Name)
+		}
+	}
+	return nil, nil
+}
+
+// readBatchJobResults downloads the output files of the job, e.g.
+// <output uri>/<job id>/records.jsonl.out, and returns the results by record id.
+func readBatchJobResults(ctx context.Context, store s3ObjectsAPI, job *batchJob) (map[string]batchJobResult, error) {
+	bucket, prefix, err := parseS3URI(job.OutputURI)
+	if err != nil {
+		return nil, err
+	}
+	jobID := job.ARN[strings.LastIndex(job.ARN, "/")+1:]
+	prefix = path.Join(prefix, jobID) + "/"
+
+	results := make(map[string]batchJobResult)
+	paginator := s3.NewListObjectsV2Paginator(store, &s3.ListObjectsV2Input{Bucket: aws.String(bucket), Prefix: aws.String(prefix)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range page.Contents {
+			key := aws.ToString(object.Key)
+			if !strings.HasSuffix(key, ".jsonl.out") {
+				continue // e.g. manifest.json.out with the counts of the job
+			}
+			if err := readBatchJobOutput(ctx, store, bucket, key, results); err != nil {
+				return nil, fmt.Errorf("s3://%s/%s: %w", bucket, key, err)
+			}
+		}
+	}
+	return results, nil
+}
+
+func readBatchJobOutput(ctx context.Context, store s3ObjectsAPI, bucket, key string, results map[string]batchJobResult) error {
+	object, err := store.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return err
+	}
+	defer object.Body.Close()
+
+	reader := bufio.NewReader(object.Body)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var result batchJobResult
+			if err := json.Unmarshal(line, &result); err != nil {
+				return err
+			}
+			results[result.RecordID] = result
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readBatchJobManifest reads the manifest of the out directory, which must have a
+// batch inference job.
+func readBatchJobManifest(out string) (*batchManifest, error) {
+	if out == "" {
+		return nil, bodsError{errors.New("no output directory given"), "Set the output directory of the batch with --out, e.g. --out results/."}
+	}
+	manifest, err := readBatchManifest(filepath.Join(out, batchManifestFile))
+	if err != nil {
+		return nil, bodsError{err, "Could not read the manifest of the batch."}
+	}
+	if manifest.Job == nil {
+		return nil, bodsError{fmt.Errorf("the batch in %s has no batch inference job", out), "Submit a job with 'bods batch submit' first."}
+	}
+	return manifest, nil
+}
+
+// printBatchJobStatus prints the status and record counts of the job.
+func printBatchJobStatus(job *bedrock.GetModelInvocationJobOutput) {
+	format := func(t *time.Time) string {
+		if t == nil {
+			return "-"
+		}
+		return t.Local().Format(time.DateTime)
+	}
+	printTable([]string{"JOB", "STATUS", "RECORDS", "PROCESSED", "SUCCEEDED", "FAILED", "SUBMITTED", "ENDED"}, [][]string{{
+		aws.ToString(job.JobName),
+		string(job.Status),
+		strconv.FormatInt(aws.ToInt64(job.TotalRecordCount), 10),
+		strconv.FormatInt(aws.ToInt64(job.ProcessedRecordCount), 10),
+		strconv.FormatInt(aws.ToInt64(job.SuccessRecordCount), 10),
+		strconv.FormatInt(aws.ToInt64(job.ErrorRecordCount), 10),
+		format(job.SubmitTime),
+		format(job.EndTime),
+	}})
+	if message := aws.ToString(job.Message); message != "" {
+		_, _ = fmt.Fprintf(os.Stderr, "\n%s\n", stderrStyles().ConversationList.Render(stderrStyles().Comment.Render(message)))
+	}
+}
+
+// batchJobFinished reports whether a job with the status has finished, with or
+// without results.
+func batchJobFinished(status types.ModelInvocationJobStatus) bool {
+	switch status {
+	case types.ModelInvocationJobStatusCompleted, types.ModelInvocationJobStatusPartiallyCompleted,
+		types.ModelInvocationJobStatusFailed, types.ModelInvocationJobStatusStopped, types.ModelInvocationJobStatusExpired:
+		return true
+	}
+	return false
+}
+
+// batchJobDefaultName returns a job name from the prompt template and the time, e.g.
+// bods-summarize-20261016-143000; job names are letters, digits and hyphens.
+func batchJobDefaultName(prompt string, t time.Time) string {
+	name := "bods"
+	if prompt = regexp.MustCompile(`[^a-zA-Z0-9]+`).ReplaceAllString(prompt, "-"); strings.Trim(prompt, "-") != "" {
+		name += "-" + strings.Trim(prompt, "-")
+	}
+	return fmt.Sprintf("%.47s-%s", name, t.Format("20060102-150405"))
+}
+
+// parseS3URI returns the bucket and the key prefix of an S3 URI, e.g. s3://bucket/prefix.
+func parseS3URI(uri string) (string, string, error) {
+	rest, ok := strings.CutPrefix(uri, "s3://")
+	bucket, prefix, _ := strings.Cut(rest, "/")
+	if !ok || bucket == "" {
+		return "", "", fmt.Errorf("invalid S3 URI '%s', expected s3://<bucket>/<prefix>", uri)
+	}
+	return bucket, strings.Trim(prefix, "/"), nil
+}
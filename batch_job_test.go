@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/bedrock"
+	"github.com/aws/aws-sdk-go-v2/service/bedrock/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// s3StandIn is a local S3 with path-style PutObject, GetObject and ListObjectsV2.
+type s3StandIn struct {
+	mu      sync.Mutex
+	objects map[string][]byte // by bucket/key
+}
+
+func (s *s3StandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	switch {
+	case r.Method == http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		s.objects[name] = data
+	case r.URL.Query().Get("list-type") == "2":
+		type content struct{ Key string }
+		result := struct {
+			XMLName  xml.Name `xml:"ListBucketResult"`
+			Contents []content
+		}{}
+		prefix := name + "/" + r.URL.Query().Get("prefix")
+		for key := range s.objects {
+			if strings.HasPrefix(key, prefix) {
+				result.Contents = append(result.Contents, content{strings.TrimPrefix(key, name+"/")})
+			}
+		}
+		_ = xml.NewEncoder(w).Encode(result)
+	default:
+		data, ok := s.objects[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `<Error><Code>NoSuchKey</Code></Error>`)
+			return
+		}
+		_, _ = w.Write(data)
+	}
+}
+
+// fakeBedrockJobs is a bedrock client with one batch inference job.
+type fakeBedrockJobs struct {
+	created *bedrock.CreateModelInvocationJobInput
+	status  types.ModelInvocationJobStatus
+}
+
+func (f *fakeBedrockJobs) CreateModelInvocationJob(_ context.Context, params *bedrock.CreateModelInvocationJobInput, _ ...func(*bedrock.Options)) (*bedrock.CreateModelInvocationJobOutput, error) {
+	f.created = params
+	f.status = types.ModelInvocationJobStatusSubmitted
+	return &bedrock.CreateModelInvocationJobOutput{JobArn: aws.String("arn:aws:bedrock:us-east-1:123456789012:model-invocation-job/j0b1d")}, nil
+}
+
+func (f *fakeBedrockJobs) GetModelInvocationJob(_ context.Context, _ *bedrock.GetModelInvocationJobInput, _ ...func(*bedrock.Options)) (*bedrock.GetModelInvocationJobOutput, error) {
+	return &bedrock.GetModelInvocationJobOutput{
+		JobName:          f.created.JobName,
+		Status:           f.status,
+		EndTime:          aws.Time(time.Now()),
+		TotalRecordCount: aws.Int64(3),
+	}, nil
+}
+
+func TestBatchJob(t *testing.T) {
+	useTempDataHome(t)
+	standIn := &s3StandIn{objects: make(map[string][]byte)}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+	store := s3.New(s3.Options{
+		BaseEndpoint: aws.String(server.URL),
+		UsePathStyle: true,
+		Region:       "us-east-1",
+		Credentials:  credentials.NewStaticCredentialsProvider("key", "secret", ""),
+	})
+	jobs := &fakeBedrockJobs{}
+
+	dir := t.TempDir()
+	for name, content := range map[string]string{"one.txt": "first document", "two.txt": "second document", "three.txt": "third document"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600))
+	}
+	out := filepath.Join(dir, "results")
+	cfg := &Config{
+		Provider: providerBedrock,
+		ModelID:  "anthropic.claude-sonnet-4-6",
+		Cache:    cacheOff,
+		Batch:    BatchJobConfig{S3URI: "s3://bucket/jobs/", RoleARN: "arn:aws:iam::123456789012:role/batch"},
+	}
+	opts := batchOptions{Inputs: []string{filepath.Join(dir, "*.txt")}, Out: out}
+
+	job, err := submitBatchJob(context.Background(), cfg, opts, "summaries", jobs, store)
+	require.NoError(t, err)
+	assert.Equal(t, "s3://bucket/jobs/summaries/records.jsonl", job.InputURI)
+	assert.Equal(t, "anthropic.claude-sonnet-4-6", aws.ToString(jobs.created.ModelId))
+	assert.Equal(t, cfg.Batch.RoleARN, aws.ToString(jobs.created.RoleArn))
+	assert.Equal(t, job.OutputURI, aws.ToString(jobs.created.OutputDataConfig.(*types.ModelInvocationJobOutputDataConfigMemberS3OutputDataConfig).Value.S3Uri))
+
+	// one record per input with the body of InvokeModel
+	var records []map[string]any
+	scanner := bufio.NewScanner(strings.NewReader(string(standIn.objects["bucket/jobs/summaries/records.jsonl"])))
+	for scanner.Scan() {
+		var record map[string]any
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	require.Len(t, records, 3)
+	assert.Equal(t, "REC00000001", records[0]["recordId"])
+	input := records[0]["modelInput"].(map[string]any)
+	assert.Equal(t, "bedrock-2023-05-31", input["anthropic_version"])
+	assert.Contains(t, fmt.Sprint(input["messages"]), "first document")
+
+	manifest, err := readBatchManifest(filepath.Join(out, batchManifestFile))
+	require.NoError(t, err)
+	assert.Equal(t, job.ARN, manifest.Job.ARN)
+	for _, item := range manifest.Items {
+		assert.Equal(t, batchStatusSubmitted, item.Status)
+	}
+	_, err = submitBatchJob(context.Background(), cfg, opts, "again", jobs, store)
+	assert.ErrorContains(t, err, "has not been fetched")
+
+	assert.ErrorContains(t, fetchBatchJob(context.Background(), out, jobs, store), "is Submitted")
+
+	// the job wrote the results of two records and failed on one
+	jobs.status = types.ModelInvocationJobStatusPartiallyCompleted
+	byInput := func(name string) *batchItem { return manifest.item(filepath.Join(dir, name)) }
+	results := []string{
+		fmt.Sprintf(`{"recordId":%q,"modelInput":{},"modelOutput":{"content":[{"type":"text","text":"summary one"}],"stop_reason":"end_turn","usage":{"input_tokens":1000000,"output_tokens":10}}}`, byInput("one.txt").RecordID),
+		fmt.Sprintf(`{"recordId":%q,"modelInput":{},"error":{"errorCode":400,"errorMessage":"too long"}}`, byInput("two.txt").RecordID),
+	}
+	standIn.objects["bucket/jobs/summaries/output/j0b1d/records.jsonl.out"] = []byte(strings.Join(results, "\n") + "\n")
+	standIn.objects["bucket/jobs/summaries/output/j0b1d/manifest.json.out"] = []byte(`{"totalRecordCount":3}`)
+
+	err = fetchBatchJob(context.Background(), out, jobs, store)
+	assert.ErrorContains(t, err, "2 of 3 records failed")
+	data, err := os.ReadFile(filepath.Join(out, "one.txt.md"))
+	require.NoError(t, err)
+	assert.Equal(t, "summary one\n", string(data))
+
+	manifest, err = readBatchManifest(filepath.Join(out, batchManifestFile))
+	require.NoError(t, err)
+	assert.Equal(t, string(types.ModelInvocationJobStatusPartiallyCompleted), manifest.Job.Status)
+	assert.Equal(t, batchStatusOK, byInput("one.txt").Status)
+	assert.Equal(t, "400: too long", byInput("two.txt").Error)
+	assert.Contains(t, byInput("three.txt").Error, "no result")
+	require.NotNil(t, byInput("one.txt").Cost)
+	assert.InDelta(t, 1.5, *byInput("one.txt").Cost, 0.001, "half of the on-demand price of 1M input tokens")
+
+	entries, err := readLedger(time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	// the failed inputs can be submitted again
+	job, err = submitBatchJob(context.Background(), cfg, opts, "retry", jobs, store)
+	require.NoError(t, err)
+	assert.Equal(t, "retry", job.Name)
+	assert.Equal(t, 2, strings.Count(string(standIn.objects["bucket/jobs/retry/records.jsonl"]), "\n"))
+}
+
+func TestBatchJobHelpers(t *testing.T) {
+	bucket, prefix, err := parseS3URI("s3://my-bucket/a/b/")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"my-bucket", "a/b"}, []string{bucket, prefix})
+	_, _, err = parseS3URI("my-bucket/a")
+	assert.Error(t, err)
+
+	at := time.Date(2026, 10, 16, 14, 30, 0, 0, time.UTC)
+	assert.Equal(t, "bods-summarize-structured-20261016-143000", batchJobDefaultName("summarize structured", at))
+	assert.Equal(t, "bods-20261016-143000", batchJobDefaultName("", at))
+	assert.True(t, slices.ContainsFunc([]types.ModelInvocationJobStatus{types.ModelInvocationJobStatusCompleted}, batchJobFinished))
+	assert.False(t, batchJobFinished(types.ModelInvocationJobStatusInProgress))
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchOutputNames(t *testing.T) {
+	names := batchOutputNames([]string{"docs/a.pdf", "docs/b.txt", "docs/x/a.pdf", "c.md"}, ".md")
+	assert.Equal(t, map[string]string{
+		"docs/a.pdf":   "docs_a.pdf.md",
+		"docs/b.txt":   "b.txt.md",
+		"docs/x/a.pdf": "docs_x_a.pdf.md",
+		"c.md":         "c.md.md",
+	}, names)
+}
+
+func TestRunBatch(t *testing.T) {
+	useTempDataHome(t)
+
+	var requests atomic.Int32
+	var failing atomic.Bool
+	failing.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		var body struct {
+			Messages []Message `json:"messages"`
+		}
+		data, _ := io.ReadAll(r.Body)
+		require.NoError(t, json.Unmarshal(data, &body))
+		text := body.Messages[0].Content[0].Text
+		if strings.Contains(text, "broken") && failing.Load() {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"type":"error","error":{"type":"invalid_request_error","message":"bad input"}}`)
+			return
+		}
+		w.Header().Set("content-type", "text/event-stream")
+		fmt.Fprint(w, "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"role\":\"assistant\",\"usage\":{\"input_tokens\":10}}}\n\n")
+		fmt.Fprint(w, "event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"text\",\"text\":\"\"}}\n\n")
+		fmt.Fprintf(w, "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"summary of %s\"}}\n\n", strings.Fields(text)[0])
+		fmt.Fprint(w, "event: content_block_stop\ndata: {\"type\":\"content_block_stop\",\"index\":0}\n\n")
+		fmt.Fprint(w, "event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"end_turn\"},\"usage\":{\"output_tokens\":5}}\n\n")
+		fmt.Fprint(w, "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n")
+	}))
+	defer server.Close()
+	t.Setenv(anthropicAPIKeyEnv, "secret")
+	t.Setenv(anthropicBaseURLEnv, server.URL+"/")
+
+	dir := t.TempDir()
+	for name, content := range map[string]string{"one.txt": "first document", "two.txt": "second document", "three.txt": "broken document"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600))
+	}
+	out := filepath.Join(dir, "results")
+	cfg := &Config{Provider: providerAnthropic, ModelID: "anthropic.claude-sonnet-4-6", Cache: cacheOff}
+	opts := batchOptions{Inputs: []string{filepath.Join(dir, "*.txt")}, Out: out, Concurrency: 2}
+
+	err := runBatch(context.Background(), cfg, opts)
+	assert.ErrorContains(t, err, "1 of 3 inputs failed")
+	assert.EqualValues(t, 3, requests.Load())
+	data, err := os.ReadFile(filepath.Join(out, "one.txt.md"))
+	require.NoError(t, err)
+	assert.Equal(t, "summary of first\n", string(data))
+
+	manifest, err := readBatchManifest(filepath.Join(out, batchManifestFile))
+	require.NoError(t, err)
+	require.Len(t, manifest.Items, 3)
+	statuses := make(map[string]string)
+	for _, item := range manifest.Items {
+		statuses[item.Output] = item.Status
+	}
+	assert.Equal(t, map[string]string{"one.txt.md": batchStatusOK, "three.txt.md": batchStatusFailed, "two.txt.md": batchStatusOK}, statuses)
+	assert.Contains(t, manifest.item(filepath.Join(dir, "three.txt")).Error, "bad input")
+	assert.Equal(t, Usage{InputTokens: 20, OutputTokens: 10}, manifest.Usage)
+
+	// only the failed input is run again, with the inputs of the manifest
+	failing.Store(false)
+	opts.Inputs, opts.Resume = nil, true
+	require.NoError(t, runBatch(context.Background(), cfg, opts))
+	assert.EqualValues(t, 4, requests.Load())
+	manifest, err = readBatchManifest(filepath.Join(out, batchManifestFile))
+	require.NoError(t, err)
+	assert.Equal(t, batchStatusOK, manifest.item(filepath.Join(dir, "three.txt")).Status)
+	assert.Equal(t, "end_turn", manifest.item(filepath.Join(dir, "three.txt")).StopReason)
+	assert.Equal(t, Usage{InputTokens: 30, OutputTokens: 15}, manifest.Usage)
+
+	entries, err := readLedger(manifest.Started.Add(-time.Minute))
+	require.NoError(t, err)
+	assert.Len(t, entries, 3)
+}
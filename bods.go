@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/base64"
 	"encoding/json"
@@ -10,7 +11,6 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"math/rand"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -44,6 +44,9 @@ var (
 
 type state int
 
+// defaultMarkdownFormatText is appended to the user prompt unless --format=false is given.
+const defaultMarkdownFormatText = " Format the response as markdown without enclosing backticks."
+
 var (
 	messages          = []Message{{Role: MessageRoleUser}}
 	paramsMessagesAPI = NewAnthropicClaudeMessagesInferenceParameters()
@@ -57,6 +60,7 @@ const (
 	requestState
 	responseState
 	errorState
+	inputState // chat mode: waiting for the next user prompt
 )
 
 // bodsError is a wrapper around error adding additional context.
@@ -69,17 +73,45 @@ func (m bodsError) Error() string {
 	return m.err.Error()
 }
 
+func (m bodsError) Unwrap() error {
+	return m.err
+}
+
 // Bods is the Bubble Tea model that manages reading stdin and querying bedrock
 type Bods struct {
-	Output        string
-	Input         string
-	Styles        styles
-	Error         *bodsError
-	state         state
-	glam          *glamour.TermRenderer
-	glamOutput    string
-	cancelRequest context.CancelFunc
-	context       *context.Context
+	Output         string
+	Input          string
+	Styles         styles
+	Error          *bodsError
+	state          state
+	glam           *glamour.TermRenderer
+	glamOutput     string
+	cancelRequest  context.CancelFunc
+	context        *context.Context
+	Usage          Usage // accumulated token usage of all requests of this run
+	bedrockClient  *bedrock.Client
+	backend        backend                // the provider and API used to invoke the model, see --provider and --api
+	rounds         []roundUsage           // usage of each model invocation, see --usage
+	cachePlan      cachePlan              // cache breakpoints of the last request, see --cache
+	count          *tokenCount            // result of a dry run, see bods count
+	guardrail      *guardrailConfig       // see --guardrail, nil for none
+	intervention   *guardrailIntervention // of the last response, if a guardrail intervened
+	stopReason     string                 // of the last response
+	responseIdx    int                    // index of the first message the model added in this run, see --output json
+	schema         *responseSchema        // see --schema, nil for none
+	structured     json.RawMessage        // the response validated against the schema
+	schemaRepaired bool                   // the model was asked to repair the response, see --schema-repair
+	awsRegion      string
+	chat           *chat // only set in interactive chat mode
+
+	// streaming state of the current response: content block index to position in the
+	// assistant message content, the partial JSON input of tool_use blocks by index,
+	// whether message_start reported the input tokens (Converse reports them at the end)
+	// and the length of the output before it, to discard it on a retry
+	blockContentIdx     map[int]int
+	toolInputJSON       map[int]string
+	inputTokensReported bool
+	outputStart         int
 
 	Config *Config
 }
@@ -98,6 +130,9 @@ func (b *Bods) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if msg.content != "" {
 			b.Input = msg.content
 		}
+		if b.chat != nil {
+			return b, b.startChat()
+		}
 		if msg.content == "" && b.Config.Prefix == "" {
 			return b, b.quit
 		}
@@ -106,9 +141,12 @@ func (b *Bods) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case completionOutput:
 		logger.Printf("completionOutput content=%s\n", msg.content)
-		if msg.content != "" {
+		if msg.content != "" || msg.retry {
+			if msg.retry { // the partial response is discarded, the model is invoked again
+				b.Output = b.Output[:min(b.outputStart, len(b.Output))]
+			}
 			b.Output += msg.content
-			if isOutputTerminal() {
+			if isOutputTerminal() && b.Config.Output == outputText || b.chat != nil {
 				if b.Config.Format {
 					b.glamOutput, _ = b.glam.Render(b.Output)
 				} else {
@@ -116,8 +154,20 @@ func (b *Bods) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				}
 				b.state = responseState
 			}
+			if b.chat != nil {
+				b.chat.retry = ""
+				b.refreshChat()
+			}
 		}
 		if msg.stream == nil {
+			if b.chat != nil {
+				return b, b.finishChatTurn()
+			}
+			if b.schema != nil && b.structured == nil {
+				if cmd := b.checkStructuredOutput(); cmd != nil {
+					return b, cmd
+				}
+			}
 			if b.Config.XMLTagContent != "" {
 				// if b.Config.Metamode && b.Config.PromptTemplate == "metaprompt" {
 				content := extractXMLTagContent(b.Output, b.Config.XMLTagContent)
@@ -131,12 +181,36 @@ func (b *Bods) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 		cmds = append(cmds, b.receiveStreamingMessagesCmd(msg))
 
+	case retryProgress:
+		if b.chat != nil {
+			b.chat.retry = string(msg)
+			return b, nil
+		}
+		return b, tea.Println(b.Styles.Comment.Render(string(msg)))
+
+	case tokenCount:
+		b.count = &msg
+		b.state = doneState
+		return b, b.quit
+
 	case bodsError:
+		if b.chat != nil {
+			b.chatError(msg)
+			return b, nil
+		}
 		b.Error = &msg
 		b.state = errorState
 		return b, b.quit
 
+	case tea.WindowSizeMsg:
+		if b.chat != nil {
+			b.resizeChat(msg.Width, msg.Height)
+		}
+
 	case tea.KeyMsg:
+		if b.chat != nil {
+			return b, b.updateChatKey(msg)
+		}
 		switch msg.String() {
 		case "q", "ctrl+c":
 			b.state = doneState
@@ -149,10 +223,19 @@ func (b *Bods) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		cmds = append(cmds, readStdinCmd)
 	}
 
+	if b.chat != nil { // e.g. cursor blink
+		var cmd tea.Cmd
+		b.chat.input, cmd = b.chat.input.Update(msg)
+		cmds = append(cmds, cmd)
+	}
+
 	return b, tea.Batch(cmds...)
 }
 
 func (b *Bods) View() string {
+	if b.chat != nil {
+		return b.chatView()
+	}
 	switch b.state {
 	case responseState:
 		if isOutputTerminal() {
@@ -175,953 +258,1016 @@ func (b *Bods) startMessagesCmd(content string) tea.Cmd {
 	// content is piped input e.g. echo "content" | bods
 	logger.Printf("startMessagesCmd: len(content)=%d\n", len(content))
 
-	awsConfig, err := sdkconfig.LoadDefaultConfig(*b.context)
-	if err != nil {
-		msg := fmt.Sprintf("LoadDefaultConfig(): failed to load SDK configuration, %v", err)
-		log.Fatalf("%s", msg)
+	if err := b.initBedrockClients(); err != nil {
+		log.Fatalf("%s", err)
 	}
-	bedrockRuntimeClient = bedrockruntime.NewFromConfig(awsConfig)
-	bedrockClient := bedrock.NewFromConfig(awsConfig)
 
 	return func() tea.Msg {
-		// ORIG LOCATION paramsMessagesAPI := NewAnthropicClaudeMessagesInferenceParameters()
-
-		const defaultMarkdownFormatText = " Format the response as markdown without enclosing backticks."
-
-		// use model as specified in prompt template, unless overridden with '--model' flag
-		promptTemplateModelID, _ := promptTemplateFieldValue[string](b.Config, "ModelID")
-		if b.Config.ModelID == "" && promptTemplateModelID != "" {
-			b.Config.ModelID = promptTemplateModelID
+		request, err := b.buildRequest(content)
+		if err != nil {
+			return err
 		}
-		if b.Config.ModelID == "" { // initialize to default if no modelID given at all
-			// b.Config.ModelID = ClaudeV35SonnetV2.String()
-			// b.Config.ModelID = ClaudeV37Sonnet.String()
-			// b.Config.ModelID = ClaudeV4Sonnet.String()
-			// b.Config.ModelID = ClaudeV45Sonnet.String()
-			// b.Config.ModelID = ClaudeV46Opus.String()
-			b.Config.ModelID = ClaudeV48Opus.String()
+		if len(os.Getenv("DUMP_PROMPT")) > 0 {
+			data, _ := json.MarshalIndent(request.Params, "", "  ")
+			fmt.Println(string(data))
+			os.Exit(0)
 		}
-		logger.Println("config.ModelID set to: ", b.Config.ModelID)
-
-		// top P
-		if topP, ok := promptTemplateFieldValue[float64](b.Config, "TopP"); ok {
-			topPValue := topP
-			paramsMessagesAPI.TopP = &topPValue
+		if b.Config.DryRun {
+			return b.countTokens(request.Params)
 		}
 
-		// For Claude 4.5+ models (Sonnet, Haiku, Opus, and Opus 4.6), only temperature OR top_p can be specified, not both
-		// We keep temperature and set top_p to nil for these models
-		if IsClaude45OrHigherModel(b.Config.ModelID) {
-			paramsMessagesAPI.TopP = nil
-			logger.Println("Excluding top_p for Claude 4.5+ model (only temperature will be used)")
+		eventStream, start, err := newInvoker(b.backend, b.Config).Stream(*b.context, request)
+		if err != nil {
+			logger.Println(err)
+			return bodsError{err, b.invokeErrorReason(err)}
 		}
+		b.startRound(start)
 
-		// top K
-		if topK, ok := promptTemplateFieldValue[int](b.Config, "TopK"); ok {
-			paramsMessagesAPI.TopK = topK
-		}
+		// Return the completionOutput to be processed by Update
+		// before was: 	return b.receiveStreamingMessagesCmd(completionOutput{stream: eventStream})()
+		return completionOutput{stream: eventStream}
+	}
+}
 
-		// For models that reject any non-default sampling parameter (Opus 4.7+),
-		// omit temperature, top_p, and top_k entirely to avoid a 400 error.
-		if IsSamplingParamsRejected(b.Config.ModelID) {
-			paramsMessagesAPI.Temperature = nil
-			paramsMessagesAPI.TopP = nil
-			paramsMessagesAPI.TopK = 0
-			logger.Println("Excluding temperature, top_p, and top_k for model that rejects sampling params (Opus 4.7+)")
-		}
+// initBedrockClients creates the Bedrock clients from the default AWS configuration;
+// the Anthropic API needs no AWS configuration.
+func (b *Bods) initBedrockClients() error {
+	if b.Config.Provider == providerAnthropic {
+		return nil
+	}
+	awsConfig, err := sdkconfig.LoadDefaultConfig(*b.context)
+	if err != nil {
+		return fmt.Errorf("LoadDefaultConfig(): failed to load SDK configuration, %v", err)
+	}
+	bedrockRuntimeClient = bedrockruntime.NewFromConfig(awsConfig, func(o *bedrockruntime.Options) {
+		o.Retryer = aws.NopRetryer{} // invocations are retried by the invoker, see --retries
+	})
+	b.bedrockClient = bedrock.NewFromConfig(awsConfig)
+	b.awsRegion = awsConfig.Region
+	return nil
+}
 
-		// effort from prompt template
-		if effortLevel, ok := promptTemplateFieldValue[string](b.Config, "Effort"); ok && effortLevel != "" {
-			b.Config.Effort = effortLevel
-		}
-		// Note: CLI flag will override if set (already bound by cobra)
-
-		// set thinking config for Claude 3.7 if --think flag is enabled
-		if !b.Config.Think { // if not set validate if set in prompt template
-			if b.Config.PromptTemplate != "" {
-				for _, p := range config.Prompts {
-					if p.Name == b.Config.PromptTemplate && p.Thinking {
-						b.Config.Think = true
-					}
-				}
+// buildRequest builds the request of a run from the configuration, the prompt
+// template and the content, e.g. piped input: the content blocks are added to the
+// user message of messages, the parameters are set by configureInferenceParameters
+// and the cache breakpoints by cachedParams.
+func (b *Bods) buildRequest(content string) (modelRequest, error) {
+	// index of the user message this run adds content to; not 0 when a saved conversation is continued
+	userMsgIdx := len(messages) - 1
+
+	toolContext, err := b.configureInferenceParameters()
+	if err != nil {
+		return modelRequest{}, err
+	}
+
+	b.backend, err = newBackend(b.Config.Provider, b.Config.API, bedrockRuntimeClient)
+	if err != nil {
+		return modelRequest{}, bodsError{err, "Invalid provider or API."}
+	}
+
+	// currently only available for Haiku 3.5 in us-east-2
+	// see https://docs.aws.amazon.com/bedrock/latest/userguide/latency-optimized-inference.html
+	performanceConfiguration := types.PerformanceConfiguration{Latency: types.PerformanceConfigLatencyStandard}
+	if b.Config.ModelID == "us.anthropic.claude-3-5-haiku-20241022-v1:0" && b.awsRegion == "us-east-2" {
+		performanceConfiguration.Latency = types.PerformanceConfigLatencyOptimized
+		logger.Println("set performance configuration latency to '", performanceConfiguration.Latency, "'")
+	}
+
+	// e.g. echo 'Summarize following text'(=prefix) | bods < file(=content)
+	// if a prompt template was given (--prompt) and the template has a 'user'
+	// prompt, pre-pend the prefix with the user prompt from the template
+	user := ""
+	if b.Config.PromptTemplate != "" {
+		for _, p := range config.Prompts {
+			if p.Name == config.PromptTemplate {
+				user = p.User // could be empty TODO
 			}
 		}
-		// set text editor config if --text-editor flag is enabled or in prompt template
-		if !b.Config.EnableTextEditor { // if not set via CLI flag, check prompt template
-			if b.Config.PromptTemplate != "" {
-				for _, p := range config.Prompts {
-					if p.Name == b.Config.PromptTemplate && p.TextEditor {
-						b.Config.EnableTextEditor = true
-					}
-				}
-			}
+	}
+
+	// replace user prompt input variables e.g. {{.TASK}} with collected input values
+	if config.UserPromptInputs != nil {
+		var replacedPrompt bytes.Buffer
+		tmpl, err := template.New("userPromptTemplate").Parse(user)
+		if err != nil {
+			panic(err)
 		}
-		logger.Printf("b.Config.Think=%t b.Config.EnableTextEditor=%t b.Config.ModelID=%s", b.Config.Think, b.Config.EnableTextEditor, b.Config.ModelID)
+		err = tmpl.Execute(&replacedPrompt, b.Config.UserPromptInputs)
+		if err != nil {
+			panic(err)
+		}
+		user = replacedPrompt.String()
+	}
 
-		normalizedModelID := normalizeToModelID(b.Config.ModelID)
-		if b.Config.Think && (normalizedModelID == ClaudeV37Sonnet.String() || normalizedModelID == ClaudeV4Sonnet.String() || normalizedModelID == ClaudeV4Opus.String() || normalizedModelID == ClaudeV45Sonnet.String() || normalizedModelID == ClaudeV45Haiku.String() || normalizedModelID == ClaudeV45Opus.String() || normalizedModelID == ClaudeV46Opus.String() || normalizedModelID == ClaudeV47Opus.String() || normalizedModelID == ClaudeV46Sonnet.String() || normalizedModelID == ClaudeV48Opus.String()) {
-			if IsAdaptiveThinkingModel(normalizedModelID) {
-				paramsMessagesAPI.Thinking = NewAdaptiveThinkingConfig()
-				logger.Println("enabled adaptive thinking for", normalizedModelID)
-			} else {
-				paramsMessagesAPI.Thinking = NewThinkingConfig()
-				logger.Println("enabled thinking feature for Claude 3.7")
-				if budget, ok := promptTemplateFieldValue[int](b.Config, "BudgetTokens"); ok {
-					paramsMessagesAPI.Thinking.BudgetTokens = budget
-				}
-				if b.Config.BudgetTokens != 0 { // override with command line flag value if given
+	// prefix = combined user prompt + Config.Prefix
+	// TODO delete prefix := fmt.Sprintf("%s %s", user, b.Config.Prefix)
 
-					if b.Config.BudgetTokens < mininumThinkingTokens {
-						e := fmt.Errorf("%d is less than the minimum budget tokens size of 1024 tokens. Anthropic suggests trying at least 4000 tokens to achieve more comprehensive and nuanced reasoning", b.Config.BudgetTokens)
-						return bodsError{e, "BudgetTokens"}
-					}
-					paramsMessagesAPI.Thinking.BudgetTokens = b.Config.BudgetTokens
-				}
+	// set assistant role from prompt template
+	assistant := ""
+	if b.Config.PromptTemplate != "" {
+		for _, p := range config.Prompts {
+			if p.Name == b.Config.PromptTemplate && p.Assistant != "" {
+				assistant = p.Assistant
 			}
 		}
+	}
+	if b.Config.Assistant != "" { // override if explicitey provided with '--assistant'
+		assistant = b.Config.Assistant
+	}
+	// for Claude2, system prompt is included in the user prompt
+	var system string
+	if !IsClaude3OrHigherModelID(b.Config.ModelID) {
+		system = b.Config.SystemPrompt
+	}
+
+	// use CRIS is available
+	b.resolveInferenceProfile()
+
+	format := ""
+	if b.Config.Format {
+		format = defaultMarkdownFormatText
+	} else {
+		format = " \n . \n "
+	}
+
+	// ORIG LOCATION messages := []Message{{Role: MessageRoleUser}}
+
+	if b.Config.Pasteboard {
+		// NEW START
+		processor := NewPasteboardProcessor(&config)
 
-		// max tokens
-		if maxTokens, ok := promptTemplateFieldValue[int](b.Config, "MaxTokens"); ok {
-			paramsMessagesAPI.MaxTokens = maxTokens
+		pasteboardContents, err := processor.ProcessPasteboard()
+		if err != nil {
+			logger.Printf("Error processing pasteboard: %v", err)
+			return modelRequest{}, bodsError{err, "Pasteboard"}
 		}
-		if b.Config.MaxTokens != 0 { // override with command line flag value if given
-			paramsMessagesAPI.MaxTokens = b.Config.MaxTokens
+
+		if len(pasteboardContents) > 0 {
+			messages[userMsgIdx].Content = append(messages[userMsgIdx].Content, pasteboardContents...)
+			logger.Printf("Added %d content items from pasteboard", len(pasteboardContents))
 		}
-		if IsAdaptiveThinkingModel(normalizedModelID) && b.Config.BudgetTokens != 0 {
-			logger.Printf("WARNING: --budget flag is ignored for %s (uses adaptive thinking); use --effort instead\n", normalizedModelID)
+		// NEW END
+
+		// OLD START
+		// t := pasteboard.GetContentType()
+		// logger.Printf("pasteboard type=%s", t)
+		//
+		// if t == MessageContentTypeMediaTypePNG || t == MessageContentTypeMediaTypeGIF || t == MessageContentTypeMediaTypeWEBP {
+		// 	if !IsVisionCapable(b.Config.ModelID) {
+		// 		e := fmt.Errorf("%s: model does not have vision capability that allows Claude to understand and analyze images", b.Config.ModelID)
+		// 		return bodsError{e, "Pasteboard"}
+		// 	}
+		//
+		// 	imgBytes := pasteboard.Read()
+		// 	if imgBytes == nil {
+		// 		logger.Println("could not read image from pasteboard")
+		// 		return bodsError{errors.New("there was a problem reading the image from the clipboard. Did you copy an image to the clipboard?"), "Pasteboard"}
+		// 	}
+		//
+		// 	imgType := http.DetectContentType(imgBytes)
+		// 	if !slices.Contains(MessageContentTypes, imgType) {
+		// 		panic("unsupported image type " + imgType)
+		// 	}
+		//
+		// 	img, format, err := image.Decode(bytes.NewReader(imgBytes))
+		// 	if err != nil {
+		// 		panic("could not decode image " + imgType)
+		// 	}
+		//
+		// 	isValidSize, msg := validateImageDimension(img, format)
+		// 	if !isValidSize {
+		// 		e := fmt.Errorf("%s", msg)
+		// 		return bodsError{e, "ImageSize"}
+		// 	}
+		//
+		// 	messages[0].Content = append(messages[0].Content, imgToMessageContent(imgBytes, imgType))
+		// }
+		// OLD END
+	}
+
+	if config.ImageContent != nil {
+		logger.Println("adding images from config.ImageContent")
+		messages[userMsgIdx].Content = append(messages[userMsgIdx].Content, config.ImageContent...)
+	}
+
+	// used replaced content in metaprompt mode
+	promptContent := content
+	if b.Config.Metamode {
+		promptContent = b.Config.Content
+	}
+
+	// Build structured content array instead of concatenated string
+	// This enables prompt caching by separating logical components into individual content blocks,
+	// the cache breakpoints are placed by planCache before each request
+	var contentBlocks []Content
+
+	// helper function to create a standard user prompt text content
+	createUserTextContent := func(text string) Content {
+		trimmedText := strings.TrimSpace(text)
+		c := Content{
+			Type: MessageContentTypeText,
+			Text: trimmedText,
 		}
-		if !IsAdaptiveThinkingModel(normalizedModelID) && paramsMessagesAPI.MaxTokens <= b.Config.BudgetTokens {
-			e := fmt.Errorf("%d <= %d: Thinking budget tokens must always be less than the max tokens", paramsMessagesAPI.MaxTokens, b.Config.BudgetTokens)
-			return bodsError{e, "Tokens"}
+		// Ensure text field is never empty for text content type
+		if c.Text == "" {
+			c.Text = " " // Use space to avoid validation errors
 		}
+		return c
+	}
 
-		// Add text editor tool if enabled
-		textEditorContext := ""
-		if b.Config.EnableTextEditor {
-			modelID := normalizeToModelID(b.Config.ModelID)
-			// Text editor tool is only supported by Claude 3.5v2 Sonnet, Claude 3.7 Sonnet, Claude 4, Claude 4.5, Claude 4.6, Claude 4.7, and Claude 4.8
-			if modelID == ClaudeV35SonnetV2.String() || modelID == ClaudeV37Sonnet.String() || modelID == ClaudeV4Sonnet.String() || modelID == ClaudeV4Opus.String() || modelID == ClaudeV45Sonnet.String() || modelID == ClaudeV45Haiku.String() || modelID == ClaudeV45Opus.String() || modelID == ClaudeV46Opus.String() || modelID == ClaudeV47Opus.String() || modelID == ClaudeV48Opus.String() {
-
-				switch {
-				case modelID == ClaudeV35SonnetV2.String():
-					paramsMessagesAPI.AnthropicBeta = append(paramsMessagesAPI.AnthropicBeta, "computer-use-2024-10-22")
-				case (modelID == ClaudeV4Sonnet.String() || modelID == ClaudeV4Opus.String() || modelID == ClaudeV45Sonnet.String() || modelID == ClaudeV45Haiku.String() || modelID == ClaudeV45Opus.String() || modelID == ClaudeV46Opus.String() || modelID == ClaudeV47Opus.String() || modelID == ClaudeV48Opus.String()) && b.Config.Think:
-					paramsMessagesAPI.AnthropicBeta = append(paramsMessagesAPI.AnthropicBeta, "interleaved-thinking-2025-05-14")
-				default: // for Claude 3.7
-					paramsMessagesAPI.AnthropicBeta = append(paramsMessagesAPI.AnthropicBeta, "token-efficient-tools-2025-02-19")
-				}
+	// 1. System prompt (if present and not using Claude 3+ system field)
+	// Only add to content if not handled by paramsMessagesAPI.System
+	if system != "" && !IsClaude3OrHigherModelID(b.Config.ModelID) {
+		c := Content{
+			Type: MessageContentTypeText,
+			Text: system,
+		}
+		contentBlocks = append(contentBlocks, c)
+	}
 
-				toolDef := NewTextEditorToolDefinition(modelID)
-				paramsMessagesAPI.Tools = append(paramsMessagesAPI.Tools, toolDef)
-				logger.Printf("Enabled text editor tool for model %s with tool type %s\n", modelID, toolDef.Type)
+	// 2. Main content (piped input or metamode content)
+	if promptContent != "" {
 
-				environmentInfo := func() string {
-					wd, err := os.Getwd()
-					if err != nil {
-						return "Error getting working directory: " + err.Error()
-					}
+		contentType, _ := getContentTypeFromString(promptContent)
 
-					isGitRepo := "No"
-					_, err = os.Stat(filepath.Join(wd, ".git"))
-					if err == nil {
-						isGitRepo = "Yes"
-					}
+		logger.Printf("getContentTypeFromString(promptContent) = %s\n", contentType)
 
-					var sb strings.Builder
-					sb.WriteString("\nHere is useful information about the environment you are running in:\n\n<env>\n")
-					fmt.Fprintf(&sb, "Working directory: %s\n", wd)
-					fmt.Fprintf(&sb, "Is directory a git repo: %s\n", isGitRepo)
-					fmt.Fprintf(&sb, "Platform: %s\n", runtime.GOOS)
-					fmt.Fprintf(&sb, "Today's date: %s\n", time.Now().Format("1/2/2006"))
-					sb.WriteString("</env>\n\n")
+		promptContentIsImage := false
+		if slices.Contains(MessageContentTypes, contentType) && contentType != MessageContentTypeMediaTypePDF {
+			imgBytes := []byte(promptContent)
+			img, imgType, _ := validateAndDecodeImage(imgBytes)
+			if img != nil {
+				promptContentIsImage = true
+				c := imgToMessageContent(imgBytes, imgType)
+				contentBlocks = append(contentBlocks, c)
+			}
+		}
 
-					directoryContext := ToolWorkingDirectoryContext()
-					logger.Println(directoryContext)
-					sb.WriteString(directoryContext)
+		// try and extract PDF content in whole sting e.g. from   bods "summarize" < file.pdf
+		// See also: https://aws.amazon.com/about-aws/whats-new/2025/06/citations-api-pdf-claude-models-amazon-bedrock/
+		// TODO: check Citations API and PDF support for Claude are available for Claude Opus 4, Claude Sonnet 4, Claude Sonnet 3.7, Claude Sonnet 3.5v2.
+		var pdfBytes [][]byte
+		var promptTextContent string
+		if !promptContentIsImage {
+			pdfBytes, promptTextContent = ExtractMultiplePDFsFromString(promptContent)
+		}
 
-					return sb.String()
-				}
-				textEditorContext = environmentInfo()
+		logger.Printf("extracted %d pdf documents\n", len(pdfBytes))
 
-			} else {
-				logger.Printf("Text editor tool is not supported for model %s, ignoring\n", modelID)
-			}
+		if len(pdfBytes) > 0 && !IsPDFCapable(b.Config.ModelID) {
+			return modelRequest{}, bodsError{fmt.Errorf("%s: model does not support PDF documents", b.Config.ModelID), "PDF"}
 		}
+		if len(pdfBytes) > 0 { // one or more pdfs detected
 
-		// Add effort parameter support for Claude Opus 4.5/4.6/4.7/4.8
-		if b.Config.Effort != "" {
-			const errLabelEffortParameter = "EffortParameter"
+			for _, pdfData := range pdfBytes {
+				if pdfData != nil && validatePDF(pdfData) == nil {
 
-			// Normalize to lowercase
-			b.Config.Effort = strings.ToLower(b.Config.Effort)
+					b64Pdf := base64.StdEncoding.EncodeToString(pdfData)
+					s := Source{
+						Type:      SourceTypeBase64,
+						MediaType: MessageContentTypeMediaTypePDF, // "application/pdf"
+						Data:      b64Pdf,
+					}
+					pdfContent := Content{
+						Type:   MessageContentTypeDocument,
+						Source: &s,
+						Citations: &Citations{
+							Enabled: false,
+						},
+					}
+					contentBlocks = append(contentBlocks, pdfContent)
 
-			// Validate model support
-			normalizedModelID := normalizeToModelID(b.Config.ModelID)
-			if !IsEffortParamSupported(normalizedModelID) {
-				e := fmt.Errorf("effort parameter is only supported by Claude Opus 4.5/4.6/4.7/4.8 (model IDs: %s, %s, %s, %s), but you are using: %s",
-					ClaudeV45Opus.String(), ClaudeV46Opus.String(), ClaudeV47Opus.String(), ClaudeV48Opus.String(), b.Config.ModelID)
-				return bodsError{e, errLabelEffortParameter}
+				} else { // not a valid pdf document, just append this invalid pdf data as text content
+					logger.Printf("no pdf or no valid pdf content, appending invalid pdfData as user prompt text input\n")
+					c := createUserTextContent(string(pdfData))
+					contentBlocks = append(contentBlocks, c)
+				}
 			}
 
-			// Validate effort value
-			validEffortLevels := []string{EffortMax, EffortXHigh, EffortHigh, EffortMedium, EffortLow}
-			if !slices.Contains(validEffortLevels, b.Config.Effort) {
-				e := fmt.Errorf("invalid effort level '%s'. Valid values are: max, xhigh, high, medium, low", b.Config.Effort)
-				return bodsError{e, errLabelEffortParameter}
-			}
+			// check if 'leftover' is potentially a piped in / redirected image; if not just append text
+			if promptTextContent != "" {
+				promptTextContent = strings.TrimLeftFunc(promptTextContent, unicode.IsSpace)
+				maxChars := min(len(promptTextContent), 100)
 
-			// Validate "max" is only used with Opus 4.6, 4.7, or 4.8
-			if b.Config.Effort == EffortMax && !IsOpus46Model(normalizedModelID) && !IsOpus47Model(normalizedModelID) && !IsOpus48Model(normalizedModelID) {
-				e := fmt.Errorf("effort level 'max' is only supported by Claude Opus 4.6, 4.7, and 4.8, but you are using: %s", b.Config.ModelID)
-				return bodsError{e, errLabelEffortParameter}
-			}
+				contentType, _ := getContentTypeFromString(promptTextContent)
 
-			// Validate "xhigh" is only used with Opus 4.7 or 4.8
-			if b.Config.Effort == EffortXHigh && !IsOpus47Model(normalizedModelID) && !IsOpus48Model(normalizedModelID) {
-				e := fmt.Errorf("effort level 'xhigh' is only supported by Claude Opus 4.7 and 4.8, but you are using: %s", b.Config.ModelID)
-				return bodsError{e, errLabelEffortParameter}
-			}
+				logger.Printf("remaining content from pdf extract contentType=%s promptTextContent=%s\n", contentType, promptTextContent[:maxChars])
 
-			// Beta header for effort is only required for Opus 4.5 (private beta).
-			// Opus 4.6, 4.7, and 4.8 use the effort parameter natively without a beta header.
-			if normalizedModelID == ClaudeV45Opus.String() {
-				if !slices.Contains(paramsMessagesAPI.AnthropicBeta, "effort-2025-11-24") {
-					paramsMessagesAPI.AnthropicBeta = append(paramsMessagesAPI.AnthropicBeta, "effort-2025-11-24")
+				promptTextContentIsImage := false
+				if slices.Contains(MessageContentTypes, contentType) && contentType != MessageContentTypeMediaTypePDF {
+					imgBytes := []byte(promptTextContent)
+					img, imgType, _ := validateAndDecodeImage(imgBytes)
+					if img != nil {
+						promptTextContentIsImage = true
+						c := imgToMessageContent(imgBytes, imgType)
+						contentBlocks = append(contentBlocks, c)
+					}
 				}
-			}
-
-			// Set output config
-			paramsMessagesAPI.OutputConfig = &OutputConfig{
-				Effort: b.Config.Effort,
-			}
 
-			// At 'xhigh'/'max' effort the model needs a large output budget for
-			// thinking plus tool calls. Raise the ceiling when the user did not
-			// set one explicitly (via --tokens or a prompt template). See opus47vision.md.
-			if b.Config.Effort == EffortXHigh || b.Config.Effort == EffortMax {
-				_, maxTokensFromTemplate := promptTemplateFieldValue[int](b.Config, "MaxTokens")
-				explicitMaxTokens := b.Config.MaxTokens != 0 || maxTokensFromTemplate
-				const highEffortMaxTokensFloor = 32768
-				if !explicitMaxTokens && paramsMessagesAPI.MaxTokens < highEffortMaxTokensFloor {
-					logger.Printf("raising max_tokens from %d to %d for '%s' effort (no explicit --tokens set)\n", paramsMessagesAPI.MaxTokens, highEffortMaxTokensFloor, b.Config.Effort)
-					paramsMessagesAPI.MaxTokens = highEffortMaxTokensFloor
+				if !promptTextContentIsImage && strings.TrimSpace(promptTextContent) != "" {
+					logger.Println("appending remaining promptTextConent as user text content")
+					c := createUserTextContent(promptTextContent)
+					contentBlocks = append(contentBlocks, c)
 				}
 			}
-
-			logger.Printf("Set effort level to '%s' for %s\n", b.Config.Effort, normalizedModelID)
 		}
 
-		// currently only available for Haiku 3.5 in us-east-2
-		// see https://docs.aws.amazon.com/bedrock/latest/userguide/latency-optimized-inference.html
-		performanceConfiguration := types.PerformanceConfiguration{Latency: types.PerformanceConfigLatencyStandard}
-		if b.Config.ModelID == "us.anthropic.claude-3-5-haiku-20241022-v1:0" && awsConfig.Region == "us-east-2" {
-			performanceConfiguration.Latency = types.PerformanceConfigLatencyOptimized
-			logger.Println("set performance configuration latency to '", performanceConfiguration.Latency, "'")
+		// else: no PDFs treat as regular text content
+		if len(pdfBytes) == 0 && !promptContentIsImage && strings.TrimSpace(promptContent) != "" {
+			logger.Println("no PDFs treat as regular text content")
+			c := createUserTextContent(promptContent)
+			contentBlocks = append(contentBlocks, c)
 		}
+	}
 
-		// e.g. echo 'Summarize following text'(=prefix) | bods < file(=content)
-		// if a prompt template was given (--prompt) and the template has a 'user'
-		// prompt, pre-pend the prefix with the user prompt from the template
-		user := ""
-		if b.Config.PromptTemplate != "" {
-			for _, p := range config.Prompts {
-				if p.Name == config.PromptTemplate {
-					user = p.User // could be empty TODO
-				}
-			}
+	// 3. User/template prefix (combined user prompt + Config.Prefix)
+	// previously above:    prefix := fmt.Sprintf("%s %s", user, b.Config.Prefix)
+	if strings.TrimSpace(user) != "" {
+		contentBlocks = append(contentBlocks, Content{
+			Type: MessageContentTypeText,
+			Text: strings.TrimSpace(user),
+		})
+	}
+	if strings.TrimSpace(b.Config.Prefix) != "" {
+		contentBlocks = append(contentBlocks, Content{
+			Type: MessageContentTypeText,
+			Text: strings.TrimSpace(b.Config.Prefix),
+		})
+	}
+
+	// 4. Tool context (environment info)
+	if strings.TrimSpace(toolContext) != "" {
+		trimmedText := strings.TrimSpace(toolContext)
+		c := Content{
+			Type: MessageContentTypeText,
+			Text: trimmedText,
 		}
+		// Ensure text field is never empty
+		if c.Text == "" {
+			c.Text = " " // Use space to avoid validation errors
+		}
+		contentBlocks = append(contentBlocks, c)
+	}
 
-		// replace user prompt input variables e.g. {{.TASK}} with collected input values
-		if config.UserPromptInputs != nil {
-			var replacedPrompt bytes.Buffer
-			tmpl, err := template.New("userPromptTemplate").Parse(user)
-			if err != nil {
-				panic(err)
-			}
-			err = tmpl.Execute(&replacedPrompt, b.Config.UserPromptInputs)
-			if err != nil {
-				panic(err)
-			}
-			user = replacedPrompt.String()
+	// 5. Format instructions
+	if strings.TrimSpace(format) != "" {
+		contentBlocks = append(contentBlocks, Content{
+			Type: MessageContentTypeText,
+			Text: strings.TrimSpace(format),
+		})
+	}
+
+	// Add all content blocks to the message
+	messages[userMsgIdx].Content = append(messages[userMsgIdx].Content, contentBlocks...)
+
+	if strings.TrimSpace(assistant) != "" {
+		if IsAdaptiveThinkingModel(normalizeToModelID(b.Config.ModelID)) {
+			e := fmt.Errorf("assistant message prefilling is not supported by %s (returns 400 error)", b.Config.ModelID)
+			return modelRequest{}, bodsError{e, "AssistantPrefill"}
 		}
+		messages = append(messages,
+			Message{
+				Role: MessageRoleAssistant,
+				Content: []Content{
+					{
+						Type: MessageContentTypeText,
+						Text: strings.TrimRight(assistant, "\n"),
+					},
+				},
+			})
+	}
+
+	paramsMessagesAPI.Messages = messages
+	b.responseIdx = len(messages)
+	params := b.cachedParams()
+
+	body, err := json.Marshal(params)
+	if err != nil {
+		panic(err)
+	}
+	// logger.Printf("body=%v\n", spew.Sdump(paramsMessagesAPI))
+	logger.Printf("string body=%v\n", string(body))
+
+	return modelRequest{
+		ModelID:   b.Config.ModelID,
+		Params:    params,
+		Latency:   performanceConfiguration.Latency,
+		Guardrail: b.guardrail,
+	}, nil
+}
+
+// configureInferenceParameters (re)builds paramsMessagesAPI from the current config and
+// prompt template: model, sampling parameters, thinking, max tokens, tools, effort and
+// system prompt. It can be called again whenever settings change, e.g. by a chat slash
+// command. The returned text describes the environment if a tool is enabled.
+func (b *Bods) configureInferenceParameters() (string, error) {
+	paramsMessagesAPI = NewAnthropicClaudeMessagesInferenceParameters()
+	toolRegistry = NewToolRegistry()
+
+	// use model as specified in prompt template, unless overridden with '--model' flag
+	promptTemplateModelID, _ := promptTemplateFieldValue[string](b.Config, "ModelID")
+	if b.Config.ModelID == "" && promptTemplateModelID != "" {
+		b.Config.ModelID = promptTemplateModelID
+	}
+	if b.Config.ModelID == "" { // initialize to default if no modelID given at all
+		b.Config.ModelID = modelRegistry.DefaultModel
+	}
+	b.Config.ModelID = modelRegistry.ResolveAlias(b.Config.ModelID) // e.g. -m opus
+	logger.Println("config.ModelID set to: ", b.Config.ModelID)
+
+	// top P
+	if topP, ok := promptTemplateFieldValue[float64](b.Config, "TopP"); ok {
+		topPValue := topP
+		paramsMessagesAPI.TopP = &topPValue
+	}
+
+	// For Claude 4.5+ models (Sonnet, Haiku, Opus, and Opus 4.6), only temperature OR top_p can be specified, not both
+	// We keep temperature and set top_p to nil for these models
+	if IsClaude45OrHigherModel(b.Config.ModelID) {
+		paramsMessagesAPI.TopP = nil
+		logger.Println("Excluding top_p for Claude 4.5+ model (only temperature will be used)")
+	}
+
+	// top K
+	if topK, ok := promptTemplateFieldValue[int](b.Config, "TopK"); ok {
+		paramsMessagesAPI.TopK = topK
+	}
+
+	// For models that reject any non-default sampling parameter (Opus 4.7+),
+	// omit temperature, top_p, and top_k entirely to avoid a 400 error.
+	if IsSamplingParamsRejected(b.Config.ModelID) {
+		paramsMessagesAPI.Temperature = nil
+		paramsMessagesAPI.TopP = nil
+		paramsMessagesAPI.TopK = 0
+		logger.Println("Excluding temperature, top_p, and top_k for model that rejects sampling params (Opus 4.7+)")
+	}
 
-		// prefix = combined user prompt + Config.Prefix
-		// TODO delete prefix := fmt.Sprintf("%s %s", user, b.Config.Prefix)
+	// guardrail from prompt template; the flag takes precedence
+	if guardrail, ok := promptTemplateFieldValue[string](b.Config, "Guardrail"); ok && guardrail != "" && b.Config.Guardrail == "" {
+		b.Config.Guardrail = guardrail
+	}
+	guardrail, err := parseGuardrail(b.Config.Guardrail)
+	if err != nil {
+		return "", bodsError{err, "Invalid guardrail."}
+	}
+	b.guardrail = guardrail
 
-		// set assistant role from prompt template
-		assistant := ""
+	b.Config.Cache, b.Config.CacheTTL = cmp.Or(strings.ToLower(b.Config.Cache), cacheAuto), strings.ToLower(b.Config.CacheTTL)
+	if err := validateCacheSettings(b.Config.Cache, b.Config.CacheTTL); err != nil {
+		return "", bodsError{err, "Invalid cache setting."}
+	}
+
+	// effort from prompt template
+	if effortLevel, ok := promptTemplateFieldValue[string](b.Config, "Effort"); ok && effortLevel != "" && b.Config.Effort == "" {
+		b.Config.Effort = effortLevel
+	}
+	// Note: CLI flag overrides the template value if set (already bound by cobra)
+
+	// set thinking config for Claude 3.7 if --think flag is enabled
+	if !b.Config.Think { // if not set validate if set in prompt template
 		if b.Config.PromptTemplate != "" {
 			for _, p := range config.Prompts {
-				if p.Name == b.Config.PromptTemplate && p.Assistant != "" {
-					assistant = p.Assistant
+				if p.Name == b.Config.PromptTemplate && p.Thinking {
+					b.Config.Think = true
 				}
 			}
 		}
-		if b.Config.Assistant != "" { // override if explicitey provided with '--assistant'
-			assistant = b.Config.Assistant
-		}
-
-		// set system prompt from prompt template, unless system prompt
-		// explicitly provided wth '--system'
-		logger.Printf("config.PromptTemplate=%s  config.SystemPrompt=%s\n", config.PromptTemplate, config.SystemPrompt)
-		if b.Config.PromptTemplate != "" && b.Config.SystemPrompt == "" {
+	}
+	// set text editor config if --text-editor flag is enabled or in prompt template
+	if !b.Config.EnableTextEditor { // if not set via CLI flag, check prompt template
+		if b.Config.PromptTemplate != "" {
 			for _, p := range config.Prompts {
-				if p.Name == b.Config.PromptTemplate && p.System != "" {
-					b.Config.SystemPrompt = p.System
+				if p.Name == b.Config.PromptTemplate && p.TextEditor {
+					b.Config.EnableTextEditor = true
 				}
 			}
 		}
+	}
+	logger.Printf("b.Config.Think=%t b.Config.EnableTextEditor=%t b.Config.ModelID=%s", b.Config.Think, b.Config.EnableTextEditor, b.Config.ModelID)
 
-		// system prompts are currently available for use with Claude 3 models and Claude 2.1
-		// for Claude2, system prompt is included in the user prompt
-		var system string
-		if IsClaude3OrHigherModelID(b.Config.ModelID) {
-			paramsMessagesAPI.System = b.Config.SystemPrompt
+	normalizedModelID := normalizeToModelID(b.Config.ModelID)
+	if b.Config.Think && IsThinkingSupported(normalizedModelID) {
+		if IsAdaptiveThinkingModel(normalizedModelID) {
+			paramsMessagesAPI.Thinking = NewAdaptiveThinkingConfig()
+			logger.Println("enabled adaptive thinking for", normalizedModelID)
 		} else {
-			system = b.Config.SystemPrompt
-		}
-
-		// use CRIS is available
-		if b.Config.CrossRegionInference {
-			inferenceProfilID, err := crossRegionInferenceProfileID(*bedrockClient, b.Config.ModelID, awsConfig.Region)
-			if err == nil {
-				logger.Println("replacing config.ModelID=", b.Config.ModelID, " with inference profile id ", inferenceProfilID)
-				b.Config.ModelID = inferenceProfilID
+			paramsMessagesAPI.Thinking = NewThinkingConfig()
+			logger.Println("enabled thinking feature for Claude 3.7")
+			if budget, ok := promptTemplateFieldValue[int](b.Config, "BudgetTokens"); ok {
+				paramsMessagesAPI.Thinking.BudgetTokens = budget
 			}
-		}
+			if b.Config.BudgetTokens != 0 { // override with command line flag value if given
 
-		format := ""
-		if b.Config.Format {
-			format = defaultMarkdownFormatText
-		} else {
-			format = " \n . \n "
+				if b.Config.BudgetTokens < mininumThinkingTokens {
+					e := fmt.Errorf("%d is less than the minimum budget tokens size of 1024 tokens. Anthropic suggests trying at least 4000 tokens to achieve more comprehensive and nuanced reasoning", b.Config.BudgetTokens)
+					return "", bodsError{e, "BudgetTokens"}
+				}
+				paramsMessagesAPI.Thinking.BudgetTokens = b.Config.BudgetTokens
+			}
 		}
+	}
 
-		// ORIG LOCATION messages := []Message{{Role: MessageRoleUser}}
-
-		if b.Config.Pasteboard {
-			// NEW START
-			processor := NewPasteboardProcessor(&config)
+	// max tokens
+	if maxTokens, ok := promptTemplateFieldValue[int](b.Config, "MaxTokens"); ok {
+		paramsMessagesAPI.MaxTokens = maxTokens
+	}
+	if b.Config.MaxTokens != 0 { // override with command line flag value if given
+		paramsMessagesAPI.MaxTokens = b.Config.MaxTokens
+	}
+	if IsAdaptiveThinkingModel(normalizedModelID) && b.Config.BudgetTokens != 0 {
+		logger.Printf("WARNING: --budget flag is ignored for %s (uses adaptive thinking); use --effort instead\n", normalizedModelID)
+	}
+	if !IsAdaptiveThinkingModel(normalizedModelID) && paramsMessagesAPI.MaxTokens <= b.Config.BudgetTokens {
+		e := fmt.Errorf("%d <= %d: Thinking budget tokens must always be less than the max tokens", paramsMessagesAPI.MaxTokens, b.Config.BudgetTokens)
+		return "", bodsError{e, "Tokens"}
+	}
 
-			pasteboardContents, err := processor.ProcessPasteboard()
-			if err != nil {
-				logger.Printf("Error processing pasteboard: %v", err)
-				return bodsError{err, "Pasteboard"}
+	// Add text editor tool if enabled, or declare it for the tool_use blocks of a continued conversation
+	toolContext := ""
+	declareTextEditor := slices.ContainsFunc(b.Config.ConversationTools, func(name string) bool { return name != BashToolName })
+	if b.Config.EnableTextEditor || declareTextEditor {
+		model := lookupModel(b.Config.ModelID)
+		// Text editor tool is only supported by models with a text_editor version in the model registry
+		if model.TextEditor != "" {
+			betas := model.Betas.TextEditor
+			if b.Config.Think {
+				betas = append(slices.Clone(betas), model.Betas.TextEditorThinking...)
 			}
-
-			if len(pasteboardContents) > 0 {
-				messages[0].Content = append(messages[0].Content, pasteboardContents...)
-				logger.Printf("Added %d content items from pasteboard", len(pasteboardContents))
+			for _, beta := range betas {
+				if !slices.Contains(paramsMessagesAPI.AnthropicBeta, beta) {
+					paramsMessagesAPI.AnthropicBeta = append(paramsMessagesAPI.AnthropicBeta, beta)
+				}
 			}
-			// NEW END
-
-			// OLD START
-			// t := pasteboard.GetContentType()
-			// logger.Printf("pasteboard type=%s", t)
-			//
-			// if t == MessageContentTypeMediaTypePNG || t == MessageContentTypeMediaTypeGIF || t == MessageContentTypeMediaTypeWEBP {
-			// 	if !IsVisionCapable(b.Config.ModelID) {
-			// 		e := fmt.Errorf("%s: model does not have vision capability that allows Claude to understand and analyze images", b.Config.ModelID)
-			// 		return bodsError{e, "Pasteboard"}
-			// 	}
-			//
-			// 	imgBytes := pasteboard.Read()
-			// 	if imgBytes == nil {
-			// 		logger.Println("could not read image from pasteboard")
-			// 		return bodsError{errors.New("there was a problem reading the image from the clipboard. Did you copy an image to the clipboard?"), "Pasteboard"}
-			// 	}
-			//
-			// 	imgType := http.DetectContentType(imgBytes)
-			// 	if !slices.Contains(MessageContentTypes, imgType) {
-			// 		panic("unsupported image type " + imgType)
-			// 	}
-			//
-			// 	img, format, err := image.Decode(bytes.NewReader(imgBytes))
-			// 	if err != nil {
-			// 		panic("could not decode image " + imgType)
-			// 	}
-			//
-			// 	isValidSize, msg := validateImageDimension(img, format)
-			// 	if !isValidSize {
-			// 		e := fmt.Errorf("%s", msg)
-			// 		return bodsError{e, "ImageSize"}
-			// 	}
-			//
-			// 	messages[0].Content = append(messages[0].Content, imgToMessageContent(imgBytes, imgType))
-			// }
-			// OLD END
-		}
-
-		if config.ImageContent != nil {
-			logger.Println("adding images from config.ImageContent")
-			messages[0].Content = append(messages[0].Content, config.ImageContent...)
-		}
-
-		// used replaced content in metaprompt mode
-		promptContent := content
-		if b.Config.Metamode {
-			promptContent = b.Config.Content
+		} else {
+			logger.Printf("Text editor tool is not supported for model %s, ignoring\n", b.Config.ModelID)
 		}
 
-		// Build structured content array instead of concatenated string
-		// This enables prompt caching by separating logical components into individual content blocks
-		// Each component can be cached independently when reused across requests
-		var contentBlocks []Content
-
-		// helper function to create a standard user prompt text content
-		createUserTextContentWithCaching := func(text string) Content {
-			trimmedText := strings.TrimSpace(text)
-			c := Content{
-				Type: MessageContentTypeText,
-				Text: trimmedText,
-			}
-			// Ensure text field is never empty for text content type
-			if c.Text == "" {
-				c.Text = " " // Use space to avoid validation errors
+		if b.Config.EnableTextEditor && model.TextEditor != "" {
+			workspace, err := NewWorkspace(b.Config.AllowPaths)
+			if err != nil {
+				return "", bodsError{err, "AllowPath"}
 			}
-
-			// Only add cache control when text is large enough (at least ~1024 tokens)
-			// Claude 3.7 requires at least 1,024 tokens per cache checkpoint
-			// Assuming 1 token ≈ 4-5 characters
-			// See: https://docs.aws.amazon.com/bedrock/latest/userguide/prompt-caching.html
-			minCacheableLength := 5 * 1024 // ~1024 tokens
-			if IsPromptCachingSupported(b.Config.ModelID) && len(c.Text) > minCacheableLength {
-				c.CacheControl = &CacheControl{
-					Type: CacheControlTypeEphemeral,
-				}
+			textEditor := GetTextEditorTool()
+			textEditor.confirmEdits = b.Config.ConfirmEdits
+			textEditor.workspace = workspace
+			textEditor.config = b.Config.TextEditor
+			if textEditor.journal == nil {
+				textEditor.journal = NewEditJournal(b.Config)
 			}
-			return c
+			toolRegistry.Register(textEditor)
+			logger.Printf("Enabled text editor tool %s for model %s\n", model.TextEditor, model.ID)
 		}
+	}
 
-		// helper function to create a standard user prompt text content
-		// createUserPDFContentWithCaching := func(pdf []byte) Content {
-		// 	b64Pdf := base64.StdEncoding.EncodeToString(pdf)
-		// 	s := Source{
-		// 		Type:      "base64",
-		// 		MediaType: MessageContentTypeMediaTypePDF, // "application/pdf"
-		// 		Data:      b64Pdf,
-		// 	}
-		// 	pdfContent := Content{
-		// 		Type:   MessageContentTypeDocument,
-		// 		Source: &s,
-		// 		Citations: &Citations{
-		// 			Enabled: false,
-		// 		},
-		// 	}
-		//
-		// 	if IsPromptCachingSupported(b.Config.ModelID) {
-		// 		pdfContent.CacheControl = &CacheControl{
-		// 			Type: "ephemeral",
-		// 		}
-		// 	}
-		// 	return pdfContent
-		// }
-
-		// 1. System prompt (if present and not using Claude 3+ system field)
-		// Only add to content if not handled by paramsMessagesAPI.System
-		if system != "" && !IsClaude3OrHigherModelID(b.Config.ModelID) {
-			c := Content{
-				Type: MessageContentTypeText,
-				Text: system,
+	// set bash tool config if --bash flag is enabled or in prompt template
+	if !b.Config.EnableBash && b.Config.PromptTemplate != "" {
+		for _, p := range config.Prompts {
+			if p.Name == b.Config.PromptTemplate && p.Bash {
+				b.Config.EnableBash = true
 			}
-			contentBlocks = append(contentBlocks, c)
 		}
+	}
+	if b.Config.EnableBash {
+		toolRegistry.Register(NewBashTool(b.Config))
+		logger.Println("Enabled bash tool")
+	}
+	for _, name := range b.Config.ConversationTools { // not executed unless enabled, see --bash and --text-editor
+		switch {
+		case name == BashToolName:
+			toolRegistry.Declare(NewBashTool(b.Config))
+		case lookupModel(b.Config.ModelID).TextEditor != "":
+			toolRegistry.Declare(GetTextEditorTool())
+		}
+		logger.Printf("declared tool %s of the continued conversation\n", name)
+	}
 
-		// 2. Main content (piped input or metamode content)
-		if promptContent != "" {
-
-			contentType, _ := getContentTypeFromString(promptContent)
-
-			logger.Printf("getContentTypeFromString(promptContent) = %s\n", contentType)
-
-			promptContentIsImage := false
-			if slices.Contains(MessageContentTypes, contentType) && contentType != MessageContentTypeMediaTypePDF {
-				imgBytes := []byte(promptContent)
-				img, imgType, _ := validateAndDecodeImage(imgBytes)
-				if img != nil {
-					promptContentIsImage = true
-					c := imgToMessageContent(imgBytes, imgType)
-					contentBlocks = append(contentBlocks, c)
-				}
+	if toolRegistry.Len() > 0 {
+		environmentInfo := func() string {
+			wd, err := os.Getwd()
+			if err != nil {
+				return "Error getting working directory: " + err.Error()
 			}
 
-			// try and extract PDF content in whole sting e.g. from   bods "summarize" < file.pdf
-			// See also: https://aws.amazon.com/about-aws/whats-new/2025/06/citations-api-pdf-claude-models-amazon-bedrock/
-			// TODO: check Citations API and PDF support for Claude are available for Claude Opus 4, Claude Sonnet 4, Claude Sonnet 3.7, Claude Sonnet 3.5v2.
-			var pdfBytes [][]byte
-			var promptTextContent string
-			if !promptContentIsImage {
-				pdfBytes, promptTextContent = ExtractMultiplePDFsFromString(promptContent)
+			isGitRepo := "No"
+			_, err = os.Stat(filepath.Join(wd, ".git"))
+			if err == nil {
+				isGitRepo = "Yes"
 			}
 
-			logger.Printf("extracted %d pdf documents\n", len(pdfBytes))
-
-			if len(pdfBytes) > 0 { // one or more pdfs detected
-
-				for _, pdfData := range pdfBytes {
-					if pdfData != nil && validatePDF(pdfData) == nil {
-
-						b64Pdf := base64.StdEncoding.EncodeToString(pdfData)
-						s := Source{
-							Type:      SourceTypeBase64,
-							MediaType: MessageContentTypeMediaTypePDF, // "application/pdf"
-							Data:      b64Pdf,
-						}
-						pdfContent := Content{
-							Type:   MessageContentTypeDocument,
-							Source: &s,
-							Citations: &Citations{
-								Enabled: false,
-							},
-						}
+			var sb strings.Builder
+			sb.WriteString("\nHere is useful information about the environment you are running in:\n\n<env>\n")
+			fmt.Fprintf(&sb, "Working directory: %s\n", wd)
+			fmt.Fprintf(&sb, "Is directory a git repo: %s\n", isGitRepo)
+			if len(b.Config.AllowPaths) > 0 && b.Config.EnableTextEditor {
+				fmt.Fprintf(&sb, "Directories the text editor can access: %s\n", strings.Join(GetTextEditorTool().workspace.Roots(), ", "))
+			}
+			fmt.Fprintf(&sb, "Platform: %s\n", runtime.GOOS)
+			fmt.Fprintf(&sb, "Today's date: %s\n", time.Now().Format("1/2/2006"))
+			sb.WriteString("</env>\n\n")
 
-						if IsPromptCachingSupported(b.Config.ModelID) {
-							pdfContent.CacheControl = &CacheControl{
-								Type: CacheControlTypeEphemeral,
-							}
-						}
-						contentBlocks = append(contentBlocks, pdfContent)
+			directoryContext := ToolWorkingDirectoryContext()
+			logger.Println(directoryContext)
+			sb.WriteString(directoryContext)
 
-					} else { // not a valid pdf document, just append this invalid pdf data as text content
-						logger.Printf("no pdf or no valid pdf content, appending invalid pdfData as user prompt text input\n")
-						c := createUserTextContentWithCaching(string(pdfData))
-						contentBlocks = append(contentBlocks, c)
-					}
-				}
+			return sb.String()
+		}
+		toolContext = environmentInfo()
+	}
 
-				// check if 'leftover' is potentially a piped in / redirected image; if not just append text
-				if promptTextContent != "" {
-					promptTextContent = strings.TrimLeftFunc(promptTextContent, unicode.IsSpace)
-					maxChars := min(len(promptTextContent), 100)
+	if err := b.configureSchema(); err != nil {
+		return "", bodsError{err, "Invalid response schema."}
+	}
 
-					contentType, _ := getContentTypeFromString(promptTextContent)
+	for _, toolDef := range toolRegistry.Definitions(normalizeToModelID(b.Config.ModelID)) {
+		paramsMessagesAPI.Tools = append(paramsMessagesAPI.Tools, toolDef)
+		logger.Printf("added tool definition name=%s type=%s\n", toolDef.Name, toolDef.Type)
+	}
 
-					logger.Printf("remaining content from pdf extract contentType=%s promptTextContent=%s\n", contentType, promptTextContent[:maxChars])
+	// Add effort parameter support for Claude Opus 4.5/4.6/4.7/4.8
+	if b.Config.Effort != "" {
+		const errLabelEffortParameter = "EffortParameter"
 
-					promptTextContentIsImage := false
-					if slices.Contains(MessageContentTypes, contentType) && contentType != MessageContentTypeMediaTypePDF {
-						imgBytes := []byte(promptTextContent)
-						img, imgType, _ := validateAndDecodeImage(imgBytes)
-						if img != nil {
-							promptTextContentIsImage = true
-							c := imgToMessageContent(imgBytes, imgType)
-							contentBlocks = append(contentBlocks, c)
-						}
-					}
+		// Normalize to lowercase
+		b.Config.Effort = strings.ToLower(b.Config.Effort)
 
-					if !promptTextContentIsImage && strings.TrimSpace(promptTextContent) != "" {
-						logger.Println("appending remaining promptTextConent as user text content")
-						c := createUserTextContentWithCaching(promptTextContent)
-						contentBlocks = append(contentBlocks, c)
-					}
+		// Validate model support
+		normalizedModelID := normalizeToModelID(b.Config.ModelID)
+		model := lookupModel(normalizedModelID)
+		if !model.SupportsEffort("") {
+			var supported []string
+			for _, m := range modelRegistry.Models {
+				if m.SupportsEffort("") {
+					supported = append(supported, m.ID)
 				}
 			}
-
-			// else: no PDFs treat as regular text content
-			if len(pdfBytes) == 0 && !promptContentIsImage && strings.TrimSpace(promptContent) != "" {
-				logger.Println("no PDFs treat as regular text content")
-				c := createUserTextContentWithCaching(promptContent)
-				contentBlocks = append(contentBlocks, c)
-			}
-		}
-
-		// 3. User/template prefix (combined user prompt + Config.Prefix)
-		// previously above:    prefix := fmt.Sprintf("%s %s", user, b.Config.Prefix)
-		if strings.TrimSpace(user) != "" {
-			contentBlocks = append(contentBlocks, Content{
-				Type: MessageContentTypeText,
-				Text: strings.TrimSpace(user),
-			})
-		}
-		if strings.TrimSpace(b.Config.Prefix) != "" {
-			contentBlocks = append(contentBlocks, Content{
-				Type: MessageContentTypeText,
-				Text: strings.TrimSpace(b.Config.Prefix),
-			})
+			e := fmt.Errorf("effort parameter is only supported by %s, but you are using: %s", strings.Join(supported, ", "), b.Config.ModelID)
+			return "", bodsError{e, errLabelEffortParameter}
 		}
 
-		// 4. Text editor context (environment info)
-		if strings.TrimSpace(textEditorContext) != "" {
-			trimmedText := strings.TrimSpace(textEditorContext)
-			c := Content{
-				Type: MessageContentTypeText,
-				Text: trimmedText,
-			}
-			// Ensure text field is never empty
-			if c.Text == "" {
-				c.Text = " " // Use space to avoid validation errors
-			}
-
-			// Only add cache control for substantial text (at least ~1024 tokens)
-			minCacheableLength := 5 * 1024 // ~1024 tokens
-			if IsPromptCachingSupported(b.Config.ModelID) && len(trimmedText) > minCacheableLength {
-				c.CacheControl = &CacheControl{
-					Type: CacheControlTypeEphemeral,
-				}
-			}
-			contentBlocks = append(contentBlocks, c)
+		// Validate effort value
+		validEffortLevels := []string{EffortMax, EffortXHigh, EffortHigh, EffortMedium, EffortLow}
+		if !slices.Contains(validEffortLevels, b.Config.Effort) {
+			e := fmt.Errorf("invalid effort level '%s'. Valid values are: max, xhigh, high, medium, low", b.Config.Effort)
+			return "", bodsError{e, errLabelEffortParameter}
 		}
 
-		// 5. Format instructions
-		if strings.TrimSpace(format) != "" {
-			contentBlocks = append(contentBlocks, Content{
-				Type: MessageContentTypeText,
-				Text: strings.TrimSpace(format),
-			})
+		// Validate the level is supported by the model, e.g. 'max' and 'xhigh' are Opus only
+		if !model.SupportsEffort(b.Config.Effort) {
+			e := fmt.Errorf("effort level '%s' is not supported by %s, supported levels are: %s", b.Config.Effort, b.Config.ModelID, strings.Join(model.Effort, ", "))
+			return "", bodsError{e, errLabelEffortParameter}
 		}
 
-		// Add all content blocks to the message
-		messages[0].Content = append(messages[0].Content, contentBlocks...)
-
-		if strings.TrimSpace(assistant) != "" {
-			if IsAdaptiveThinkingModel(normalizeToModelID(b.Config.ModelID)) {
-				e := fmt.Errorf("assistant message prefilling is not supported by %s (returns 400 error)", b.Config.ModelID)
-				return bodsError{e, "AssistantPrefill"}
+		// Some models need a beta header for effort, e.g. Opus 4.5 (private beta).
+		for _, beta := range model.Betas.Effort {
+			if !slices.Contains(paramsMessagesAPI.AnthropicBeta, beta) {
+				paramsMessagesAPI.AnthropicBeta = append(paramsMessagesAPI.AnthropicBeta, beta)
 			}
-			messages = append(messages,
-				Message{
-					Role: MessageRoleAssistant,
-					Content: []Content{
-						{
-							Type: MessageContentTypeText,
-							Text: strings.TrimRight(assistant, "\n"),
-						},
-					},
-				})
 		}
 
-		paramsMessagesAPI.Messages = messages
-
-		body, err := json.Marshal(paramsMessagesAPI)
-		if err != nil {
-			panic(err)
+		// Set output config
+		if paramsMessagesAPI.OutputConfig == nil {
+			paramsMessagesAPI.OutputConfig = &OutputConfig{}
 		}
-		// logger.Printf("body=%v\n", spew.Sdump(paramsMessagesAPI))
-		logger.Printf("string body=%v\n", string(body))
-		if len(os.Getenv("DUMP_PROMPT")) > 0 {
-			data, _ := json.MarshalIndent(paramsMessagesAPI, "", "  ")
-			fmt.Println(string(data))
-			os.Exit(0)
+		paramsMessagesAPI.OutputConfig.Effort = b.Config.Effort
+
+		// At 'xhigh'/'max' effort the model needs a large output budget for
+		// thinking plus tool calls. Raise the ceiling when the user did not
+		// set one explicitly (via --tokens or a prompt template). See opus47vision.md.
+		if b.Config.Effort == EffortXHigh || b.Config.Effort == EffortMax {
+			_, maxTokensFromTemplate := promptTemplateFieldValue[int](b.Config, "MaxTokens")
+			explicitMaxTokens := b.Config.MaxTokens != 0 || maxTokensFromTemplate
+			const highEffortMaxTokensFloor = 32768
+			if !explicitMaxTokens && paramsMessagesAPI.MaxTokens < highEffortMaxTokensFloor {
+				logger.Printf("raising max_tokens from %d to %d for '%s' effort (no explicit --tokens set)\n", paramsMessagesAPI.MaxTokens, highEffortMaxTokensFloor, b.Config.Effort)
+				paramsMessagesAPI.MaxTokens = highEffortMaxTokensFloor
+			}
 		}
 
-		modelInput := bedrockruntime.InvokeModelWithResponseStreamInput{
-			Body:                     body,
-			ModelId:                  &b.Config.ModelID,
-			ContentType:              aws.String("application/json"),
-			Accept:                   aws.String("application/json"),
-			PerformanceConfigLatency: performanceConfiguration.Latency,
-		}
+		logger.Printf("Set effort level to '%s' for %s\n", b.Config.Effort, normalizedModelID)
+	}
 
-		modelOutput, err := bedrockRuntimeClient.InvokeModelWithResponseStream(*b.context, &modelInput)
-		if err != nil {
-			logger.Println(err)
-			return bodsError{err, "There was a problem invoking the model. Have you enabled the model and set the correct region?"}
+	// set system prompt from prompt template, unless system prompt
+	// explicitly provided wth '--system'
+	logger.Printf("config.PromptTemplate=%s  config.SystemPrompt=%s\n", config.PromptTemplate, config.SystemPrompt)
+	if b.Config.PromptTemplate != "" && b.Config.SystemPrompt == "" {
+		for _, p := range config.Prompts {
+			if p.Name == b.Config.PromptTemplate && p.System != "" {
+				b.Config.SystemPrompt = p.System
+			}
 		}
+	}
 
-		eventStream := modelOutput.GetStream()
-
-		// Return the completionOutput to be processed by Update
-		// before was: 	return b.receiveStreamingMessagesCmd(completionOutput{stream: eventStream})()
-		return completionOutput{stream: eventStream}
+	// system prompts are currently available for use with Claude 3 models and Claude 2.1
+	// for Claude2, system prompt is included in the user prompt (see startMessagesCmd)
+	if IsClaude3OrHigherModelID(b.Config.ModelID) {
+		paramsMessagesAPI.System = SystemPrompt{Text: b.Config.SystemPrompt}
 	}
+
+	return toolContext, nil
 }
 
-// HandleTextEditorToolResult processes the result from a text editor tool call
-func HandleTextEditorToolResult(toolUseID string, result string, isError bool) json.RawMessage {
-	response := map[string]any{
-		"type":        "tool_result",
-		"tool_use_id": toolUseID,
-		"content":     result,
-	}
-	if isError {
-		response["is_error"] = true
+// resolveInferenceProfile replaces the configured model id with its cross-region
+// inference profile id, if one is available.
+func (b *Bods) resolveInferenceProfile() {
+	if !b.Config.CrossRegionInference || b.bedrockClient == nil {
+		return
 	}
-
-	responseJSON, err := json.Marshal(response)
-	if err != nil {
-		logger.Printf("Error marshaling tool result: %v\n", err)
-		return nil
+	inferenceProfilID, err := crossRegionInferenceProfileID(*b.bedrockClient, b.Config.ModelID, b.awsRegion)
+	if err == nil {
+		logger.Println("replacing config.ModelID=", b.Config.ModelID, " with inference profile id ", inferenceProfilID)
+		b.Config.ModelID = inferenceProfilID
 	}
-
-	return responseJSON
 }
 
-// invokeModelForToolResponse handles invoking the model after a tool response and returns a tea.Msg
+// invokeModel invokes the model with the current messages, e.g. after a tool response or
+// a new chat turn, and returns a tea.Msg
 // see also https://docs.anthropic.com/en/docs/build-with-claude/extended-thinking#example-passing-thinking-blocks-with-tool-results
-func (b *Bods) invokeModelForToolResponse() tea.Msg {
+func (b *Bods) invokeModel() tea.Msg {
 	paramsMessagesAPI.Messages = messages
+	params := b.cachedParams()
 
 	// not working on Bedrock (yet): https://docs.anthropic.com/en/docs/build-with-claude/tool-use/token-efficient-tool-use
 
-	// prepare the model input
-	body, err := json.Marshal(paramsMessagesAPI)
-	if err != nil {
-		panic(err)
-	}
-
 	if len(os.Getenv("DEBUG")) > 0 { // don't marshal if debug not set
-		data, _ := json.MarshalIndent(paramsMessagesAPI, "", "  ")
-		logger.Printf("InvokeModelWithResponseStreamInput:\n%s\n", string(data))
-	}
-
-	// Implement exponential backoff with jitter for ThrottlingException handling
-	const maxRetries = 6
-	const baseDelay = 2 * time.Second
-	var modelOutput *bedrockruntime.InvokeModelWithResponseStreamOutput
-	var lastError error
-
-	for attempt := range maxRetries {
-
-		modelInput := bedrockruntime.InvokeModelWithResponseStreamInput{
-			Body:        body,
-			ModelId:     &b.Config.ModelID,
-			ContentType: aws.String("application/json"),
-			Accept:      aws.String("application/json"),
-		}
-
-		if attempt > 0 {
-			// Calculate backoff with jitter for retries; exponential backoff: baseDelay * 2^attempt
-			// Add jitter: random value between 0 and 1 second
-			jitter := time.Duration(rand.Int63n(1000)) * time.Millisecond // #nosec G404 - Weak random is acceptable for jitter
-			delay := baseDelay*(1<<attempt) + jitter
-			logger.Printf("Retrying API call (attempt %d/%d) after %v delay due to throttling", attempt+1, maxRetries, delay)
-			time.Sleep(delay)
-		}
-
-		modelOutput, err = bedrockRuntimeClient.InvokeModelWithResponseStream(*b.context, &modelInput)
-
-		if err == nil {
-			break // success, break out of retry loop
-		}
-
-		lastError = err
-		logger.Printf("API call attempt %d failed: %v", attempt+1, err)
-
-		// Check if error is a ThrottlingException
-		if strings.Contains(err.Error(), "ThrottlingException") {
-			// Continue to next retry
-			logger.Println("Detected ThrottlingException, will retry with backoff")
-			continue
-		} else {
-			// For non-throttling errors, don't retry
-			break
-		}
+		data, _ := json.MarshalIndent(params, "", "  ")
+		logger.Printf("model request (%s api):\n%s\n", b.Config.API, string(data))
 	}
 
-	// If we exhausted all retries or had a different error
+	request := modelRequest{ModelID: b.Config.ModelID, Params: params, Guardrail: b.guardrail}
+	eventStream, start, err := newInvoker(b.backend, b.Config).Stream(*b.context, request)
 	if err != nil {
-		logger.Println(lastError)
-		return bodsError{lastError, "There was a problem invoking the model. Have you enabled the model and set the correct region?"}
+		logger.Println(err)
+		return bodsError{err, b.invokeErrorReason(err)}
 	}
-
-	eventStream := modelOutput.GetStream()
+	b.startRound(start)
 
 	// return the new stream as output to be processed by Update
 	return completionOutput{stream: eventStream}
 }
 
+// invokeErrorReason returns the reason shown when a model could not be invoked.
+func (b *Bods) invokeErrorReason(err error) string {
+	switch {
+	case errors.Is(err, errInvocationTimeout):
+		return "The model did not respond in time, see --timeout."
+	case retryableError(err):
+		return "The model is throttled or unavailable. Try again later or with more --retries."
+	}
+	if b.Config.Provider == providerAnthropic {
+		return "There was a problem invoking the model. Is " + anthropicAPIKeyEnv + " valid and the model available with the Anthropic API?"
+	}
+	return "There was a problem invoking the model. Have you enabled the model and set the correct region? See 'bods models' for the models available to you."
+}
+
 func (b *Bods) receiveStreamingMessagesCmd(msg completionOutput) tea.Cmd {
 	// logger.Printf("receiveStreamingMessagesCmd msg.stream=%v\n", msg.stream)
+	msg.retry = false // the msg is passed on with the next event
 	return func() tea.Msg {
 		var stopReason string
 		const timeSleep = 30 * time.Millisecond
 		for {
 			select {
-			case responseStream := <-msg.stream.Reader.Events():
-				logger.Printf("responseStream=%s\n", responseStream)
-
-				if responseStream == nil {
+			case msgResponse, ok := <-msg.stream.Events():
+				if !ok {
+					if err := msg.stream.Err(); err != nil {
+						return bodsError{err, "There was a problem receiving the response."}
+					}
 					return bodsError{errEmptyResponseStream, "The response stream was empty (nil)."}
 				}
 
-				switch v := responseStream.(type) {
-				case *types.ResponseStreamMemberChunk: // logger.Printf("ResponseStreamMemberChunk [v.Value.Bytes]: %s\n", v.Value.Bytes)
-					var msgResponse AnthropicClaudeMessagesResponse
-					err := json.Unmarshal(v.Value.Bytes, &msgResponse)
-					if err != nil {
-						panic(err)
-					}
-
-					logger.Printf("msgResponse.Type: %s\n", msgResponse.Type)
-
-					if msgResponse.Type == EventMessageStart.String() {
-						// {"type": "message_start", "message": {"id": "msg_1nZdL29xx5MUA1yADyHTEsnR8uuvGzszyY", "type": "message", "role": "assistant", "content": [], "model": "claude-3-7-sonnet-20250219", "stop_reason": null, "stop_sequence": null, "usage": {"input_tokens": 25, "output_tokens": 1}}}
+				logger.Printf("msgResponse.Type: %s\n", msgResponse.Type)
+				if b.Config.Output == outputNDJSON {
+					emitEvent(msgResponse)
+				}
+				if round := b.round(); round.TTFB == 0 {
+					round.TTFB = time.Since(round.start)
+				}
 
-						logger.Printf("event: message_start role=%s id=%s\n", msgResponse.Message.Role, msgResponse.Message.ID)
-						if msgResponse.Message.Role == MessageRoleAssistant {
-							messages = append(messages,
-								Message{
-									Role:    MessageRoleAssistant,
-									Content: []Content{},
-								})
-						}
+				if msgResponse.AmazonBedrockGuardrailAction == guardrailActionIntervened &&
+					(b.intervention == nil || len(msgResponse.AmazonBedrockTrace) > 0) {
+					b.intervention = newGuardrailIntervention(b.guardrail.String(), msgResponse.AmazonBedrockTrace)
+					logger.Println("guardrail intervened:", b.intervention)
+				}
 
+				if msgResponse.Type == EventRetry.String() {
+					logger.Println("event: retry, discarding the partial response")
+					if last := len(messages) - 1; last >= 0 && messages[last].Role == MessageRoleAssistant {
+						messages = messages[:last]
 					}
+					return completionOutput{stream: msg.stream, retry: true}
+				}
 
-					// event: message_stop
-					if msgResponse.Type == EventMessageStop.String() {
+				if msgResponse.Type == EventMessageStart.String() {
+					// {"type": "message_start", "message": {"id": "msg_1nZdL29xx5MUA1yADyHTEsnR8uuvGzszyY", "type": "message", "role": "assistant", "content": [], "model": "claude-3-7-sonnet-20250219", "stop_reason": null, "stop_sequence": null, "usage": {"input_tokens": 25, "output_tokens": 1}}}
+
+					logger.Printf("event: message_start role=%s id=%s\n", msgResponse.Message.Role, msgResponse.Message.ID)
+					usage := msgResponse.Message.Usage
+					b.addUsage(Usage{
+						InputTokens:           usage.InputTokens,
+						CacheReadInputTokens:  usage.CacheReadInputTokens,
+						CacheWriteInputTokens: usage.CacheCreationInputTokens,
+					})
+					b.inputTokensReported = usage.InputTokens > 0
+					b.outputStart = len(b.Output)
+					b.blockContentIdx = make(map[int]int)
+					b.toolInputJSON = make(map[int]string)
+					if msgResponse.Message.Role == MessageRoleAssistant {
+						messages = append(messages,
+							Message{
+								Role:    MessageRoleAssistant,
+								Content: []Content{},
+							})
+					}
 
-						logger.Println("event: message_stop")
+				}
 
-						if stopReason == MessageContentTypeToolUse {
-							toolCallInputByte := []byte(b.Config.ToolCallJSONString)
-							editorResult := HandleTextEditorToolCall(toolCallInputByte)
+				// event: message_stop
+				if msgResponse.Type == EventMessageStop.String() {
 
-							lastMsgIdx := len(messages) - 1
-							lastContentIdx := len(messages[lastMsgIdx].Content) - 1
+					logger.Println("event: message_stop")
+					round := b.round()
+					round.Latency = time.Since(round.start)
+					round.Metrics = msgResponse.AmazonBedrockInvocationMetrics
+					for _, c := range messages[len(messages)-1].Content {
+						round.ThinkingTokens += estimateTokens(c.Thinking)
+					}
 
-							// create tool response message
-							messages = append(messages,
-								Message{
-									Role: MessageRoleUser,
-									Content: []Content{{
-										Type:      "tool_result",
-										ToolUseID: messages[lastMsgIdx].Content[lastContentIdx].ID,
-										Content:   editorResult.Content,
-									}},
-								})
+					if data, toolUse := structuredOutput(messages[len(messages)-1]); toolUse != nil {
+						// the forced tool call of --schema is the response, it is not dispatched
+						_ = msg.stream.Close()
+						return completionOutput{content: string(data)}
+					}
 
-							// return a special message that will trigger a new model invocation
-							_ = msg.stream.Close()
-							return b.invokeModelForToolResponse()
+					if stopReason == MessageContentTypeToolUse {
+						// execute every tool call of the turn; all results go back in one user message, in order
+						var toolResults []Content
+						for _, c := range messages[len(messages)-1].Content {
+							if c.Type != MessageContentTypeToolUse {
+								continue
+							}
+							result := toolRegistry.Dispatch(c.Name, c.Input)
+							toolResults = append(toolResults, Content{
+								Type:      MessageContentTypeToolResult,
+								ToolUseID: c.ID,
+								Content:   result.Content,
+								IsError:   result.IsError,
+							})
+						}
+						logger.Printf("executed %d tool calls\n", len(toolResults))
+						if b.Config.Output == outputNDJSON {
+							for _, result := range toolResults {
+								emitEvent(result)
+							}
 						}
 
-						logger.Printf("type:message_stop v.Value.Bytes=%s\n", v.Value.Bytes)
-						logger.Printf("type:message_stop outputTokenCount=%d\n", msgResponse.AmazonBedrockInvocationMetrics.OutputTokenCount)
+						// create tool response message
+						messages = append(messages,
+							Message{
+								Role:    MessageRoleUser,
+								Content: toolResults,
+							})
+
+						// return a special message that will trigger a new model invocation
 						_ = msg.stream.Close()
-						msg.stream = nil
-						msg.content = ""
-						return msg
+						return b.invokeModel()
 					}
 
-					//
-					// content_block_start
-					//
-					if msgResponse.Type == EventContentBlockStart.String() {
+					if metrics := msgResponse.AmazonBedrockInvocationMetrics; metrics != nil {
+						logger.Printf("type:message_stop metrics=%+v\n", *metrics)
+					}
+					_ = msg.stream.Close()
+					msg.stream = nil
+					msg.content = ""
+					return msg
+				}
 
-						// currentRole := messages[len(messages)-1].Role
+				//
+				// content_block_start
+				//
+				if msgResponse.Type == EventContentBlockStart.String() {
 
-						msg.content = ""
-						if msgResponse.ContentBlock.Type == "thinking" && b.Config.Format {
-							msg.content = "`<thinking>` \n\n"
-						}
+					// currentRole := messages[len(messages)-1].Role
 
-						if msgResponse.ContentBlock.Type == "text" { // && currentRole == MessageRoleAssistant {
-							logger.Println("content_block_start type='text'")
-							messages[len(messages)-1].Content = append(messages[len(messages)-1].Content,
-								Content{
-									Type: MessageContentTypeText,
-									Text: " ", // Use space to avoid validation errors for empty text
-								})
-						}
+					msg.content = ""
+					if msgResponse.ContentBlock.Type == "thinking" && b.Config.Format {
+						msg.content = "`<thinking>` \n\n"
+					}
 
-						if msgResponse.ContentBlock.Type == "tool_use" {
-							logger.Println("content_block_start type='tool_use'")
-							// {{{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_bdrk_01NHfgPyKd23Dy57k97Rn2ou","name":"str_replace_editor","input":{}}} {}} {}}
-							// DEBUG msg.content = fmt.Sprintf("\n\nSTART tool_use id=%s name=%s\n", msgResponse.ContentBlock.ID, msgResponse.ContentBlock.Name)
-							messages[len(messages)-1].Content = append(messages[len(messages)-1].Content,
-								Content{
-									Type: MessageContentTypeToolUse,
-									ID:   msgResponse.ContentBlock.ID,
-									Name: msgResponse.ContentBlock.Name,
-								})
-							b.Config.ToolCallJSONString = "" // reset to empty string
-						}
+					if msgResponse.ContentBlock.Type == "text" { // && currentRole == MessageRoleAssistant {
+						logger.Println("content_block_start type='text'")
+						messages[len(messages)-1].Content = append(messages[len(messages)-1].Content,
+							Content{
+								Type: MessageContentTypeText,
+								Text: " ", // Use space to avoid validation errors for empty text
+							})
+					}
 
-						if msgResponse.ContentBlock.Type == "thinking" {
-							logger.Println("content_block_start type='thinking'")
-							messages[len(messages)-1].Content = append(messages[len(messages)-1].Content,
-								Content{
-									Type:      MessageContentTypeThinking, // "type": "thinking",
-									Thinking:  "",                         // "thinking": "Let me analyze this step by step...",
-									Signature: "",                         // "signature": "WaU..."
-								})
-						}
+					if msgResponse.ContentBlock.Type == "tool_use" {
+						logger.Println("content_block_start type='tool_use'")
+						// {{{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_bdrk_01NHfgPyKd23Dy57k97Rn2ou","name":"str_replace_editor","input":{}}} {}} {}}
+						// DEBUG msg.content = fmt.Sprintf("\n\nSTART tool_use id=%s name=%s\n", msgResponse.ContentBlock.ID, msgResponse.ContentBlock.Name)
+						messages[len(messages)-1].Content = append(messages[len(messages)-1].Content,
+							Content{
+								Type: MessageContentTypeToolUse,
+								ID:   msgResponse.ContentBlock.ID,
+								Name: msgResponse.ContentBlock.Name,
+							})
+						b.toolInputJSON[msgResponse.Index] = ""
+					}
 
-						return msg
+					if msgResponse.ContentBlock.Type == "thinking" {
+						logger.Println("content_block_start type='thinking'")
+						messages[len(messages)-1].Content = append(messages[len(messages)-1].Content,
+							Content{
+								Type:      MessageContentTypeThinking, // "type": "thinking",
+								Thinking:  "",                         // "thinking": "Let me analyze this step by step...",
+								Signature: "",                         // "signature": "WaU..."
+							})
+					}
 
-					} // content_block_start END
+					b.blockContentIdx[msgResponse.Index] = len(messages[len(messages)-1].Content) - 1
 
-					//
-					// content_block_delta START
-					//
-					if msgResponse.Type == EventContentBlockDelta.String() {
-						msg.isThinkingOutput = false // default to no thinking output
+					return msg
 
-						// type can be thinking | thinking_delta | text | text_delta | signature_delta
-						if msgResponse.Delta.Type == "thinking_delta" {
-							lastMsgIdx := len(messages) - 1
-							lastContentIdx := len(messages[lastMsgIdx].Content) - 1
-							messages[lastMsgIdx].Content[lastContentIdx].Thinking += msgResponse.Delta.Thinking
+				} // content_block_start END
 
-							msg.content = msgResponse.Delta.Thinking
-							msg.isThinkingOutput = true
-							return msg
-						}
+				//
+				// content_block_delta START
+				//
+				if msgResponse.Type == EventContentBlockDelta.String() {
+					msg.isThinkingOutput = false // default to no thinking output
 
-						if msgResponse.Delta.Type == "signature_delta" {
-							logger.Printf("signature_delta=%s", msgResponse.Delta.Signature)
-							lastMsgIdx := len(messages) - 1
-							lastContentIdx := len(messages[lastMsgIdx].Content) - 1
-							messages[lastMsgIdx].Content[lastContentIdx].Signature += msgResponse.Delta.Signature
+					// type can be thinking | thinking_delta | text | text_delta | signature_delta
+					if msgResponse.Delta.Type == "thinking_delta" {
+						b.blockContent(msgResponse.Index).Thinking += msgResponse.Delta.Thinking
 
-							// if msgResponse.ContentBlock.Type == "text" && b.Config.Think && b.Config.Format {
-							if b.Config.Think && b.Config.Format {
-								msg.content = "\n\n`</thinking>`\n\n"
-							}
-							return msg
-						}
+						msg.content = msgResponse.Delta.Thinking
+						msg.isThinkingOutput = true
+						return msg
+					}
 
-						// debug [59429] responseStream=&{{{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":""}} {}} {}}
-						if msgResponse.Delta.Type == "input_json_delta" {
-							// you can accumulate the string deltas and parse the JSON once you receive a content_block_stop
-							b.Config.ToolCallJSONString = fmt.Sprintf("%s%s", b.Config.ToolCallJSONString, msgResponse.Delta.PartialJSON)
-							msg.content = ""
-							return msg
-						}
+					if msgResponse.Delta.Type == "signature_delta" {
+						logger.Printf("signature_delta=%s", msgResponse.Delta.Signature)
+						b.blockContent(msgResponse.Index).Signature += msgResponse.Delta.Signature
 
-						if msgResponse.Delta.Type == "text_delta" {
-							role := messages[len(messages)-1].Role
-							if role != MessageRoleAssistant {
-								logger.Printf("ERROR should be assistant role %v\n", messages)
-							}
+						// if msgResponse.ContentBlock.Type == "text" && b.Config.Think && b.Config.Format {
+						if b.Config.Think && b.Config.Format {
+							msg.content = "\n\n`</thinking>`\n\n"
+						}
+						return msg
+					}
 
-							lastMsgIdx := len(messages) - 1
-							lastContentIdx := len(messages[lastMsgIdx].Content) - 1
-							messages[lastMsgIdx].Content[lastContentIdx].Text += msgResponse.Delta.Text
+					// debug [59429] responseStream=&{{{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":""}} {}} {}}
+					if msgResponse.Delta.Type == "input_json_delta" {
+						// you can accumulate the string deltas and parse the JSON once you receive a content_block_stop
+						b.toolInputJSON[msgResponse.Index] += msgResponse.Delta.PartialJSON
+						msg.content = ""
+						return msg
+					}
 
-							// DEL t := messages[len(messages)-1].Content[0].Text
-							// DEL messages[len(messages)-1].Content[0].Text = t + msgResponse.Delta.Text
+					if msgResponse.Delta.Type == "text_delta" {
+						role := messages[len(messages)-1].Role
+						if role != MessageRoleAssistant {
+							logger.Printf("ERROR should be assistant role %v\n", messages)
 						}
 
-						msg.content = msgResponse.Delta.Text
-						return msg
-					} // content_block_delta END
+						b.blockContent(msgResponse.Index).Text += msgResponse.Delta.Text
 
-					if msgResponse.Type == EventContentBlockStop.String() { //nolint:staticcheck // SA9003: empty branch
-						// debug [62732] responseStream=&{{{"type":"content_block_stop","index":1} {}} {}}
+						// DEL t := messages[len(messages)-1].Content[0].Text
+						// DEL messages[len(messages)-1].Content[0].Text = t + msgResponse.Delta.Text
 					}
 
-					// debug [55908] responseStream=&{{{"type":"message_delta","delta":{"stop_reason":"tool_use","stop_sequence":null},"usage":{"output_tokens":116}} {}} {}}
-					if msgResponse.Type == EventMessageDelta.String() {
-						stopReason = msgResponse.Delta.StopReason
-						if stopReason == "tool_use" {
-							logger.Println("b.Config.ToolCallJSONString=" + b.Config.ToolCallJSONString)
+					msg.content = msgResponse.Delta.Text
+					return msg
+				} // content_block_delta END
 
-							toolCallInputByte := []byte(b.Config.ToolCallJSONString)
-
-							lastMsgIdx := len(messages) - 1
-							lastContentIdx := len(messages[lastMsgIdx].Content) - 1
-							messages[lastMsgIdx].Content[lastContentIdx].Input = toolCallInputByte
+				if msgResponse.Type == EventContentBlockStop.String() {
+					// debug [62732] responseStream=&{{{"type":"content_block_stop","index":1} {}} {}}
+					if inputJSON, ok := b.toolInputJSON[msgResponse.Index]; ok {
+						logger.Printf("tool_use index=%d input=%s\n", msgResponse.Index, inputJSON)
+						if strings.TrimSpace(inputJSON) == "" {
+							inputJSON = "{}" // tool called without parameters
 						}
+						b.blockContent(msgResponse.Index).Input = json.RawMessage(inputJSON)
 					}
+				}
 
-					logger.Printf("WARN ignoring response type '%s'", msgResponse.Type)
-
-				default:
-					logger.Printf("receiveStreamingMessagesCmd - sleeping (switch) for %dms; v = %v", timeSleep, v) // XX
-					time.Sleep(timeSleep)
+				// debug [55908] responseStream=&{{{"type":"message_delta","delta":{"stop_reason":"tool_use","stop_sequence":null},"usage":{"output_tokens":116}} {}} {}}
+				if msgResponse.Type == EventMessageDelta.String() {
+					stopReason = msgResponse.Delta.StopReason
+					b.stopReason = stopReason
+					if usage := msgResponse.Usage; usage != nil {
+						u := Usage{OutputTokens: usage.OutputTokens}
+						if !b.inputTokensReported {
+							u.InputTokens = usage.InputTokens
+							u.CacheReadInputTokens = usage.CacheReadInputTokens
+							u.CacheWriteInputTokens = usage.CacheCreationInputTokens
+						}
+						b.addUsage(u)
+					}
 				}
+
+				logger.Printf("WARN ignoring response type '%s'", msgResponse.Type)
 			case <-(*b.context).Done():
 				_ = msg.stream.Close()
 				return bodsError{errContextCanceled, "The context was cancelled."}
@@ -1132,6 +1278,17 @@ func (b *Bods) receiveStreamingMessagesCmd(msg completionOutput) tea.Cmd {
 	}
 }
 
+// blockContent returns the content of the streamed assistant message for the
+// given content block index of the response.
+func (b *Bods) blockContent(index int) *Content {
+	lastMsgIdx := len(messages) - 1
+	contentIdx, ok := b.blockContentIdx[index]
+	if !ok || contentIdx >= len(messages[lastMsgIdx].Content) {
+		contentIdx = len(messages[lastMsgIdx].Content) - 1
+	}
+	return &messages[lastMsgIdx].Content[contentIdx]
+}
+
 // readStdinCmd reads from stdin and returns a tea.Msg wrapping the content read.
 func readStdinCmd() tea.Msg {
 	logger.Printf("readStdInCmd: isInputTerminal=%v\n", isInputTerminal())
@@ -1176,7 +1333,7 @@ func initialBodsModel(r *lipgloss.Renderer, cfg *Config) *Bods {
 		glamour.WithAutoStyle(),   // detect bg color and pick either the default dark or light theme
 	)
 
-	return &Bods{
+	b := &Bods{
 		Styles:        makeStyles(r),
 		Config:        cfg,
 		cancelRequest: cancel,
@@ -1184,6 +1341,10 @@ func initialBodsModel(r *lipgloss.Renderer, cfg *Config) *Bods {
 		context:       &ctx,
 		glam:          glamRenderer,
 	}
+	if cfg.Chat {
+		b.chat = newChat(r)
+	}
+	return b
 }
 
 // promptInput a tea.Msg wrapping the content read from stdin.
@@ -1196,7 +1357,8 @@ type promptInput struct {
 type completionOutput struct {
 	content          string
 	isThinkingOutput bool
-	stream           *bedrockruntime.InvokeModelWithResponseStreamEventStream
+	retry            bool // the response failed and is invoked again, its output is discarded
+	stream           responseStream
 }
 
 // -------------------
@@ -1220,7 +1382,11 @@ type styles struct {
 	ConversationList,
 	SHA1,
 	Bullet,
-	Timeago lipgloss.Style
+	Timeago,
+	DiffHeader,
+	DiffHunk,
+	DiffAdd,
+	DiffDelete lipgloss.Style
 }
 
 func makeStyles(r *lipgloss.Renderer) (s styles) {
@@ -1243,6 +1409,10 @@ func makeStyles(r *lipgloss.Renderer) (s styles) {
 	s.SHA1 = s.Flag
 	s.Bullet = r.NewStyle().SetString("• ").Foreground(lipgloss.AdaptiveColor{Light: "#757575", Dark: "#777"})
 	s.Timeago = r.NewStyle().Foreground(lipgloss.AdaptiveColor{Light: "#999", Dark: "#555"})
+	s.DiffHeader = r.NewStyle().Bold(true)
+	s.DiffHunk = r.NewStyle().Foreground(lipgloss.AdaptiveColor{Light: "#0087AF", Dark: "#5FAFD7"})
+	s.DiffAdd = r.NewStyle().Foreground(lipgloss.AdaptiveColor{Light: "#008700", Dark: "#5FD75F"})
+	s.DiffDelete = r.NewStyle().Foreground(lipgloss.AdaptiveColor{Light: "#AF0000", Dark: "#FF5F5F"})
 	return s
 }
 
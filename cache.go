@@ -0,0 +1,196 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Prompt caching modes, see --cache
+const (
+	cacheOff        = "off"        // no cache breakpoints
+	cacheAuto       = "auto"       // breakpoints where the estimated prefix reaches the model's minimum
+	cacheAggressive = "aggressive" // breakpoints regardless of the estimate, also for single requests
+)
+
+var cacheModes = []string{cacheOff, cacheAuto, cacheAggressive}
+
+// Lifetimes of cache entries, see --cache-ttl
+const (
+	cacheTTL5m = "5m"
+	cacheTTL1h = "1h"
+)
+
+var cacheTTLs = []string{cacheTTL5m, cacheTTL1h}
+
+// maxCacheBreakpoints is the number of cache_control markers allowed in a request.
+const maxCacheBreakpoints = 4
+
+// validateCacheSettings checks the --cache mode and --cache-ttl.
+func validateCacheSettings(mode, ttl string) error {
+	if !slices.Contains(cacheModes, mode) {
+		return fmt.Errorf("unknown cache mode '%s', supported are: %s", mode, strings.Join(cacheModes, ", "))
+	}
+	if ttl != "" && !slices.Contains(cacheTTLs, ttl) {
+		return fmt.Errorf("unknown cache ttl '%s', supported are: %s", ttl, strings.Join(cacheTTLs, ", "))
+	}
+	return nil
+}
+
+// cachePlan describes the cache breakpoints of a request.
+type cachePlan struct {
+	Tools        bool // after the last tool definition
+	System       bool // after the system prompt
+	Documents    int  // after large documents and texts of the messages
+	Conversation bool // after the last user message, the prefix of the next request
+}
+
+// Breakpoints returns the number of cache breakpoints.
+func (p cachePlan) Breakpoints() int {
+	n := p.Documents
+	for _, set := range []bool{p.Tools, p.System, p.Conversation} {
+		if set {
+			n++
+		}
+	}
+	return n
+}
+
+// cacheCandidate is a content block that can be a cache breakpoint.
+type cacheCandidate struct {
+	message, block int
+	tokens         int
+}
+
+// planCache returns a copy of params with at most four cache breakpoints: after the
+// tool definitions, the system prompt, the largest documents and the last user
+// message. The API caches the whole prefix up to a breakpoint, in the order tools,
+// system, messages, but only from the model's minimum number of tokens on; with
+// cacheAuto breakpoints are placed only where the estimated prefix reaches it, and
+// the conversation prefix only if another request is likely to follow, i.e. with
+// several messages, tools or in chat mode. Cache markers of params are removed.
+func planCache(params *AnthropicClaudeMessagesInferenceParameters, modelID, mode, ttl string, chat bool) (*AnthropicClaudeMessagesInferenceParameters, cachePlan) {
+	planned := *params
+	planned.Messages = withoutCacheControl(params.Messages)
+	planned.System.CacheControl = nil
+	planned.Tools = slices.Clone(params.Tools)
+	for i, t := range planned.Tools {
+		if def, ok := t.(ToolDefinition); ok {
+			def.CacheControl = nil
+			planned.Tools[i] = def
+		}
+	}
+
+	var plan cachePlan
+	model := lookupModel(modelID)
+	if mode == cacheOff || !IsPromptCachingSupported(modelID) {
+		return &planned, plan
+	}
+
+	cacheControl := &CacheControl{Type: CacheControlTypeEphemeral}
+	if ttl == cacheTTL1h {
+		cacheControl.TTL = ttl
+	}
+	minTokens := model.CacheMinTokens
+	reaches := func(tokens int) bool { return mode == cacheAggressive || tokens >= minTokens }
+
+	// tools and system prompt, the stable start of every request
+	prefix := 0
+	if n := len(planned.Tools); n > 0 {
+		data, _ := json.Marshal(planned.Tools)
+		prefix += model.EstimateTokens(string(data))
+		if def, ok := planned.Tools[n-1].(ToolDefinition); ok && reaches(prefix) {
+			def.CacheControl = cacheControl
+			planned.Tools[n-1] = def
+			plan.Tools = true
+		}
+	}
+	if planned.System.Text != "" {
+		prefix += model.EstimateTokens(planned.System.Text)
+		if reaches(prefix) {
+			planned.System.CacheControl = cacheControl
+			plan.System = true
+		}
+	}
+
+	// the conversation prefix: the last block of the last user message
+	var documents []cacheCandidate
+	last := cacheCandidate{message: -1}
+	for i, m := range planned.Messages {
+		for j, c := range m.Content {
+			tokens := contentTokens(model, c)
+			prefix += tokens
+			if !cacheable(c) {
+				continue
+			}
+			if m.Role == MessageRoleUser {
+				last = cacheCandidate{message: i, block: j, tokens: prefix}
+			}
+			if c.Type == MessageContentTypeDocument || tokens >= minTokens {
+				documents = append(documents, cacheCandidate{message: i, block: j, tokens: tokens})
+			}
+		}
+	}
+	followUp := chat || len(planned.Messages) > 1 || len(planned.Tools) > 0
+	if last.message >= 0 && (mode == cacheAggressive || followUp && last.tokens >= minTokens) {
+		planned.Messages[last.message].Content[last.block].CacheControl = cacheControl
+		plan.Conversation = true
+	}
+
+	// large documents, the largest first, with the remaining breakpoints
+	slices.SortStableFunc(documents, func(a, b cacheCandidate) int { return cmp.Compare(b.tokens, a.tokens) })
+	for _, d := range documents {
+		if plan.Breakpoints() == maxCacheBreakpoints {
+			break
+		}
+		c := &planned.Messages[d.message].Content[d.block]
+		if c.CacheControl == nil {
+			c.CacheControl = cacheControl
+			plan.Documents++
+		}
+	}
+
+	logger.Printf("planCache(mode=%s, ttl=%s) %s: estimated %d tokens, minimum %d, %d breakpoint(s) %+v\n",
+		mode, ttl, modelID, prefix, minTokens, plan.Breakpoints(), plan)
+	return &planned, plan
+}
+
+// contentTokens estimates the tokens of a content block.
+func contentTokens(model ModelInfo, c Content) int {
+	switch c.Type {
+	case MessageContentTypeImage:
+		return estimateImageTokens(c)
+	case MessageContentTypeDocument:
+		return estimateDocumentTokens(c)
+	case MessageContentTypeToolUse:
+		return model.EstimateTokens(c.Name + string(c.Input))
+	case MessageContentTypeThinking:
+		return model.EstimateTokens(c.Thinking)
+	default:
+		return model.EstimateTokens(c.Text + c.Content)
+	}
+}
+
+// cacheable reports whether a content block can carry a cache breakpoint; thinking
+// and empty text blocks cannot.
+func cacheable(c Content) bool {
+	switch c.Type {
+	case MessageContentTypeThinking:
+		return false
+	case MessageContentTypeText:
+		return strings.TrimSpace(c.Text) != ""
+	default:
+		return true
+	}
+}
+
+// cachedParams returns the parameters of the next request with the cache breakpoints
+// of the configured --cache mode. The plan is recorded with the usage round started
+// by startRound.
+func (b *Bods) cachedParams() *AnthropicClaudeMessagesInferenceParameters {
+	params, plan := planCache(paramsMessagesAPI, b.Config.ModelID, b.Config.Cache, b.Config.CacheTTL, b.chat != nil)
+	b.cachePlan = plan
+	return params
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanCache(t *testing.T) {
+	const model = "anthropic.claude-sonnet-4-6" // cache_min_tokens 2048, about 8k characters
+	large := strings.Repeat("lorem ipsum ", 1000)
+	pdf := Content{Type: MessageContentTypeDocument, Source: &Source{Type: SourceTypeBase64, MediaType: MessageContentTypeMediaTypePDF, Data: strings.Repeat("A", 40000)}}
+	params := &AnthropicClaudeMessagesInferenceParameters{
+		System: SystemPrompt{Text: large},
+		Tools:  []any{ToolDefinition{Name: BashToolName, InputSchema: json.RawMessage(`{}`)}},
+		Messages: []Message{{Role: MessageRoleUser, Content: []Content{
+			pdf, pdf, pdf, pdf,
+			{Type: MessageContentTypeText, Text: large, CacheControl: &CacheControl{Type: CacheControlTypeEphemeral}},
+			{Type: MessageContentTypeText, Text: "Summarize the documents."},
+		}}},
+	}
+
+	planned, plan := planCache(params, model, cacheAuto, cacheTTL1h, false)
+	assert.Equal(t, cachePlan{System: true, Conversation: true, Documents: 2}, plan) // tools are too short, follow-up rounds likely with tools
+	assert.Equal(t, &CacheControl{Type: CacheControlTypeEphemeral, TTL: cacheTTL1h}, planned.System.CacheControl)
+	assert.Nil(t, planned.Tools[0].(ToolDefinition).CacheControl)
+	content := planned.Messages[0].Content
+	assert.NotNil(t, content[5].CacheControl) // conversation prefix
+	markers := 0
+	for _, c := range content {
+		if c.CacheControl != nil {
+			markers++
+		}
+	}
+	assert.Equal(t, 3, markers, "at most four breakpoints in total")
+	assert.NotNil(t, params.Messages[0].Content[4].CacheControl, "params are not modified")
+
+	_, plan = planCache(params, model, cacheAggressive, cacheTTL5m, false)
+	assert.Equal(t, 4, plan.Breakpoints())
+	assert.True(t, plan.Tools)
+
+	planned, plan = planCache(params, model, cacheOff, "", false)
+	assert.Zero(t, plan)
+	assert.Nil(t, planned.Messages[0].Content[4].CacheControl)
+
+	_, plan = planCache(params, "anthropic.claude-3-haiku-20240307-v1:0", cacheAggressive, "", true)
+	assert.Zero(t, plan, "no caching support")
+}
+
+func TestSystemPromptJSON(t *testing.T) {
+	data, err := json.Marshal(SystemPrompt{Text: "Be brief."})
+	require.NoError(t, err)
+	assert.JSONEq(t, `"Be brief."`, string(data))
+
+	cached := SystemPrompt{Text: "Be brief.", CacheControl: &CacheControl{Type: CacheControlTypeEphemeral}}
+	data, err = json.Marshal(cached)
+	require.NoError(t, err)
+	assert.JSONEq(t, `[{"type":"text","text":"Be brief.","cache_control":{"type":"ephemeral"}}]`, string(data))
+
+	var s SystemPrompt
+	require.NoError(t, json.Unmarshal(data, &s))
+	assert.Equal(t, cached, s)
+
+	params := AnthropicClaudeMessagesInferenceParameters{}
+	data, err = json.Marshal(params)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "system")
+}
+
+func TestValidateCacheSettings(t *testing.T) {
+	assert.NoError(t, validateCacheSettings(cacheAuto, ""))
+	assert.NoError(t, validateCacheSettings(cacheOff, cacheTTL1h))
+	assert.Error(t, validateCacheSettings("always", cacheTTL5m))
+	assert.Error(t, validateCacheSettings(cacheAuto, "24h"))
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textarea"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/glamour"
+	glamourstyles "github.com/charmbracelet/glamour/styles"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/spf13/cobra"
+)
+
+const (
+	chatInputHeight = 3
+	chatHelpText    = `Commands:
+  /model [id]               show or switch the model
+  /think [on|off]           toggle thinking
+  /effort [level|off]       set the effort level (max, xhigh, high, medium, low)
+  /system [prompt]          set the system prompt; without prompt, clear it
+  /clear                    start a new conversation
+  /help                     show this help
+  /quit                     exit (or ctrl+c)
+
+enter sends the prompt, ctrl+j or alt+enter inserts a newline, pgup/pgdown scroll.`
+)
+
+var chatCmd = &cobra.Command{
+	Use:   "chat [prompt]",
+	Short: "Start an interactive multi-turn chat",
+	Long: `Start an interactive multi-turn chat. Flags of the root command apply to the
+chat as well, e.g. 'bods chat -p <prompt> --think'. Piped input and a prompt
+given as argument are sent as first turn.`,
+	Args: cobra.ArbitraryArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config.Chat = true
+		return rootCmd.RunE(cmd, args)
+	},
+}
+
+// chat is the state of the interactive chat mode.
+type chat struct {
+	input     textarea.Model
+	viewport  viewport.Model
+	glamStyle string // glamour style, resolved once as querying the terminal is not possible while the program runs
+	history   string // rendered transcript of all finished turns
+	started   bool   // the first turn, which adds piped input and prompt template, was answered
+	turnStart int    // len(messages) before the current turn, to roll it back on errors
+	retry     string // progress of a retry of the invocation, shown in the status line while waiting
+}
+
+func newChat(r *lipgloss.Renderer) *chat {
+	ta := textarea.New()
+	ta.Placeholder = "Send a message (/help for commands)"
+	ta.ShowLineNumbers = false
+	ta.CharLimit = 0
+	ta.SetHeight(chatInputHeight)
+	ta.KeyMap.InsertNewline.SetKeys("ctrl+j", "alt+enter")
+	ta.Focus()
+
+	vp := viewport.New(0, 0)
+	vp.KeyMap = viewport.KeyMap{
+		PageDown: key.NewBinding(key.WithKeys("pgdown")),
+		PageUp:   key.NewBinding(key.WithKeys("pgup")),
+	}
+
+	glamStyle := os.Getenv("GLAMOUR_STYLE")
+	if glamStyle == "" {
+		glamStyle = glamourstyles.LightStyle
+		if r.HasDarkBackground() {
+			glamStyle = glamourstyles.DarkStyle
+		}
+	}
+
+	return &chat{input: ta, viewport: vp, glamStyle: glamStyle}
+}
+
+// startChat is called once stdin was read. Piped input and a prompt given as
+// argument are sent right away as first turn, otherwise the chat waits for input.
+func (b *Bods) startChat() tea.Cmd {
+	if len(messages) > 1 { // continued conversation
+		md := messagesMarkdown(messages[:len(messages)-1])
+		b.chat.history, _ = b.glam.Render(md)
+	}
+
+	if strings.TrimSpace(b.Input) == "" && strings.TrimSpace(b.Config.Prefix) == "" {
+		// resolve model and settings from flags and prompt template for the status line
+		if _, err := b.configureInferenceParameters(); err != nil {
+			b.appendChatNote(err.Error())
+		}
+		b.state = inputState
+		b.refreshChat()
+		return textarea.Blink
+	}
+
+	if strings.TrimSpace(b.Input) != "" {
+		b.appendChatNote(fmt.Sprintf("(%d bytes of piped input)", len(b.Input)))
+	}
+	if strings.TrimSpace(b.Config.Prefix) != "" {
+		b.appendChatUser(b.Config.Prefix)
+	}
+	return b.sendChatTurn("")
+}
+
+// sendChatTurn sends the given prompt as next user turn. The first turn goes through
+// startMessagesCmd like a single prompt run, later turns are appended to messages.
+func (b *Bods) sendChatTurn(prompt string) tea.Cmd {
+	b.Output, b.glamOutput = "", ""
+	b.state = requestState
+	b.refreshChat()
+
+	if !b.chat.started {
+		b.chat.turnStart = len(messages) - 1
+		if prompt != "" {
+			b.Config.Prefix = prompt
+		}
+		return b.startMessagesCmd(b.Input)
+	}
+
+	b.chat.turnStart = len(messages)
+	messages = append(messages, Message{
+		Role:    MessageRoleUser,
+		Content: []Content{{Type: MessageContentTypeText, Text: prompt}},
+	})
+	return func() tea.Msg {
+		b.resolveInferenceProfile() // the model might have been changed with /model
+		return b.invokeModel()
+	}
+}
+
+// finishChatTurn moves the streamed response to the history, saves the conversation
+// and waits for the next prompt.
+func (b *Bods) finishChatTurn() tea.Cmd {
+	b.chat.retry = ""
+	intervention := b.intervention
+	b.intervention = nil
+	if intervention != nil && intervention.Blocked { // the blocked turn is not kept
+		b.chatError(bodsError{intervention, intervention.reason()})
+		return nil
+	}
+
+	b.chat.history += b.chatResponse()
+	if b.Config.ShowUsage {
+		b.chat.history += formatUsage(b.Styles, b.rounds) + "\n"
+	}
+	b.recordUsage()
+	if intervention != nil {
+		b.chat.history += b.chatErrorText(bodsError{intervention, intervention.reason()})
+	}
+	b.Output, b.glamOutput = "", ""
+	b.chat.started = true
+	b.state = inputState
+
+	if _, err := recordConversation(b.Config, b.Usage); err != nil {
+		logger.Println("could not save conversation:", err)
+		b.appendChatNote("Could not save conversation: " + err.Error())
+	} else {
+		b.Usage = Usage{} // already added to the saved conversation
+	}
+
+	b.refreshChat()
+	return nil
+}
+
+// chatError shows the error in the transcript and rolls back the failed turn so
+// the prompt can be sent again.
+func (b *Bods) chatError(e bodsError) {
+	b.chat.retry = ""
+	b.chat.history += b.chatResponse()
+	b.Output, b.glamOutput = "", ""
+	b.intervention = nil
+	b.recordUsage()
+
+	if b.chat.turnStart < len(messages) {
+		messages = messages[:b.chat.turnStart]
+	}
+	if !b.chat.started {
+		messages = append(messages, Message{Role: MessageRoleUser})
+	}
+
+	b.chat.history += b.chatErrorText(e)
+	b.state = inputState
+	b.refreshChat()
+}
+
+// recordUsage adds the rounds of the turn to the usage ledger.
+func (b *Bods) recordUsage() {
+	if err := recordUsage(b.Config, b.rounds); err != nil {
+		logger.Println("could not record usage:", err)
+	}
+	b.rounds = nil
+}
+
+// chatErrorText renders an error for the transcript with the ErrorHeader style.
+func (b *Bods) chatErrorText(e bodsError) string {
+	return b.Styles.ErrPadding.Render(b.Styles.ErrorHeader.String()+" "+e.reason) + "\n" +
+		b.Styles.ErrPadding.Render(b.Styles.ErrorDetails.Render(e.Error())) + "\n\n"
+}
+
+func (b *Bods) updateChatKey(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "ctrl+c":
+		b.state = doneState
+		return b.quit
+	case "enter":
+		if b.state != inputState {
+			return nil
+		}
+		return b.submitChatInput()
+	}
+
+	var cmd tea.Cmd
+	var cmds []tea.Cmd
+	b.chat.viewport, cmd = b.chat.viewport.Update(msg)
+	cmds = append(cmds, cmd)
+	b.chat.input, cmd = b.chat.input.Update(msg)
+	cmds = append(cmds, cmd)
+	return tea.Batch(cmds...)
+}
+
+func (b *Bods) submitChatInput() tea.Cmd {
+	prompt := strings.TrimSpace(b.chat.input.Value())
+	if prompt == "" {
+		return nil
+	}
+	b.chat.input.Reset()
+
+	if strings.HasPrefix(prompt, "/") {
+		return b.runSlashCommand(prompt)
+	}
+
+	b.appendChatUser(prompt)
+	return b.sendChatTurn(prompt)
+}
+
+// runSlashCommand executes a chat command like '/model <id>'. Settings are applied
+// to the config and paramsMessagesAPI is rebuilt, so they take effect on the next turn.
+func (b *Bods) runSlashCommand(line string) tea.Cmd {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+
+	switch name {
+	case "/quit", "/exit":
+		b.state = doneState
+		return b.quit
+
+	case "/help":
+		b.appendChatNote(chatHelpText)
+
+	case "/clear":
+		messages = []Message{{Role: MessageRoleUser}}
+		b.Input = ""
+		b.Config.ImageContent = nil
+		b.Config.ConversationID = "" // the next turn starts a new saved conversation
+		b.chat.started = false
+		b.chat.history = ""
+		b.appendChatNote("Started a new conversation.")
+
+	case "/model":
+		if arg == "" {
+			b.appendChatNote("Model: " + b.Config.ModelID)
+			break
+		}
+		modelID := modelRegistry.ResolveAlias(arg)
+		b.changeChatSetting(func(c *Config) { c.ModelID = modelID }, "Model set to "+modelID+".")
+
+	case "/think":
+		think := !b.Config.Think
+		switch strings.ToLower(arg) {
+		case "on", "true":
+			think = true
+		case "off", "false":
+			think = false
+		}
+		b.changeChatSetting(func(c *Config) { c.Think = think }, fmt.Sprintf("Thinking %s.", onOff(think)))
+
+	case "/effort":
+		if arg == "" {
+			b.appendChatNote("Effort: " + valueOrNone(b.Config.Effort))
+			break
+		}
+		effort := arg
+		if strings.EqualFold(arg, "off") || strings.EqualFold(arg, "none") {
+			effort = ""
+		}
+		b.changeChatSetting(func(c *Config) { c.Effort = effort }, "Effort set to "+valueOrNone(effort)+".")
+
+	case "/system":
+		note := "System prompt set."
+		if arg == "" {
+			note = "System prompt cleared."
+		}
+		b.changeChatSetting(func(c *Config) { c.SystemPrompt = arg }, note)
+
+	default:
+		b.appendChatNote(fmt.Sprintf("Unknown command '%s', see /help.", name))
+	}
+
+	b.refreshChat()
+	return nil
+}
+
+// changeChatSetting applies change to the config and rebuilds the inference parameters;
+// if the new settings are invalid, e.g. an effort level the model does not support,
+// the change is reverted.
+func (b *Bods) changeChatSetting(change func(*Config), note string) {
+	previous := *b.Config
+	change(b.Config)
+	if _, err := b.configureInferenceParameters(); err != nil {
+		*b.Config = previous
+		_, _ = b.configureInferenceParameters()
+		b.appendChatNote(err.Error())
+		return
+	}
+	b.appendChatNote(note)
+}
+
+func (b *Bods) appendChatUser(prompt string) {
+	style := b.Styles.Quote.Padding(0, 2)
+	if w := b.chat.viewport.Width; w > 4 {
+		style = style.Width(w)
+	}
+	b.chat.history += style.Render("› "+strings.TrimSpace(prompt)) + "\n\n"
+}
+
+func (b *Bods) appendChatNote(note string) {
+	b.chat.history += b.Styles.Comment.Padding(0, 2).Render(note) + "\n\n"
+}
+
+// chatResponse returns the response of the current turn as shown in the transcript.
+func (b *Bods) chatResponse() string {
+	if b.glamOutput != "" {
+		return b.glamOutput
+	}
+	if b.Output != "" {
+		return b.Output + "\n\n"
+	}
+	return ""
+}
+
+func (b *Bods) refreshChat() {
+	b.chat.viewport.SetContent(b.chat.history + b.chatResponse())
+	b.chat.viewport.GotoBottom()
+}
+
+func (b *Bods) resizeChat(width, height int) {
+	b.chat.input.SetWidth(width)
+	b.chat.viewport.Width = width
+	b.chat.viewport.Height = max(height-chatInputHeight-1, 1) // 1 line for the status line
+
+	// wrap responses at the window width; the initial renderer uses a fixed width
+	if r, err := glamour.NewTermRenderer(
+		glamour.WithStylePath(b.chat.glamStyle),
+		glamour.WithWordWrap(min(width-4, 100)),
+	); err == nil {
+		b.glam = r
+	}
+	b.refreshChat()
+}
+
+func (b *Bods) chatView() string {
+	status := shortModelName(b.Config.ModelID)
+	if b.Config.Think {
+		status += " · thinking"
+	}
+	if b.Config.Effort != "" {
+		status += " · effort " + b.Config.Effort
+	}
+	if b.state == requestState || b.state == responseState {
+		status += " · waiting for response…"
+		if b.chat.retry != "" {
+			status += " " + b.chat.retry
+		}
+	}
+
+	return lipgloss.JoinVertical(lipgloss.Left,
+		b.chat.viewport.View(),
+		b.Styles.Comment.Render(status),
+		b.chat.input.View(),
+	)
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+func valueOrNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
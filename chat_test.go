@@ -0,0 +1,36 @@
+package main
+
+import (
+	"io"
+	"testing"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChatSlashCommands(t *testing.T) {
+	t.Setenv("GLAMOUR_STYLE", "notty")
+	cfg := &Config{ModelID: ClaudeV48Opus.String(), Chat: true}
+	b := initialBodsModel(lipgloss.NewRenderer(io.Discard), cfg)
+
+	b.runSlashCommand("/effort bogus")
+	assert.Empty(t, cfg.Effort, "invalid effort level should be reverted")
+
+	b.runSlashCommand("/effort high")
+	assert.Equal(t, EffortHigh, cfg.Effort)
+	assert.Equal(t, EffortHigh, paramsMessagesAPI.OutputConfig.Effort)
+
+	b.runSlashCommand("/think on")
+	assert.True(t, cfg.Think)
+	assert.NotNil(t, paramsMessagesAPI.Thinking)
+
+	b.runSlashCommand("/system Be brief.")
+	assert.Equal(t, "Be brief.", paramsMessagesAPI.System.Text)
+
+	messages = append(messages, Message{Role: MessageRoleAssistant, Content: []Content{{Type: MessageContentTypeText, Text: "Hi"}}})
+	b.chat.started = true
+	b.runSlashCommand("/clear")
+	assert.Len(t, messages, 1)
+	assert.False(t, b.chat.started)
+	assert.Equal(t, ClaudeV48Opus.String(), cfg.ModelID, "settings are kept")
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"reflect"
+	"time"
 
 	"github.com/adrg/xdg"
 
@@ -47,15 +48,38 @@ type Config struct {
 	ShowSettings         bool
 	XMLTagContent        string
 	CrossRegionInference bool
-	Think                bool   // enables thinking (extended for 3.7-4.5, adaptive for Opus 4.6)
-	BudgetTokens         int    // thinking budget tokens (3.7-4.5 only; deprecated for Opus 4.6)
-	EnableTextEditor     bool   // enables text editor tool for Claude
-	Effort               string // "max", "high", "medium", "low", or empty string
+	Think                bool     // enables thinking (extended for 3.7-4.5, adaptive for Opus 4.6)
+	BudgetTokens         int      // thinking budget tokens (3.7-4.5 only; deprecated for Opus 4.6)
+	EnableTextEditor     bool     // enables text editor tool for Claude
+	ConfirmEdits         bool     // show a diff and ask before the text editor writes files
+	EnableBash           bool     // enables bash tool for Claude
+	Yes                  bool     // run tool actions without asking for confirmation
+	Effort               string   // "max", "high", "medium", "low", or empty string
+	Continue             bool     // continue the last saved conversation
+	ConversationID       string   // id (or id prefix) of a saved conversation to continue
+	ConversationTools    []string // tools of the continued conversation; their definitions are sent, but they run only if enabled
+	Chat                 bool     // interactive multi-turn chat mode (bods chat)
+	API                  string   // Bedrock API used to invoke models: invoke or converse
+	Provider             string   // provider of the models: bedrock or anthropic
+	Guardrail            string   // Bedrock guardrail as id:version, e.g. gr4bc1d2e3:1
+	ShowUsage            bool     // print token usage, latency and cost after the response
+	Cache                string   // prompt caching mode: off, auto or aggressive
+	CacheTTL             string   // lifetime of cache entries: 5m or 1h
+	DryRun               bool     // count the input tokens instead of invoking the model (bods count)
+	Output               string   // format of the response: text, json or ndjson
+	Schema               string   // JSON schema file (or inline JSON) the response must match
+	SchemaRepair         bool     // ask the model once to repair a response that does not match the schema
+
+	Retries int           // retries of an invocation that is throttled or the model unavailable
+	Timeout time.Duration // deadline of an invocation including retries and the response, 0 for none
 
 	ImagesFlagInput string // list of images e.g. file://image1.png,file://image2.jpeg
 	ImageContent    []Content
 
-	ToolCallJSONString string
+	Bash       BashConfig       // settings of the bash tool ('bash' section of bods.yaml)
+	TextEditor TextEditorConfig // settings of the text editor tool ('text_editor' section of bods.yaml)
+	Batch      BatchJobConfig   // settings of batch inference jobs ('batch' section of bods.yaml)
+	AllowPaths []string         // additional directories the text editor can access besides the working directory
 
 	VariableInput    map[string]string // mapping of input variable to values
 	VariableInputRaw string
@@ -76,7 +100,10 @@ type Prompt struct {
 	Thinking     bool   `koanf:"thinking"`
 	BudgetTokens int    `koanf:"budget_tokens"`
 	TextEditor   bool   `koanf:"text_editor"`
+	Bash         bool   `koanf:"bash"`
 	Effort       string `koanf:"effort"`
+	Guardrail    string `koanf:"guardrail"` // Bedrock guardrail as id:version
+	Schema       any    `koanf:"schema"`    // JSON schema of the response, inline or a file name
 }
 
 func newPrompt() Prompt {
@@ -119,12 +146,14 @@ func configFilePath() string {
 
 func ensureConfig() (Config, error) {
 	filePath := configFilePath()
+	bodsConfigFromFile := false
 	_, err := os.Stat(filePath)
 	if err == nil {
 		b, err := os.ReadFile(filePath)
 		if err == nil {
 			logger.Println("replacing standard embedded bods.yaml with file content from " + filePath)
 			bodsConfig = b
+			bodsConfigFromFile = true
 		}
 	}
 
@@ -145,6 +174,57 @@ func ensureConfig() (Config, error) {
 		c.Prompts = append(c.Prompts, p)
 	}
 
+	c.Bash = newBashConfig()
+	if k.Exists("bash") {
+		if err := k.Unmarshal("bash", &c.Bash); err != nil {
+			return Config{}, fmt.Errorf("invalid bash settings: %w", err)
+		}
+	}
+
+	if bodsConfigFromFile && (k.Exists("models") || k.Exists("default_model")) {
+		userModels, err := modelRegistryFromKoanf(k)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid models settings: %w", err)
+		}
+		modelRegistry.Merge(userModels)
+		logger.Printf("merged %d model(s) from %s into the model registry\n", len(userModels.Models), filePath)
+	}
+
+	c.TextEditor = newTextEditorConfig()
+	if k.Exists("text_editor") {
+		if err := k.Unmarshal("text_editor", &c.TextEditor); err != nil {
+			return Config{}, fmt.Errorf("invalid text_editor settings: %w", err)
+		}
+	}
+
+	if k.Exists("batch") {
+		if err := k.Unmarshal("batch", &c.Batch); err != nil {
+			return Config{}, fmt.Errorf("invalid batch settings: %w", err)
+		}
+	}
+
+	c.API = k.String("api")
+	if c.API == "" {
+		c.API = apiInvoke
+	}
+	c.Provider = k.String("provider")
+	if c.Provider == "" {
+		c.Provider = providerBedrock
+	}
+	c.Cache = k.String("cache")
+	if c.Cache == "" {
+		c.Cache = cacheAuto
+	}
+	c.CacheTTL = k.String("cache_ttl")
+	if c.CacheTTL == "" {
+		c.CacheTTL = cacheTTL5m
+	}
+	c.Retries = defaultRetries
+	if k.Exists("retries") {
+		c.Retries = k.Int("retries")
+	}
+	c.Timeout = k.Duration("timeout")
+
 	c.Format = true
 	c.Metamode = false
 	c.CrossRegionInference = true
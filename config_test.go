@@ -1,9 +1,14 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/adrg/xdg"
+	"github.com/knadh/koanf/v2"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestEnsureConfig(t *testing.T) {
@@ -14,3 +19,29 @@ func TestEnsureConfig(t *testing.T) {
 	assert.NoError(t, err)
 	assert.GreaterOrEqual(t, len(c.Prompts), 1)
 }
+
+func TestEnsureConfigUserFile(t *testing.T) {
+	embedded := bodsConfig
+	t.Cleanup(func() { bodsConfig, k = embedded, koanf.New("."); xdg.Reload() })
+
+	load := func(yaml string) Config {
+		home := t.TempDir()
+		t.Setenv("XDG_CONFIG_HOME", home)
+		xdg.Reload()
+		require.NoError(t, os.MkdirAll(filepath.Join(home, "bods"), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(home, "bods", "bods.yaml"), []byte(yaml), 0o600))
+		k = koanf.New(".")
+		c, err := ensureConfig()
+		require.NoError(t, err)
+		require.Len(t, c.Prompts, 1, "the prompts of the file only")
+		return c
+	}
+
+	// the file replaces the embedded bods.yaml, the default deny list still applies
+	c := load("prompts:\n  hello:\n    user: say hello\n")
+	assert.Equal(t, defaultBashDeny, c.Bash.Deny)
+	assert.Equal(t, defaultBashTimeout, c.Bash.Timeout)
+
+	c = load("prompts:\n  hello:\n    user: say hello\nbash:\n  deny: [curl]\n")
+	assert.Equal(t, []string{"curl"}, c.Bash.Deny)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/huh"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+var errNoTerminal = errors.New("no terminal available to ask for confirmation")
+
+// confirmMu serializes confirmations, e.g. of parallel tool calls.
+var confirmMu sync.Mutex
+
+// runInteractiveForm shows a huh form on the terminal while a tool is executed.
+// The running Bubble Tea program is paused for that time, and as stdin might be
+// piped input, the form reads from the controlling terminal. A non-empty header,
+// e.g. a diff, is printed on stderr before the form.
+func runInteractiveForm(header string, form *huh.Form) error {
+	confirmMu.Lock()
+	defer confirmMu.Unlock()
+
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		logger.Println("runInteractiveForm: could not open /dev/tty:", err)
+		return errNoTerminal
+	}
+	defer tty.Close()
+
+	if program != nil {
+		if err := program.ReleaseTerminal(); err != nil {
+			return fmt.Errorf("could not release terminal: %w", err)
+		}
+		defer func() {
+			if err := program.RestoreTerminal(); err != nil {
+				logger.Println("runInteractiveForm: could not restore terminal:", err)
+			}
+		}()
+	}
+
+	if header != "" {
+		fmt.Fprintln(os.Stderr, header)
+	}
+	return form.WithInput(tty).WithOutput(os.Stderr).Run()
+}
+
+// confirm asks the user a yes/no question; description is shown below the title.
+func confirm(title, description string) (bool, error) {
+	var confirmed bool
+	err := runInteractiveForm("", huh.NewForm(
+		huh.NewGroup(
+			huh.NewConfirm().
+				Title(title).
+				Description(description).
+				Affirmative("Yes").
+				Negative("No").
+				Value(&confirmed),
+		),
+	))
+	if errors.Is(err, huh.ErrUserAborted) {
+		return false, nil
+	}
+	return confirmed, err
+}
+
+type editDecision int
+
+const (
+	editAccept editDecision = iota
+	editReject
+	editAcceptAll
+)
+
+// confirmEdit shows the diff of a proposed file edit and asks the user to accept
+// or reject it, or to accept all further edits. On rejection the user can give a
+// reason, which is sent back to Claude.
+func confirmEdit(path, diff string) (editDecision, string, error) {
+	decision := editAccept
+	var reason string
+	err := runInteractiveForm(renderDiff(diff), huh.NewForm(
+		huh.NewGroup(
+			huh.NewSelect[editDecision]().
+				Title("Apply this edit to "+path+"?").
+				Options(
+					huh.NewOption("Accept", editAccept),
+					huh.NewOption("Reject", editReject),
+					huh.NewOption("Accept all (don't ask again)", editAcceptAll),
+				).
+				Value(&decision),
+		),
+		huh.NewGroup(
+			huh.NewInput().
+				Title("Why? The reason is sent to Claude.").
+				Placeholder("optional").
+				Value(&reason),
+		).WithHideFunc(func() bool { return decision != editReject }),
+	))
+	if errors.Is(err, huh.ErrUserAborted) {
+		return editReject, "", nil
+	}
+	return decision, strings.TrimSpace(reason), err
+}
+
+// unifiedDiff returns the unified diff of a change of the file at path; an empty
+// oldContent is a new file.
+func unifiedDiff(path, oldContent, newContent string) string {
+	fromFile := path
+	if oldContent == "" {
+		fromFile = "/dev/null"
+	}
+	splitLines := func(s string) []string {
+		if s == "" {
+			return nil
+		}
+		lines := strings.SplitAfter(s, "\n")
+		if lines[len(lines)-1] == "" {
+			return lines[:len(lines)-1]
+		}
+		lines[len(lines)-1] += "\n\\ No newline at end of file\n"
+		return lines
+	}
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(oldContent),
+		B:        splitLines(newContent),
+		FromFile: fromFile,
+		ToFile:   path,
+		Context:  3,
+	})
+	if err != nil {
+		logger.Println("unifiedDiff: ", err)
+	}
+	return diff
+}
+
+// renderDiff colors the lines of a unified diff for stderr.
+func renderDiff(diff string) string {
+	s := stderrStyles()
+	lines := strings.Split(strings.TrimSuffix(diff, "\n"), "\n")
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+			lines[i] = s.DiffHeader.Render(line)
+		case strings.HasPrefix(line, "@@"):
+			lines[i] = s.DiffHunk.Render(line)
+		case strings.HasPrefix(line, "+"):
+			lines[i] = s.DiffAdd.Render(line)
+		case strings.HasPrefix(line, "-"):
+			lines[i] = s.DiffDelete.Render(line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"crypto/sha1" // #nosec G505 - only used to derive conversation ids, not for security
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/adrg/xdg"
+	"github.com/tidwall/buntdb"
+)
+
+const conversationKeyPrefix = "conversation:"
+
+// titleMaxLength is the number of characters of the first user prompt used as conversation title.
+const titleMaxLength = 60
+
+var errNoConversations = errors.New("no saved conversations found")
+
+// Conversation is a finished exchange with Claude as persisted in the local
+// conversation store, so it can be picked up again with --continue or --conversation.
+type Conversation struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	ModelID   string    `json:"model_id"`
+	Messages  []Message `json:"messages"`
+	Usage     Usage     `json:"usage"` // token totals of all requests of this conversation
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// Params are the inference parameters sent with the last request; Messages is left empty
+	Params *AnthropicClaudeMessagesInferenceParameters `json:"params,omitempty"`
+}
+
+func conversationsDBFilePath() string {
+	dataDir := filepath.Join(xdg.DataHome, "bods")
+	_, err := os.Stat(dataDir)
+	if os.IsNotExist(err) {
+		err = os.MkdirAll(dataDir, 0o755)
+		if err != nil {
+			logger.Printf("could not create directory %s: %v", dataDir, err)
+		}
+	}
+
+	return filepath.Join(dataDir, "conversations.db")
+}
+
+func newConversationID(title string) string {
+	h := sha1.New() // #nosec G401
+	_, _ = fmt.Fprintf(h, "%d:%s", time.Now().UnixNano(), title)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// conversationTitle returns the first line of the given prompt text shortened to titleMaxLength.
+func conversationTitle(prompt string) string {
+	title, _, _ := strings.Cut(strings.TrimSpace(prompt), "\n")
+	title = strings.TrimSpace(title)
+	if len([]rune(title)) > titleMaxLength {
+		title = string([]rune(title)[:titleMaxLength-3]) + "..."
+	}
+	if title == "" {
+		title = "(untitled)"
+	}
+	return title
+}
+
+// compactMessages prepares messages for storage: consecutive messages of the same role
+// (e.g. an assistant prefill followed by the streamed response) are merged, and a
+// trailing assistant turn with unanswered tool_use blocks is dropped, as a conversation
+// ending like that would be rejected by the API when continued. The forced tool call
+// of a --schema response is the response, it is stored as text like a structured output.
+func compactMessages(msgs []Message) []Message {
+	var compacted []Message
+	for _, m := range withSchemaToolAsText(msgs) {
+		if len(m.Content) == 0 {
+			continue
+		}
+		last := len(compacted) - 1
+		if last >= 0 && compacted[last].Role == m.Role {
+			if m.Role == MessageRoleAssistant && len(compacted[last].Content) > 0 && len(m.Content) > 0 &&
+				compacted[last].Content[len(compacted[last].Content)-1].Type == MessageContentTypeText && m.Content[0].Type == MessageContentTypeText {
+				// response continues the prefilled assistant text
+				compacted[last].Content[len(compacted[last].Content)-1].Text += strings.TrimPrefix(m.Content[0].Text, " ")
+				compacted[last].Content = append(compacted[last].Content, m.Content[1:]...)
+				continue
+			}
+			compacted[last].Content = append(compacted[last].Content, m.Content...)
+			continue
+		}
+		compacted = append(compacted, Message{Role: m.Role, Content: slices.Clone(m.Content)})
+	}
+
+	if last := len(compacted) - 1; last >= 0 && compacted[last].Role == MessageRoleAssistant {
+		if slices.ContainsFunc(compacted[last].Content, func(c Content) bool { return c.Type == MessageContentTypeToolUse }) {
+			compacted = compacted[:last]
+		}
+	}
+
+	return compacted
+}
+
+// withSchemaToolAsText returns msgs with the calls of the structured_output tool of
+// --schema replaced by their JSON input and their results, asking for a repair, by
+// their text, so a continued conversation does not need the tool.
+func withSchemaToolAsText(msgs []Message) []Message {
+	calls := make(map[string]bool) // ids of the schema tool calls
+	for _, m := range msgs {
+		for _, c := range m.Content {
+			if c.Type == MessageContentTypeToolUse && c.Name == schemaToolName {
+				calls[c.ID] = true
+			}
+		}
+	}
+	if len(calls) == 0 {
+		return msgs
+	}
+
+	converted := make([]Message, len(msgs))
+	for i, m := range msgs {
+		converted[i] = Message{Role: m.Role, Content: slices.Clone(m.Content)}
+		for j, c := range converted[i].Content {
+			switch {
+			case c.Type == MessageContentTypeToolUse && calls[c.ID]:
+				converted[i].Content[j] = Content{Type: MessageContentTypeText, Text: string(c.Input)}
+			case c.Type == MessageContentTypeToolResult && calls[c.ToolUseID]:
+				converted[i].Content[j] = Content{Type: MessageContentTypeText, Text: c.Content}
+			}
+		}
+	}
+	return converted
+}
+
+// withoutCacheControl returns a copy of msgs with all cache_control markers removed, so
+// that cache checkpoints of earlier requests do not add up when a conversation is continued.
+func withoutCacheControl(msgs []Message) []Message {
+	stripped := make([]Message, len(msgs))
+	for i, m := range msgs {
+		stripped[i] = Message{Role: m.Role, Content: slices.Clone(m.Content)}
+		for j := range stripped[i].Content {
+			stripped[i].Content[j].CacheControl = nil
+		}
+	}
+	return stripped
+}
+
+func saveConversation(c *Conversation) error {
+	db, err := buntdb.Open(conversationsDBFilePath())
+	if err != nil {
+		logger.Println("saveConversation() buntdb.Open - ", err)
+		return err
+	}
+	defer db.Close()
+
+	value, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(tx *buntdb.Tx) error {
+		_, _, err := tx.Set(conversationKeyPrefix+c.ID, string(value), nil)
+		return err
+	})
+}
+
+// listConversations returns all stored conversations, most recently updated first.
+func listConversations() ([]Conversation, error) {
+	db, err := buntdb.Open(conversationsDBFilePath())
+	if err != nil {
+		logger.Println("listConversations() buntdb.Open - ", err)
+		return nil, err
+	}
+	defer db.Close()
+
+	var conversations []Conversation
+	err = db.View(func(tx *buntdb.Tx) error {
+		var decodeErr error
+		err := tx.AscendKeys(conversationKeyPrefix+"*", func(key, value string) bool {
+			var c Conversation
+			if decodeErr = json.Unmarshal([]byte(value), &c); decodeErr != nil {
+				decodeErr = fmt.Errorf("could not decode %s: %w", key, decodeErr)
+				return false
+			}
+			conversations = append(conversations, c)
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		return decodeErr
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(conversations, func(a, b Conversation) int {
+		return b.UpdatedAt.Compare(a.UpdatedAt)
+	})
+
+	return conversations, nil
+}
+
+// findConversation returns the conversation with the given id; like git, an
+// unambiguous id prefix is accepted as well.
+func findConversation(id string) (*Conversation, error) {
+	conversations, err := listConversations()
+	if err != nil {
+		return nil, err
+	}
+
+	id = strings.ToLower(strings.TrimSpace(id))
+	var matches []Conversation
+	for _, c := range conversations {
+		if c.ID == id {
+			return &c, nil
+		}
+		if id != "" && strings.HasPrefix(c.ID, id) {
+			matches = append(matches, c)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no conversation found with id '%s'", id)
+	case 1:
+		return &matches[0], nil
+	default:
+		return nil, fmt.Errorf("conversation id '%s' is ambiguous, it matches %d conversations", id, len(matches))
+	}
+}
+
+// deleteConversations removes the conversations with the given ids from the store.
+func deleteConversations(ids ...string) error {
+	db, err := buntdb.Open(conversationsDBFilePath())
+	if err != nil {
+		logger.Println("deleteConversations() buntdb.Open - ", err)
+		return err
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *buntdb.Tx) error {
+		for _, id := range ids {
+			if _, err := tx.Delete(conversationKeyPrefix + id); err != nil {
+				return fmt.Errorf("could not delete conversation %s: %w", id, err)
+			}
+		}
+		return nil
+	})
+}
+
+// lastConversation returns the most recently updated conversation.
+func lastConversation() (*Conversation, error) {
+	conversations, err := listConversations()
+	if err != nil {
+		return nil, err
+	}
+	if len(conversations) == 0 {
+		return nil, errNoConversations
+	}
+	return &conversations[0], nil
+}
+
+// loadConversationToContinue returns the conversation selected with --continue
+// or --conversation, or nil if neither is given.
+func loadConversationToContinue(cfg *Config) (*Conversation, error) {
+	switch {
+	case cfg.ConversationID != "":
+		return findConversation(cfg.ConversationID)
+	case cfg.Continue:
+		return lastConversation()
+	default:
+		return nil, nil
+	}
+}
+
+// restoreConversation makes the given conversation the start of the current exchange:
+// previous messages are restored, a new user turn is appended, and settings of the
+// previous run are used unless they are explicitly given again on the command line.
+// thinkGiven reports whether --think was given, e.g. --think=false to turn off the
+// thinking of the conversation. Its tools are not enabled again, see ConversationTools.
+func restoreConversation(cfg *Config, c *Conversation, thinkGiven bool) {
+	messages = append(withoutCacheControl(c.Messages), Message{Role: MessageRoleUser})
+	cfg.ConversationID = c.ID
+
+	if cfg.ModelID == "" && cfg.PromptTemplate == "" {
+		cfg.ModelID = c.ModelID
+	}
+
+	if c.Params == nil {
+		return
+	}
+	if cfg.SystemPrompt == "" && cfg.PromptTemplate == "" {
+		cfg.SystemPrompt = c.Params.System.Text
+	}
+	if cfg.MaxTokens == 0 && cfg.PromptTemplate == "" {
+		cfg.MaxTokens = c.Params.MaxTokens
+	}
+	if c.Params.Thinking != nil && !thinkGiven {
+		cfg.Think = true
+	}
+	if cfg.Think && cfg.BudgetTokens == 0 && c.Params.Thinking != nil && c.Params.Thinking.BudgetTokens != 0 {
+		cfg.BudgetTokens = c.Params.Thinking.BudgetTokens
+	}
+	if cfg.Effort == "" && c.Params.OutputConfig != nil {
+		cfg.Effort = c.Params.OutputConfig.Effort
+	}
+	for _, tool := range c.Params.Tools { // tool_use blocks in the history require the tool definition
+		name := ""
+		if def, ok := tool.(map[string]any); ok {
+			name, _ = def["name"].(string)
+		}
+		switch name { // other tools, like the structured_output tool of --schema, are not used again
+		case BashToolName, TextEditorToolNameLegacy, TextEditorToolNameNew:
+			if !slices.Contains(cfg.ConversationTools, name) {
+				cfg.ConversationTools = append(cfg.ConversationTools, name)
+			}
+		}
+	}
+}
+
+// recordConversation stores the current exchange, either as a new conversation
+// or by updating the one that was continued. The stored conversation is returned.
+func recordConversation(cfg *Config, usage Usage) (*Conversation, error) {
+	msgs := compactMessages(messages)
+	if len(msgs) == 0 || msgs[len(msgs)-1].Role != MessageRoleAssistant {
+		return nil, nil // nothing was answered, nothing to store
+	}
+
+	now := time.Now()
+	c := &Conversation{CreatedAt: now}
+	if cfg.ConversationID != "" {
+		existing, err := findConversation(cfg.ConversationID)
+		if err == nil {
+			c = existing
+		}
+	}
+	if c.ID == "" {
+		title := cfg.Prefix
+		if strings.TrimSpace(title) == "" {
+			title = firstUserText(msgs)
+		}
+		c.Title = conversationTitle(title)
+		c.ID = newConversationID(c.Title)
+	}
+
+	params := *paramsMessagesAPI
+	params.Messages = nil
+
+	c.ModelID = cfg.ModelID
+	c.Messages = msgs
+	c.Usage.Add(usage)
+	c.Params = &params
+	c.UpdatedAt = now
+
+	if err := saveConversation(c); err != nil {
+		return nil, err
+	}
+	cfg.ConversationID = c.ID
+	logger.Printf("saved conversation id=%s title=%s messages=%d\n", c.ID, c.Title, len(c.Messages))
+
+	if err := linkEditSession(c.ID); err != nil {
+		logger.Println("could not link edits to conversation:", err)
+	}
+
+	return c, nil
+}
+
+// firstUserText returns the text of the first text content block sent by the user.
+func firstUserText(msgs []Message) string {
+	for _, m := range msgs {
+		if m.Role != MessageRoleUser {
+			continue
+		}
+		for _, c := range m.Content {
+			if c.Type == MessageContentTypeText && strings.TrimSpace(c.Text) != "" {
+				return c.Text
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/glamour"
+	"github.com/spf13/cobra"
+)
+
+const (
+	exportFormatMarkdown = "markdown"
+	exportFormatJSON     = "json"
+)
+
+var (
+	deleteOlderThan string
+	exportFormat    string
+	exportOutput    string
+
+	listCmd = &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"ls"},
+		Short:   "List saved conversations",
+		Args:    noPrompt(cobra.NoArgs),
+		RunE: func(_ *cobra.Command, _ []string) error {
+			conversations, err := listConversations()
+			if err != nil {
+				return bodsError{err, "Could not read saved conversations."}
+			}
+			if len(conversations) == 0 {
+				_, _ = fmt.Fprintln(os.Stderr, "No saved conversations.")
+				return nil
+			}
+
+			s := stdoutStyles()
+			for _, c := range conversations {
+				tokens := fmt.Sprintf("%s in / %s out", formatTokens(c.Usage.InputTokens), formatTokens(c.Usage.OutputTokens))
+				fmt.Println(s.ConversationList.Render(
+					s.Bullet.String() +
+						s.SHA1.Render(c.ID[:7]) + " " +
+						c.Title + " " +
+						s.Comment.Render(shortModelName(c.ModelID)+", "+tokens) + " " +
+						s.Timeago.Render(timeAgo(c.UpdatedAt)),
+				))
+			}
+			return nil
+		},
+	}
+
+	showCmd = &cobra.Command{
+		Use:               "show [id]",
+		Short:             "Show a saved conversation (default: the last one)",
+		Args:              noPrompt(cobra.MaximumNArgs(1)),
+		ValidArgsFunction: completeConversationIDs,
+		RunE: func(_ *cobra.Command, args []string) error {
+			c, err := conversationFromArgs(args)
+			if err != nil {
+				return bodsError{err, "Could not load conversation."}
+			}
+
+			md := conversationMarkdown(c)
+			if !isOutputTerminal() {
+				fmt.Print(md)
+				return nil
+			}
+
+			r, err := glamour.NewTermRenderer(
+				glamour.WithEnvironmentConfig(),
+				glamour.WithWordWrap(100),
+				glamour.WithAutoStyle(),
+			)
+			if err != nil {
+				return bodsError{err, "Could not create markdown renderer."}
+			}
+			out, err := r.Render(md)
+			if err != nil {
+				return bodsError{err, "Could not render conversation."}
+			}
+			fmt.Print(out)
+			return nil
+		},
+	}
+
+	deleteCmd = &cobra.Command{
+		Use:               "delete [id]...",
+		Aliases:           []string{"rm"},
+		Short:             "Delete saved conversations by id or age",
+		ValidArgsFunction: completeConversationIDs,
+		RunE: func(_ *cobra.Command, args []string) error {
+			if len(args) == 0 && deleteOlderThan == "" {
+				return bodsError{errors.New("no conversation id and no --older-than given"), "Nothing to delete."}
+			}
+
+			var ids []string
+			for _, arg := range args {
+				c, err := findConversation(arg)
+				if err != nil {
+					return bodsError{err, "Could not delete conversation."}
+				}
+				ids = append(ids, c.ID)
+			}
+
+			if deleteOlderThan != "" {
+				age, err := parseAge(deleteOlderThan)
+				if err != nil {
+					return bodsError{err, "Invalid value for --older-than."}
+				}
+				conversations, err := listConversations()
+				if err != nil {
+					return bodsError{err, "Could not read saved conversations."}
+				}
+				for _, c := range conversations {
+					if time.Since(c.UpdatedAt) > age {
+						ids = append(ids, c.ID)
+					}
+				}
+			}
+
+			// a conversation given by id can also be older than --older-than
+			slices.Sort(ids)
+			ids = slices.Compact(ids)
+			if err := deleteConversations(ids...); err != nil {
+				return bodsError{err, "Could not delete conversation."}
+			}
+			_, _ = fmt.Fprintf(os.Stderr, "Deleted %d conversation(s).\n", len(ids))
+			return nil
+		},
+	}
+
+	exportCmd = &cobra.Command{
+		Use:               "export [id]",
+		Short:             "Export a saved conversation as Markdown or Messages API JSON (default: the last one)",
+		Args:              noPrompt(cobra.MaximumNArgs(1)),
+		ValidArgsFunction: completeConversationIDs,
+		RunE: func(_ *cobra.Command, args []string) error {
+			c, err := conversationFromArgs(args)
+			if err != nil {
+				return bodsError{err, "Could not load conversation."}
+			}
+
+			var data []byte
+			switch exportFormat {
+			case exportFormatMarkdown, "md":
+				data = []byte(conversationMarkdown(c))
+			case exportFormatJSON:
+				params := NewAnthropicClaudeMessagesInferenceParameters()
+				if c.Params != nil {
+					params = c.Params
+				}
+				params.Messages = c.Messages
+				data, err = json.MarshalIndent(params, "", "  ")
+				if err != nil {
+					return bodsError{err, "Could not encode conversation."}
+				}
+				data = append(data, '\n')
+			default:
+				return bodsError{fmt.Errorf("unknown export format '%s'", exportFormat), "Valid formats are 'markdown' and 'json'."}
+			}
+
+			if exportOutput == "" || exportOutput == "-" {
+				_, err = os.Stdout.Write(data)
+				return err
+			}
+			if err := os.WriteFile(exportOutput, data, 0o600); err != nil {
+				return bodsError{err, "Could not write export file."}
+			}
+			return nil
+		},
+	}
+)
+
+func initConversationCommands() {
+	deleteCmd.Flags().StringVar(&deleteOlderThan, "older-than", "", "Delete conversations not updated within the given age, e.g. 30d, 2w or 12h")
+
+	exportCmd.Flags().StringVarP(&exportFormat, "format", "f", exportFormatMarkdown, "Export format (markdown or json)")
+	exportCmd.Flags().StringVarP(&exportOutput, "out", "o", "", "File to write to (default stdout)")
+	_ = exportCmd.RegisterFlagCompletionFunc("format",
+		func(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+			return []string{exportFormatMarkdown, exportFormatJSON}, cobra.ShellCompDirectiveDefault
+		},
+	)
+
+	rootCmd.AddCommand(listCmd, showCmd, deleteCmd, exportCmd)
+}
+
+func completeConversationIDs(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+	conversations, err := listConversations()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	var ids []string
+	for _, c := range conversations {
+		ids = append(ids, c.ID[:7]+"\t"+c.Title)
+	}
+	return ids, cobra.ShellCompDirectiveNoFileComp
+}
+
+func conversationFromArgs(args []string) (*Conversation, error) {
+	if len(args) == 0 {
+		return lastConversation()
+	}
+	return findConversation(args[0])
+}
+
+// conversationMarkdown renders a conversation as Markdown document.
+func conversationMarkdown(c *Conversation) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# %s\n\n", c.Title)
+	fmt.Fprintf(&sb, "_%s · %s · %s_\n\n", c.ID[:7], shortModelName(c.ModelID), c.UpdatedAt.Format("2006-01-02 15:04"))
+	if c.Params != nil && strings.TrimSpace(c.Params.System.Text) != "" {
+		fmt.Fprintf(&sb, "## System\n\n%s\n\n", strings.TrimSpace(c.Params.System.Text))
+	}
+	sb.WriteString(messagesMarkdown(c.Messages))
+
+	return sb.String()
+}
+
+// messagesMarkdown renders messages as Markdown with one section per message.
+func messagesMarkdown(msgs []Message) string {
+	var sb strings.Builder
+	for _, m := range msgs {
+		role := "User"
+		if m.Role == MessageRoleAssistant {
+			role = "Assistant"
+		}
+		fmt.Fprintf(&sb, "## %s\n\n", role)
+
+		for _, content := range m.Content {
+			switch content.Type {
+			case MessageContentTypeText:
+				text := strings.TrimSpace(content.Text)
+				if text == "" || text == strings.TrimSpace(defaultMarkdownFormatText) {
+					continue
+				}
+				fmt.Fprintf(&sb, "%s\n\n", text)
+			case MessageContentTypeThinking:
+				fmt.Fprintf(&sb, "> **Thinking**\n>\n> %s\n\n", strings.ReplaceAll(strings.TrimSpace(content.Thinking), "\n", "\n> "))
+			case MessageContentTypeImage:
+				sb.WriteString("_[image]_\n\n")
+			case MessageContentTypeDocument:
+				sb.WriteString("_[document]_\n\n")
+			case MessageContentTypeToolUse:
+				fmt.Fprintf(&sb, "**Tool call** `%s`\n\n```json\n%s\n```\n\n", content.Name, string(content.Input))
+			case MessageContentTypeToolResult:
+				fmt.Fprintf(&sb, "**Tool result**\n\n```\n%s\n```\n\n", strings.TrimSpace(content.Content))
+			}
+		}
+	}
+
+	return sb.String()
+}
+
+// shortModelName strips any inference profile and provider prefix from a model id.
+func shortModelName(id string) string {
+	return strings.TrimPrefix(normalizeToModelID(id), "anthropic.")
+}
+
+// formatTokens formats a token count in a compact form e.g. 1.2k.
+func formatTokens(n int) string {
+	switch {
+	case n >= 1_000_000:
+		return fmt.Sprintf("%.1fM", float64(n)/1_000_000)
+	case n >= 1_000:
+		return fmt.Sprintf("%.1fk", float64(n)/1_000)
+	default:
+		return strconv.Itoa(n)
+	}
+}
+
+// timeAgo returns a human readable description of how long ago t was.
+func timeAgo(t time.Time) string {
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("1 %s ago", unit)
+		}
+		return fmt.Sprintf("%d %ss ago", n, unit)
+	}
+
+	d := time.Since(t)
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return plural(int(d.Minutes()), "minute")
+	case d < 24*time.Hour:
+		return plural(int(d.Hours()), "hour")
+	case d < 7*24*time.Hour:
+		return plural(int(d.Hours()/24), "day")
+	case d < 30*24*time.Hour:
+		return plural(int(d.Hours()/(24*7)), "week")
+	case d < 365*24*time.Hour:
+		return plural(int(d.Hours()/(24*30)), "month")
+	default:
+		return plural(int(d.Hours()/(24*365)), "year")
+	}
+}
+
+// parseAge parses a duration like time.ParseDuration, additionally accepting
+// days (d) and weeks (w) as unit e.g. 30d or 2w.
+func parseAge(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			v, err := strconv.Atoi(n)
+			if err != nil || v < 0 {
+				return 0, fmt.Errorf("invalid age '%s'", s)
+			}
+			return time.Duration(v) * unit, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid age '%s', use e.g. 30d, 2w or 12h", s)
+	}
+	return d, nil
+}
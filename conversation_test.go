@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/adrg/xdg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompactMessages(t *testing.T) {
+	msgs := []Message{
+		{Role: MessageRoleUser, Content: []Content{{Type: MessageContentTypeText, Text: "Give me JSON"}}},
+		{Role: MessageRoleAssistant, Content: []Content{{Type: MessageContentTypeText, Text: "{"}}},
+		{Role: MessageRoleAssistant, Content: []Content{{Type: MessageContentTypeText, Text: ` "a": 1}`}}},
+		{Role: MessageRoleUser, Content: []Content{{Type: MessageContentTypeText, Text: "Now edit a file"}}},
+		{Role: MessageRoleAssistant, Content: []Content{{Type: MessageContentTypeToolUse, ID: "toolu_1", Name: TextEditorToolNameNew}}},
+	}
+
+	compacted := compactMessages(msgs)
+
+	assert.Len(t, compacted, 3)
+	assert.Equal(t, `{"a": 1}`, compacted[1].Content[0].Text)
+	assert.Equal(t, MessageRoleUser, compacted[2].Role, "unanswered tool_use turn should be dropped")
+}
+
+func TestCompactMessagesSchemaTool(t *testing.T) {
+	msgs := []Message{
+		{Role: MessageRoleUser, Content: []Content{{Type: MessageContentTypeText, Text: "Summarize"}}},
+		{Role: MessageRoleAssistant, Content: []Content{{Type: MessageContentTypeToolUse, ID: "toolu_1", Name: schemaToolName, Input: json.RawMessage(`{}`)}}},
+		{Role: MessageRoleUser, Content: []Content{{Type: MessageContentTypeToolResult, ToolUseID: "toolu_1", Content: "missing summary", IsError: true}}},
+		{Role: MessageRoleAssistant, Content: []Content{{Type: MessageContentTypeToolUse, ID: "toolu_2", Name: schemaToolName, Input: json.RawMessage(`{"summary":"ok"}`)}}},
+	}
+
+	compacted := compactMessages(msgs)
+
+	require.Len(t, compacted, 4, "the forced tool call of --schema is the response and is stored")
+	assert.Equal(t, Content{Type: MessageContentTypeText, Text: "missing summary"}, compacted[2].Content[0])
+	assert.Equal(t, Content{Type: MessageContentTypeText, Text: `{"summary":"ok"}`}, compacted[3].Content[0])
+	assert.Equal(t, MessageContentTypeToolUse, msgs[3].Content[0].Type, "messages are not modified")
+}
+
+func TestConversationTitle(t *testing.T) {
+	assert.Equal(t, "Summarize this", conversationTitle("  Summarize this\nand more lines"))
+	assert.Equal(t, "(untitled)", conversationTitle(" "))
+	assert.Len(t, []rune(conversationTitle(string(make([]rune, 200)))), titleMaxLength)
+}
+
+// useTempDataHome points xdg.DataHome to a temporary directory for the test.
+func useTempDataHome(t *testing.T) {
+	t.Helper()
+	dataHome := xdg.DataHome
+	xdg.DataHome = t.TempDir()
+	t.Cleanup(func() { xdg.DataHome = dataHome })
+}
+
+func TestConversationStore(t *testing.T) {
+	useTempDataHome(t)
+
+	_, err := lastConversation()
+	assert.ErrorIs(t, err, errNoConversations)
+
+	c := &Conversation{ID: "abcdef0123", Title: "first"}
+	assert.NoError(t, saveConversation(c))
+
+	found, err := findConversation("abcd")
+	assert.NoError(t, err)
+	assert.Equal(t, "first", found.Title)
+
+	_, err = findConversation("ffff")
+	assert.Error(t, err)
+}
+
+func TestRestoreConversationTools(t *testing.T) {
+	defer func() { messages = nil }()
+	tools := func(names ...string) *Conversation {
+		c := &Conversation{ID: "abcdef0123", Params: &AnthropicClaudeMessagesInferenceParameters{}}
+		for _, name := range names {
+			c.Params.Tools = append(c.Params.Tools, map[string]any{"name": name})
+		}
+		return c
+	}
+
+	var cfg Config
+	restoreConversation(&cfg, tools(schemaToolName, ""), false)
+	assert.Empty(t, cfg.ConversationTools, "the schema tool is not used again")
+
+	cfg = Config{}
+	restoreConversation(&cfg, tools(TextEditorToolNameNew, BashToolName), false)
+	assert.Equal(t, []string{TextEditorToolNameNew, BashToolName}, cfg.ConversationTools)
+	assert.False(t, cfg.EnableTextEditor, "the tools run only with --text-editor and --bash")
+	assert.False(t, cfg.EnableBash)
+}
+
+func TestRestoreConversationThinking(t *testing.T) {
+	defer func() { messages = nil }()
+	c := &Conversation{ID: "abcdef0123", Params: &AnthropicClaudeMessagesInferenceParameters{Thinking: NewThinkingConfig()}}
+
+	var cfg Config
+	restoreConversation(&cfg, c, false)
+	assert.True(t, cfg.Think)
+	assert.Equal(t, c.Params.Thinking.BudgetTokens, cfg.BudgetTokens)
+
+	cfg = Config{}
+	restoreConversation(&cfg, c, true) // --think=false
+	assert.False(t, cfg.Think)
+	assert.Zero(t, cfg.BudgetTokens)
+}
+
+func TestParseAge(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected time.Duration
+		wantErr  bool
+	}{
+		{input: "30d", expected: 30 * 24 * time.Hour},
+		{input: "2w", expected: 14 * 24 * time.Hour},
+		{input: "12h", expected: 12 * time.Hour},
+		{input: "xd", wantErr: true},
+		{input: "soon", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseAge(tt.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
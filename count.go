@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/spf13/cobra"
+)
+
+// Estimates of images and PDF pages, see https://docs.anthropic.com/en/docs/build-with-claude/vision
+// and https://docs.anthropic.com/en/docs/build-with-claude/pdf-support
+const (
+	imagePixelsPerToken = 750
+	imageMaxEdge        = 1568      // pixels of the long edge, larger images are scaled down
+	imageMaxPixels      = 1_150_000 // larger images are scaled down
+	imageTokens         = 1600      // if the size of an image is unknown
+	pdfPageTokens       = 2000      // text and image of a page, 1500 to 3000 depending on the content
+)
+
+var countCmd = &cobra.Command{
+	Use:   "count [prompt]",
+	Short: "Count the input tokens of a request without invoking the model",
+	Long: `Build the request like 'bods [prompt]' does, with piped input, prompt template,
+images and tools, and print the estimated input tokens per content block. The
+total is counted with the CountTokens API where available. Warns if the request
+exceeds the context window or the output limit of the model. Same as --dry-run.`,
+	Args: cobra.ArbitraryArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config.DryRun = true
+		return rootCmd.RunE(cmd, args)
+	},
+}
+
+// tokenCounter is implemented by backends that can count the input tokens of a
+// request with the API of the provider.
+type tokenCounter interface {
+	CountTokens(ctx context.Context, request modelRequest) (int, error)
+}
+
+// tokenCount is the result of a dry run, see bods count.
+type tokenCount struct {
+	ModelID   string
+	Blocks    []blockTokens
+	Estimate  int    // sum of the estimates of the blocks
+	Counted   int    // counted by the API, 0 if not available
+	CountErr  error  // why the tokens could not be counted by the API
+	MaxTokens int    // max_tokens of the request, including thinking
+	Thinking  string // budget_tokens, "adaptive" or empty without thinking
+	Warnings  []string
+}
+
+// blockTokens is the estimate of a part of the request.
+type blockTokens struct {
+	Name   string // e.g. system, tool bash or user 1: text
+	Tokens int
+}
+
+// Input returns the input tokens, counted or estimated.
+func (c tokenCount) Input() int {
+	if c.Counted > 0 {
+		return c.Counted
+	}
+	return c.Estimate
+}
+
+// countTokens returns the tokenCount of the request as tea.Msg, instead of invoking
+// the model.
+func (b *Bods) countTokens(params *AnthropicClaudeMessagesInferenceParameters) tea.Msg {
+	model := lookupModel(b.Config.ModelID)
+	count := tokenCount{ModelID: b.Config.ModelID, MaxTokens: params.MaxTokens}
+	count.Blocks = requestTokens(model, params)
+	for _, block := range count.Blocks {
+		count.Estimate += block.Tokens
+	}
+	if t := params.Thinking; t != nil {
+		count.Thinking = t.Type
+		if t.BudgetTokens > 0 {
+			count.Thinking = strconv.Itoa(t.BudgetTokens)
+		}
+	}
+
+	if counter, ok := b.backend.(tokenCounter); ok {
+		count.Counted, count.CountErr = counter.CountTokens(*b.context, modelRequest{ModelID: b.Config.ModelID, Params: params})
+		if count.CountErr != nil {
+			logger.Println("CountTokens:", count.CountErr)
+		}
+	} else {
+		count.CountErr = fmt.Errorf("not supported by the %s api", b.Config.API)
+	}
+
+	count.Warnings = tokenWarnings(model, count.Input(), params)
+	return count
+}
+
+// requestTokens estimates the input tokens of the system prompt, every tool
+// definition and every content block of the request.
+func requestTokens(model ModelInfo, params *AnthropicClaudeMessagesInferenceParameters) []blockTokens {
+	var blocks []blockTokens
+	if params.System.Text != "" {
+		blocks = append(blocks, blockTokens{"system", model.EstimateTokens(params.System.Text)})
+	}
+	for _, t := range params.Tools {
+		data, _ := json.Marshal(t)
+		name := "tool"
+		if def, ok := t.(ToolDefinition); ok {
+			name += " " + def.Name
+		}
+		blocks = append(blocks, blockTokens{name, model.EstimateTokens(string(data))})
+	}
+	for i, m := range params.Messages {
+		for _, c := range m.Content {
+			name := fmt.Sprintf("%s %d: %s", m.Role, i+1, c.Type)
+			switch c.Type {
+			case MessageContentTypeText:
+				if c.Text == " " { // placeholder of an empty block
+					continue
+				}
+			case MessageContentTypeToolUse:
+				name += " " + c.Name
+			case MessageContentTypeImage, MessageContentTypeDocument:
+				if c.Source != nil {
+					name += " " + c.Source.MediaType
+				}
+			}
+			blocks = append(blocks, blockTokens{name, contentTokens(model, c)})
+		}
+	}
+	return blocks
+}
+
+// tokenWarnings returns warnings if the request exceeds the context window or the
+// output limit of the model.
+func tokenWarnings(model ModelInfo, input int, params *AnthropicClaudeMessagesInferenceParameters) []string {
+	var warnings []string
+	if model.ContextWindow > 0 {
+		switch {
+		case input > model.ContextWindow:
+			warnings = append(warnings, fmt.Sprintf("the input of %d tokens exceeds the context window of %d tokens", input, model.ContextWindow))
+		case input+params.MaxTokens > model.ContextWindow:
+			warnings = append(warnings, fmt.Sprintf("the input of %d tokens plus max_tokens %d exceeds the context window of %d tokens, the response may be cut off", input, params.MaxTokens, model.ContextWindow))
+		}
+	}
+	if model.MaxOutputTokens > 0 && params.MaxTokens > model.MaxOutputTokens {
+		warnings = append(warnings, fmt.Sprintf("max_tokens %d (including thinking) exceeds the output limit of %d tokens", params.MaxTokens, model.MaxOutputTokens))
+	}
+	if t := params.Thinking; t != nil && t.BudgetTokens >= params.MaxTokens {
+		warnings = append(warnings, fmt.Sprintf("the thinking budget of %d tokens leaves no room for the response, max_tokens %d must be larger", t.BudgetTokens, params.MaxTokens))
+	}
+	return warnings
+}
+
+// estimateImageTokens estimates the tokens of an image from its size, one token
+// per 750 pixels after scaling it down to the largest size the model uses.
+func estimateImageTokens(c Content) int {
+	if c.Source == nil {
+		return imageTokens
+	}
+	data, err := base64.StdEncoding.DecodeString(c.Source.Data)
+	if err != nil {
+		return imageTokens
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || cfg.Width == 0 || cfg.Height == 0 {
+		return imageTokens
+	}
+	w, h := float64(cfg.Width), float64(cfg.Height)
+	scale := math.Min(1, math.Min(imageMaxEdge/max(w, h), math.Sqrt(imageMaxPixels/(w*h))))
+	return int(math.Ceil(w * h * scale * scale / imagePixelsPerToken))
+}
+
+// estimateDocumentTokens estimates the tokens of a PDF from its pages; from its
+// size if it cannot be read, about three bytes per token.
+func estimateDocumentTokens(c Content) int {
+	if c.Source == nil {
+		return 0
+	}
+	if data, err := base64.StdEncoding.DecodeString(c.Source.Data); err == nil {
+		if pages, err := pdfPageCount(data); err == nil {
+			return pages * pdfPageTokens
+		}
+	}
+	return len(c.Source.Data) / 4 // base64, 4 characters per 3 bytes
+}
+
+// printTokenCount prints the estimates per block as a table on stdout and the
+// warnings on stderr.
+func printTokenCount(c tokenCount) {
+	rows := make([][]string, 0, len(c.Blocks)+2)
+	for _, block := range c.Blocks {
+		rows = append(rows, []string{block.Name, strconv.Itoa(block.Tokens)})
+	}
+	rows = append(rows, []string{"total (estimate)", strconv.Itoa(c.Estimate)})
+	if c.Counted > 0 {
+		rows = append(rows, []string{"total (counted)", strconv.Itoa(c.Counted)})
+	}
+	printTable([]string{"BLOCK", "TOKENS"}, rows)
+
+	s := stderrStyles()
+	output := fmt.Sprintf("max_tokens %d", c.MaxTokens)
+	if c.Thinking != "" {
+		output += ", thinking " + c.Thinking
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "\n%s\n", s.ConversationList.Render(s.Comment.Render(shortModelName(c.ModelID)+": "+output)))
+	if c.CountErr != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "%s\n", s.ConversationList.Render(s.Comment.Render("tokens estimated locally, the API could not count them: "+c.CountErr.Error())))
+	}
+	for _, w := range c.Warnings {
+		_, _ = fmt.Fprintf(os.Stderr, "%s\n", s.ErrPadding.Render(s.ErrorHeader.SetString("WARNING").String(), w))
+	}
+}
+
+func (i *invokeBackend) CountTokens(ctx context.Context, request modelRequest) (int, error) {
+	body, err := json.Marshal(request.Params)
+	if err != nil {
+		return 0, err
+	}
+	return countTokens(ctx, i.client, request.ModelID, &types.CountTokensInputMemberInvokeModel{Value: types.InvokeModelTokensRequest{Body: body}})
+}
+
+func (c *converseBackend) CountTokens(ctx context.Context, request modelRequest) (int, error) {
+	input, err := converseStreamInput(request)
+	if err != nil {
+		return 0, err
+	}
+	return countTokens(ctx, c.client, request.ModelID, &types.CountTokensInputMemberConverse{Value: types.ConverseTokensRequest{
+		Messages:                     input.Messages,
+		System:                       input.System,
+		ToolConfig:                   input.ToolConfig,
+		AdditionalModelRequestFields: input.AdditionalModelRequestFields,
+	}})
+}
+
+// countTokens counts with the CountTokens API, which takes the id of the model,
+// not of an inference profile.
+func countTokens(ctx context.Context, client *bedrockruntime.Client, modelID string, input types.CountTokensInput) (int, error) {
+	output, err := client.CountTokens(ctx, &bedrockruntime.CountTokensInput{
+		ModelId: aws.String(normalizeToModelID(modelID)),
+		Input:   input,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(aws.ToInt32(output.InputTokens)), nil
+}
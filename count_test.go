@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestTokens(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, 3000, 1500))))
+	img := imgToMessageContent(buf.Bytes(), MessageContentTypeMediaTypePNG)
+
+	params := &AnthropicClaudeMessagesInferenceParameters{
+		System: SystemPrompt{Text: "Be brief."},
+		Tools:  []any{ToolDefinition{Name: BashToolName}},
+		Messages: []Message{{Role: MessageRoleUser, Content: []Content{
+			img,
+			{Type: MessageContentTypeText, Text: " "},
+			{Type: MessageContentTypeText, Text: strings.Repeat("x", 400)},
+		}}},
+	}
+	blocks := requestTokens(lookupModel("anthropic.claude-sonnet-4-6"), params)
+	require.Len(t, blocks, 4) // without the empty placeholder block
+	assert.Equal(t, blockTokens{"system", 3}, blocks[0])
+	assert.Equal(t, "tool bash", blocks[1].Name)
+	assert.Equal(t, blockTokens{"user 1: image image/png", 1534}, blocks[2]) // scaled down to 1.15 megapixels
+	assert.Equal(t, blockTokens{"user 1: text", 100}, blocks[3])
+
+	assert.Equal(t, 134, lookupModel("anthropic.claude-opus-4-8").EstimateTokens(strings.Repeat("x", 400)), "tokenizer with more tokens")
+}
+
+func TestTokenWarnings(t *testing.T) {
+	model := lookupModel("anthropic.claude-sonnet-4-5-20250929-v1:0") // 200k context, 64k output
+	params := &AnthropicClaudeMessagesInferenceParameters{MaxTokens: 8000}
+	assert.Empty(t, tokenWarnings(model, 150_000, params))
+
+	warnings := tokenWarnings(model, 195_000, params)
+	require.Len(t, warnings, 1)
+	assert.Contains(t, warnings[0], "plus max_tokens 8000 exceeds the context window")
+
+	params = &AnthropicClaudeMessagesInferenceParameters{MaxTokens: 70_000, Thinking: &ThinkingConfig{Type: "enabled", BudgetTokens: 80_000}}
+	warnings = tokenWarnings(model, 250_000, params)
+	require.Len(t, warnings, 3)
+	assert.Contains(t, warnings[0], "input of 250000 tokens exceeds the context window of 200000 tokens")
+	assert.Contains(t, warnings[1], "exceeds the output limit of 64000 tokens")
+	assert.Contains(t, warnings[2], "thinking budget")
+}
+
+func TestEstimateDocumentTokens(t *testing.T) {
+	assert.Equal(t, 250, estimateDocumentTokens(Content{Type: MessageContentTypeDocument, Source: &Source{Data: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("x"), 750))}}), "not a PDF, by size")
+	assert.Zero(t, estimateDocumentTokens(Content{Type: MessageContentTypeDocument}))
+}
+
+func TestAnthropicCountTokens(t *testing.T) {
+	var path string
+	var body map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		data, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(data, &body)
+		fmt.Fprint(w, `{"input_tokens":42}`)
+	}))
+	defer server.Close()
+
+	t.Setenv(anthropicAPIKeyEnv, "secret")
+	t.Setenv(anthropicBaseURLEnv, server.URL)
+	b, err := newBackend(providerAnthropic, "", nil)
+	require.NoError(t, err)
+
+	params := NewAnthropicClaudeMessagesInferenceParameters()
+	params.System = SystemPrompt{Text: "Be brief."}
+	params.Messages = []Message{{Role: MessageRoleUser, Content: []Content{{Type: MessageContentTypeText, Text: "Hello"}}}}
+	n, err := b.(tokenCounter).CountTokens(context.Background(), modelRequest{ModelID: "anthropic.claude-opus-4-8", Params: params})
+	require.NoError(t, err)
+	assert.Equal(t, 42, n)
+	assert.Equal(t, "/v1/messages/count_tokens", path)
+	assert.Equal(t, "claude-opus-4-8", body["model"])
+	assert.Equal(t, "Be brief.", body["system"])
+	assert.NotContains(t, body, "max_tokens")
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// exitCodeGuardrailBlocked is the exit code when a guardrail blocked the request
+// or the response, to tell it apart from other errors (exit code 1).
+const exitCodeGuardrailBlocked = 3
+
+const guardrailActionIntervened = "INTERVENED" // amazon-bedrock-guardrailAction
+
+var guardrailVersion = regexp.MustCompile(`^(DRAFT|[0-9]+)$`)
+
+// guardrailConfig is a Bedrock guardrail applied to model requests, see --guardrail.
+type guardrailConfig struct {
+	ID      string // id or ARN of the guardrail
+	Version string // e.g. 1 or DRAFT
+}
+
+func (g *guardrailConfig) String() string {
+	if g == nil {
+		return ""
+	}
+	return g.ID + ":" + g.Version
+}
+
+// parseGuardrail parses a guardrail in the form id:version, e.g. gr4bc1d2e3:1 or
+// arn:aws:bedrock:us-east-1:111122223333:guardrail/gr4bc1d2e3:DRAFT. It returns
+// nil for an empty string.
+func parseGuardrail(s string) (*guardrailConfig, error) {
+	if s == "" {
+		return nil, nil
+	}
+	i := strings.LastIndex(s, ":")
+	if i <= 0 || !guardrailVersion.MatchString(s[i+1:]) {
+		return nil, fmt.Errorf("invalid guardrail '%s', expected id:version, e.g. gr4bc1d2e3:1 or gr4bc1d2e3:DRAFT", s)
+	}
+	return &guardrailConfig{ID: s[:i], Version: s[i+1:]}, nil
+}
+
+// guardrailIntervention is a guardrail intervention reported in the response stream.
+type guardrailIntervention struct {
+	Guardrail   string   `json:"guardrail"`   // id:version
+	Assessments []string `json:"assessments"` // e.g. "input: denied topic Investments (BLOCKED)"
+	Blocked     bool     `json:"blocked"`     // the request or the response was blocked, not only masked
+}
+
+func (g *guardrailIntervention) Error() string {
+	if len(g.Assessments) == 0 {
+		return fmt.Sprintf("guardrail %s intervened", g.Guardrail)
+	}
+	return fmt.Sprintf("guardrail %s intervened: %s", g.Guardrail, strings.Join(g.Assessments, "; "))
+}
+
+// reason returns the reason shown with the ErrorHeader style.
+func (g *guardrailIntervention) reason() string {
+	if g.Blocked {
+		return "A guardrail blocked the request."
+	}
+	return "A guardrail intervened."
+}
+
+// newGuardrailIntervention summarizes the guardrail trace of a response. Traces of
+// InvokeModel (amazon-bedrock-trace) and ConverseStream (converted to JSON, so with
+// Go field names) are both supported; without details the request counts as blocked.
+func newGuardrailIntervention(guardrail string, trace json.RawMessage) *guardrailIntervention {
+	g := &guardrailIntervention{Guardrail: guardrail}
+	var v any
+	if len(trace) > 0 && json.Unmarshal(trace, &v) == nil {
+		collectGuardrailAssessments(v, "", "", g)
+	}
+	if len(g.Assessments) == 0 {
+		g.Blocked = true
+	}
+	slices.Sort(g.Assessments)
+	g.Assessments = slices.Compact(g.Assessments)
+	return g
+}
+
+// guardrailPolicies are the names of the guardrail policies in a trace.
+var guardrailPolicies = map[string]string{
+	"topicpolicy":                "denied topic",
+	"contentpolicy":              "content filter",
+	"wordpolicy":                 "word filter",
+	"sensitiveinformationpolicy": "sensitive information",
+	"contextualgroundingpolicy":  "contextual grounding",
+	"automatedreasoningpolicy":   "automated reasoning",
+}
+
+// collectGuardrailAssessments walks the trace and adds every assessment with an
+// action other than NONE; source is input or output and policy the enclosing policy.
+func collectGuardrailAssessments(v any, source, policy string, g *guardrailIntervention) {
+	switch v := v.(type) {
+	case []any:
+		for _, e := range v {
+			collectGuardrailAssessments(e, source, policy, g)
+		}
+	case map[string]any:
+		fields := make(map[string]string)
+		for key, value := range v {
+			if s, ok := value.(string); ok {
+				fields[strings.ToLower(key)] = s
+			}
+		}
+		action := fields["action"]
+		name := cmp.Or(fields["name"], fields["type"], fields["match"]) // e.g. topic name, content filter type or PII type
+		if action != "" && action != "NONE" && policy != "" {
+			assessment := strings.TrimSpace(fmt.Sprintf("%s %s (%s)", policy, name, action))
+			if source != "" {
+				assessment = source + ": " + assessment
+			}
+			g.Assessments = append(g.Assessments, assessment)
+			if action == "BLOCKED" {
+				g.Blocked = true
+			}
+		}
+		for key, value := range v {
+			k := strings.ToLower(key)
+			switch {
+			case k == "input" || k == "inputassessment":
+				collectGuardrailAssessments(value, "input", policy, g)
+			case k == "outputs" || k == "outputassessments":
+				collectGuardrailAssessments(value, "output", policy, g)
+			case guardrailPolicies[k] != "":
+				collectGuardrailAssessments(value, source, guardrailPolicies[k], g)
+			default:
+				collectGuardrailAssessments(value, source, policy, g)
+			}
+		}
+	}
+}
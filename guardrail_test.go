@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseGuardrail(t *testing.T) {
+	g, err := parseGuardrail("arn:aws:bedrock:us-east-1:111122223333:guardrail/gr4bc1d2e3:DRAFT")
+	require.NoError(t, err)
+	assert.Equal(t, "arn:aws:bedrock:us-east-1:111122223333:guardrail/gr4bc1d2e3", g.ID)
+	assert.Equal(t, "DRAFT", g.Version)
+
+	g, err = parseGuardrail("gr4bc1d2e3:12")
+	require.NoError(t, err)
+	assert.Equal(t, "gr4bc1d2e3:12", g.String())
+
+	g, err = parseGuardrail("")
+	assert.NoError(t, err)
+	assert.Nil(t, g)
+
+	for _, s := range []string{"gr4bc1d2e3", ":1", "gr4bc1d2e3:latest"} {
+		_, err = parseGuardrail(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestGuardrailIntervention(t *testing.T) {
+	// amazon-bedrock-trace of InvokeModel
+	trace := `{"guardrail":{"input":{"gr4bc1d2e3":{
+		"topicPolicy":{"topics":[{"name":"Investments","type":"DENY","action":"BLOCKED"}]},
+		"contentPolicy":{"filters":[{"type":"VIOLENCE","confidence":"LOW","action":"NONE"}]}}},
+		"outputs":[{"gr4bc1d2e3":{"sensitiveInformationPolicy":{"piiEntities":[{"type":"EMAIL","match":"a@b.c","action":"ANONYMIZED"}]}}}]}}`
+	g := newGuardrailIntervention("gr4bc1d2e3:1", json.RawMessage(trace))
+	assert.True(t, g.Blocked)
+	assert.Equal(t, []string{"input: denied topic Investments (BLOCKED)", "output: sensitive information EMAIL (ANONYMIZED)"}, g.Assessments)
+	assert.Equal(t, "A guardrail blocked the request.", g.reason())
+
+	// trace of ConverseStream, marshaled with the Go field names of the SDK
+	data, err := json.Marshal(types.ConverseStreamTrace{Guardrail: &types.GuardrailTraceAssessment{
+		OutputAssessments: map[string][]types.GuardrailAssessment{"gr4bc1d2e3": {{
+			WordPolicy: &types.GuardrailWordPolicyAssessment{CustomWords: []types.GuardrailCustomWord{
+				{Match: aws.String("secret"), Action: types.GuardrailWordPolicyActionBlocked},
+			}},
+		}}},
+	}})
+	require.NoError(t, err)
+	g = newGuardrailIntervention("gr4bc1d2e3:1", data)
+	assert.True(t, g.Blocked)
+	assert.Equal(t, []string{"output: word filter secret (BLOCKED)"}, g.Assessments)
+
+	g = newGuardrailIntervention("gr4bc1d2e3:1", json.RawMessage(`{"guardrail":{"input":{"gr4bc1d2e3":{
+		"sensitiveInformationPolicy":{"regexes":[{"name":"account","match":"12345","action":"ANONYMIZED"}]}}}}}`))
+	assert.False(t, g.Blocked)
+	assert.Equal(t, "guardrail gr4bc1d2e3:1 intervened: input: sensitive information account (ANONYMIZED)", g.Error())
+
+	g = newGuardrailIntervention("gr4bc1d2e3:1", nil) // no trace
+	assert.True(t, g.Blocked)
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+	"github.com/aws/smithy-go"
+)
+
+const (
+	defaultRetries = 5                // retries of an invocation, see --retries
+	retryBaseDelay = 2 * time.Second  // delay before the first retry, doubled for every further retry
+	retryMaxDelay  = 60 * time.Second // maximum delay between two attempts, without jitter
+)
+
+// retryableErrorCodes are the error codes of Bedrock errors that are worth a retry,
+// in lower case; errors of the event stream start in lower case, e.g.
+// throttlingException, those of the request in upper case.
+var retryableErrorCodes = []string{
+	"throttlingexception",
+	"serviceunavailableexception",
+	"modelnotreadyexception",
+	"modelstreamerrorexception",
+	"internalserverexception",
+}
+
+// invoker invokes models through a backend. Requests that fail because the model is
+// throttled, unavailable or not ready are retried with exponential backoff and
+// jitter, as are responses that fail with such an error event. A response that fails
+// after its first content is invoked again from the start; a retry event tells the
+// receiver to discard the partial response.
+type invoker struct {
+	backend   backend
+	retries   int                  // retries after the first attempt
+	timeout   time.Duration        // deadline of an invocation including retries and the response, 0 for none
+	baseDelay time.Duration        // delay before the first retry
+	progress  func(message string) // reports retries, e.g. on stderr; nil for none
+}
+
+// newInvoker returns an invoker with the --retries and --timeout of the config that
+// reports retries on stderr.
+func newInvoker(backend backend, cfg *Config) *invoker {
+	return &invoker{
+		backend:   backend,
+		retries:   max(cfg.Retries, 0),
+		timeout:   cfg.Timeout,
+		baseDelay: retryBaseDelay,
+		progress:  printRetryProgress,
+	}
+}
+
+// retryProgress is a tea.Msg with a retry of the invocation, printed above the
+// output or shown in the status line of the chat.
+type retryProgress string
+
+// printRetryProgress prints a retry on stderr; while the Bubble Tea program is
+// running, it is passed to the program as the output is rendered there.
+func printRetryProgress(message string) {
+	if program != nil {
+		program.Send(retryProgress(message))
+		return
+	}
+	_, _ = fmt.Fprintln(os.Stderr, stderrStyles().Comment.Render(message))
+}
+
+// Stream invokes the model and returns the response stream and the start of the
+// successful attempt.
+func (i *invoker) Stream(ctx context.Context, request modelRequest) (responseStream, time.Time, error) {
+	var cancel context.CancelFunc
+	if i.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, i.timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+
+	stream, held, start, attempts, err := i.open(ctx, request, 0, nil)
+	if err != nil {
+		cancel()
+		return nil, time.Time{}, err
+	}
+	return i.forward(ctx, cancel, request, stream, held, attempts), start, nil
+}
+
+// open invokes the model until a response starts with content and returns its
+// stream, the events held by firstContent, its start and the number of attempts
+// including it. attempt is the number of attempts so far and err the error of the
+// last one, nil for none.
+func (i *invoker) open(ctx context.Context, request modelRequest, attempt int, err error) (responseStream, []AnthropicClaudeMessagesResponse, time.Time, int, error) {
+	for ; ; attempt++ {
+		if err != nil {
+			err = i.deadlineError(ctx, err)
+			logger.Printf("invocation attempt %d failed: %v\n", attempt, err)
+
+			if !i.retryable(attempt, err) {
+				if attempt > 1 {
+					err = fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+				}
+				return nil, nil, time.Time{}, attempt, err
+			}
+
+			delay := i.delay(attempt - 1)
+			if i.progress != nil {
+				i.progress(fmt.Sprintf("%s, retrying in %s (%d/%d)", errorCode(err), delay.Round(100*time.Millisecond), attempt, i.retries))
+			}
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return nil, nil, time.Time{}, attempt, i.deadlineError(ctx, fmt.Errorf("%w, last attempt failed with: %w", ctx.Err(), err))
+			}
+		}
+
+		start := time.Now()
+		var stream responseStream
+		stream, err = i.backend.Stream(ctx, request)
+		if err == nil {
+			var held []AnthropicClaudeMessagesResponse
+			held, err = firstContent(stream)
+			if err == nil {
+				return stream, held, start, attempt + 1, nil
+			}
+			_ = stream.Close()
+		}
+	}
+}
+
+// retryable reports whether another attempt follows the given number of attempts
+// that ended with err.
+func (i *invoker) retryable(attempts int, err error) bool {
+	return attempts <= i.retries && retryableError(err)
+}
+
+// delay returns the exponential backoff with jitter before the given retry, starting
+// at 0.
+func (i *invoker) delay(retry int) time.Duration {
+	delay := i.baseDelay << min(retry, 16)
+	if delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	jitter := time.Duration(rand.Int63n(int64(i.baseDelay)/2 + 1)) // #nosec G404 - weak random is acceptable for jitter
+	return delay + jitter
+}
+
+// deadlineError explains an error caused by the --timeout of the invocation.
+func (i *invoker) deadlineError(ctx context.Context, err error) error {
+	if i.timeout > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) && !errors.Is(err, errInvocationTimeout) {
+		return fmt.Errorf("%w of %s: %w", errInvocationTimeout, i.timeout, err)
+	}
+	return err
+}
+
+var errInvocationTimeout = errors.New("no response within the timeout")
+
+// firstContent reads the events of the stream up to the first event with content.
+// The events before it, like message_start, are returned to be passed on, so a
+// retried response does not start twice.
+func firstContent(stream responseStream) ([]AnthropicClaudeMessagesResponse, error) {
+	var held []AnthropicClaudeMessagesResponse
+	for event := range stream.Events() {
+		held = append(held, event)
+		if event.Type != EventMessageStart.String() && event.Type != EventPing.String() {
+			return held, nil
+		}
+	}
+	return held, stream.Err() // ended before any content
+}
+
+// forward returns a stream of the held events followed by the remaining events of
+// the stream. If the stream fails with a retryable error, a retry event is passed on
+// and the model invoked again; the context of the invocation is canceled at its end.
+func (i *invoker) forward(ctx context.Context, cancel context.CancelFunc, request modelRequest, stream responseStream, held []AnthropicClaudeMessagesResponse, attempts int) responseStream {
+	var mu sync.Mutex // guards stream, which is replaced by a retry
+	s := newEventStream(func() error {
+		defer cancel()
+		mu.Lock()
+		defer mu.Unlock()
+		return stream.Close()
+	})
+	go func() {
+		defer close(s.events)
+		current := stream
+		for {
+			for _, event := range held {
+				if !s.send(event) {
+					return
+				}
+			}
+			for event := range current.Events() {
+				if !s.send(event) {
+					return
+				}
+			}
+			err := current.Err()
+			if err == nil {
+				return
+			}
+			err = i.deadlineError(ctx, err)
+			if !i.retryable(attempts, err) {
+				if attempts > 1 {
+					err = fmt.Errorf("giving up after %d attempts: %w", attempts, err)
+				}
+				s.err = err
+				return
+			}
+
+			_ = current.Close()
+			if !s.send(AnthropicClaudeMessagesResponse{Type: EventRetry.String()}) {
+				return
+			}
+			var next responseStream
+			next, held, _, attempts, err = i.open(ctx, request, attempts, err)
+			if err != nil {
+				s.err = err
+				return
+			}
+			mu.Lock()
+			stream, current = next, next
+			mu.Unlock()
+		}
+	}()
+	return s
+}
+
+// retryableError reports whether an invocation that failed with err can be retried:
+// the model is throttled, unavailable or not ready, or the Anthropic API is rate
+// limited or overloaded.
+func retryableError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var (
+		throttling  *types.ThrottlingException
+		unavailable *types.ServiceUnavailableException
+		notReady    *types.ModelNotReadyException
+		streamError *types.ModelStreamErrorException
+		internal    *types.InternalServerException
+	)
+	if errors.As(err, &throttling) || errors.As(err, &unavailable) || errors.As(err, &notReady) ||
+		errors.As(err, &streamError) || errors.As(err, &internal) {
+		return true
+	}
+	var apiErr smithy.APIError // e.g. an exception of the event stream without a type of its own
+	if errors.As(err, &apiErr) {
+		return slices.Contains(retryableErrorCodes, strings.ToLower(apiErr.ErrorCode()))
+	}
+	var anthropicErr *anthropicAPIError
+	return errors.As(err, &anthropicErr) && (anthropicErr.Throttled() || anthropicErr.StatusCode >= 500)
+}
+
+// errorCode returns a short name of the error for progress messages, e.g.
+// ThrottlingException or overloaded_error.
+func errorCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	var anthropicErr *anthropicAPIError
+	if errors.As(err, &anthropicErr) {
+		return anthropicErr.Type
+	}
+	return err.Error()
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+	"github.com/aws/smithy-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scriptedAttempt is the outcome of one invocation of a scriptedBackend: an error of
+// the request, or the events of the response followed by an error of the stream.
+type scriptedAttempt struct {
+	err       error
+	events    []string // event types
+	streamErr error
+	wait      time.Duration // before the response
+}
+
+type scriptedBackend struct {
+	attempts []scriptedAttempt
+	calls    int
+}
+
+func (s *scriptedBackend) Stream(ctx context.Context, _ modelRequest) (responseStream, error) {
+	attempt := s.attempts[min(s.calls, len(s.attempts)-1)]
+	s.calls++
+	select {
+	case <-time.After(attempt.wait):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if attempt.err != nil {
+		return nil, attempt.err
+	}
+	stream := newEventStream(func() error { return nil })
+	go func() {
+		defer close(stream.events)
+		for _, event := range attempt.events {
+			if !stream.send(AnthropicClaudeMessagesResponse{Type: event}) {
+				return
+			}
+		}
+		stream.err = attempt.streamErr
+	}()
+	return stream, nil
+}
+
+func receiveAll(stream responseStream) []string {
+	var events []string
+	for event := range stream.Events() {
+		events = append(events, event.Type)
+	}
+	_ = stream.Close()
+	return events
+}
+
+func TestInvokerRetries(t *testing.T) {
+	response := []string{"message_start", "content_block_start", "content_block_delta", "message_stop"}
+	throttled := &types.ThrottlingException{Message: new("Too many requests")}
+
+	tests := []struct {
+		name     string
+		attempts []scriptedAttempt
+		retries  int
+		calls    int
+		progress int
+		err      string
+		events   []string
+	}{
+		{
+			name:     "throttled request",
+			attempts: []scriptedAttempt{{err: throttled}, {err: &types.ServiceUnavailableException{}}, {events: response}},
+			retries:  5, calls: 3, progress: 2, events: response,
+		},
+		{
+			name:     "not retryable",
+			attempts: []scriptedAttempt{{err: &types.ValidationException{Message: new("invalid")}}},
+			retries:  5, calls: 1, err: "invalid",
+		},
+		{
+			name:     "retries exhausted",
+			attempts: []scriptedAttempt{{err: &types.ModelNotReadyException{}}},
+			retries:  2, calls: 3, progress: 2, err: "giving up after 3 attempts",
+		},
+		{
+			name: "error event before content",
+			attempts: []scriptedAttempt{
+				{events: []string{"message_start"}, streamErr: &smithy.GenericAPIError{Code: "throttlingException"}},
+				{events: response},
+			},
+			retries: 5, calls: 2, progress: 1, events: response,
+		},
+		{
+			name: "error event after content",
+			attempts: []scriptedAttempt{
+				{events: response[:3], streamErr: &types.ModelStreamErrorException{Message: new("stream broke")}},
+				{events: response},
+			},
+			retries: 5, calls: 2, progress: 1, events: append(append(slices.Clone(response[:3]), "retry"), response...),
+		},
+		{
+			name: "error event after content, retries exhausted",
+			attempts: []scriptedAttempt{
+				{events: response[:3], streamErr: &types.ModelStreamErrorException{Message: new("stream broke")}},
+			},
+			retries: 1, calls: 2, progress: 1, events: []string{"message_start", "content_block_start", "content_block_delta", "retry", "message_start", "content_block_start", "content_block_delta"},
+			err: "giving up after 2 attempts",
+		},
+		{
+			name: "error event after content not retryable",
+			attempts: []scriptedAttempt{
+				{events: response[:3], streamErr: &smithy.GenericAPIError{Code: "validationException", Message: "stream broke"}},
+			},
+			retries: 5, calls: 1, events: response[:3], err: "stream broke",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &scriptedBackend{attempts: tt.attempts}
+			var progress []string
+			i := &invoker{backend: backend, retries: tt.retries, baseDelay: time.Millisecond,
+				progress: func(message string) { progress = append(progress, message) }}
+
+			stream, _, err := i.Stream(context.Background(), modelRequest{})
+			if err == nil {
+				assert.Equal(t, tt.events, receiveAll(stream))
+				err = stream.Err()
+			}
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.err)
+			}
+			assert.Equal(t, tt.calls, backend.calls)
+			assert.Len(t, progress, tt.progress)
+		})
+	}
+}
+
+func TestInvokerTimeout(t *testing.T) {
+	backend := &scriptedBackend{attempts: []scriptedAttempt{{err: &types.ThrottlingException{}}, {wait: time.Minute}}}
+	i := &invoker{backend: backend, retries: 5, timeout: 50 * time.Millisecond, baseDelay: time.Millisecond}
+	_, _, err := i.Stream(context.Background(), modelRequest{})
+	require.ErrorIs(t, err, errInvocationTimeout)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 2, backend.calls)
+}
+
+func TestRetryableError(t *testing.T) {
+	for err, retryable := range map[error]bool{
+		&types.ThrottlingException{}:                                        true,
+		fmt.Errorf("operation error: %w", &types.InternalServerException{}): true,
+		&smithy.GenericAPIError{Code: "modelStreamErrorException"}:          true,
+		&smithy.GenericAPIError{Code: "AccessDeniedException"}:              false,
+		&anthropicAPIError{Type: "overloaded_error", StatusCode: 529}:       true,
+		&anthropicAPIError{Type: "api_error", StatusCode: 500}:              true,
+		&anthropicAPIError{Type: "invalid_request_error", StatusCode: 400}:  false,
+		errors.New("ThrottlingException"):                                   false,
+		context.Canceled:                                                    false,
+	} {
+		assert.Equal(t, retryable, retryableError(err), err.Error())
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"crypto/sha1" // #nosec G505 - only used to derive session ids, not for security
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tidwall/buntdb"
+)
+
+const editKeyPrefix = "edit:"
+
+var errNoEdits = errors.New("no recorded edits found")
+
+// Edit is a file change made by the text editor tool as recorded in the edit
+// journal, so it can be listed with 'bods edits' and rolled back with 'bods undo'.
+type Edit struct {
+	Session        string    `json:"session"`                   // id of the bods run that made the edit
+	Seq            int       `json:"seq"`                       // order of the edit within the session
+	ConversationID string    `json:"conversation_id,omitempty"` // set once the conversation is saved
+	Command        string    `json:"command"`                   // e.g. str_replace
+	Path           string    `json:"path"`
+	Before         string    `json:"before"`
+	After          string    `json:"after"`
+	Created        bool      `json:"created"` // the file did not exist before the edit
+	Time           time.Time `json:"time"`
+	Undone         bool      `json:"undone,omitempty"` // rolled back with 'bods undo'
+}
+
+func (e *Edit) key() string {
+	return fmt.Sprintf("%s%s:%06d", editKeyPrefix, e.Session, e.Seq)
+}
+
+// EditSession holds the edits of one bods run.
+type EditSession struct {
+	ID             string
+	ConversationID string
+	Edits          []Edit // in the order they were made
+}
+
+// Time returns when the last edit of the session was made.
+func (s *EditSession) Time() time.Time {
+	return s.Edits[len(s.Edits)-1].Time
+}
+
+// Pending returns the edits of the session that were not undone.
+func (s *EditSession) Pending() []Edit {
+	var pending []Edit
+	for _, e := range s.Edits {
+		if !e.Undone {
+			pending = append(pending, e)
+		}
+	}
+	return pending
+}
+
+// editSessionID identifies the edits of this bods run in the edit journal.
+var editSessionID = func() string {
+	h := sha1.New() // #nosec G401
+	_, _ = fmt.Fprintf(h, "%d:%d", time.Now().UnixNano(), os.Getpid())
+	return hex.EncodeToString(h.Sum(nil))
+}()
+
+// EditJournal records the file edits of the text editor tool of a bods run.
+type EditJournal struct {
+	session string
+	cfg     *Config // the edits belong to the conversation cfg.ConversationID, if already saved
+	seq     int
+}
+
+func NewEditJournal(cfg *Config) *EditJournal {
+	return &EditJournal{session: editSessionID, cfg: cfg}
+}
+
+// Record stores an edit of path; failing to do so does not fail the edit.
+func (j *EditJournal) Record(command EditorCommand, path string, before string, after string, created bool) {
+	j.seq++
+	e := &Edit{
+		Session:        j.session,
+		Seq:            j.seq,
+		ConversationID: j.cfg.ConversationID,
+		Command:        string(command),
+		Path:           path,
+		Before:         before,
+		After:          after,
+		Created:        created,
+		Time:           time.Now(),
+	}
+	if err := saveEdits(*e); err != nil {
+		logger.Printf("could not record edit of %s in edit journal: %v\n", path, err)
+	}
+}
+
+func editsDBFilePath() string {
+	return filepath.Join(filepath.Dir(conversationsDBFilePath()), "edits.db")
+}
+
+func saveEdits(edits ...Edit) error {
+	db, err := buntdb.Open(editsDBFilePath())
+	if err != nil {
+		logger.Println("saveEdits() buntdb.Open - ", err)
+		return err
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *buntdb.Tx) error {
+		for _, e := range edits {
+			value, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			if _, _, err := tx.Set(e.key(), string(value), nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// listEditSessions returns all sessions of the edit journal, most recent first.
+func listEditSessions() ([]EditSession, error) {
+	db, err := buntdb.Open(editsDBFilePath())
+	if err != nil {
+		logger.Println("listEditSessions() buntdb.Open - ", err)
+		return nil, err
+	}
+	defer db.Close()
+
+	var sessions []EditSession
+	err = db.View(func(tx *buntdb.Tx) error {
+		var decodeErr error
+		err := tx.AscendKeys(editKeyPrefix+"*", func(key, value string) bool {
+			var e Edit
+			if decodeErr = json.Unmarshal([]byte(value), &e); decodeErr != nil {
+				decodeErr = fmt.Errorf("could not decode %s: %w", key, decodeErr)
+				return false
+			}
+			if n := len(sessions); n > 0 && sessions[n-1].ID == e.Session { // keys are ordered by session and seq
+				sessions[n-1].Edits = append(sessions[n-1].Edits, e)
+			} else {
+				sessions = append(sessions, EditSession{ID: e.Session, Edits: []Edit{e}})
+			}
+			if e.ConversationID != "" {
+				sessions[len(sessions)-1].ConversationID = e.ConversationID
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		return decodeErr
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(sessions, func(a, b EditSession) int {
+		return b.Time().Compare(a.Time())
+	})
+
+	return sessions, nil
+}
+
+// findEditSession returns the session with the given id or unambiguous id prefix,
+// or the most recent session if id is empty.
+func findEditSession(id string) (*EditSession, error) {
+	sessions, err := listEditSessions()
+	if err != nil {
+		return nil, err
+	}
+	if len(sessions) == 0 {
+		return nil, errNoEdits
+	}
+	if id == "" {
+		return &sessions[0], nil
+	}
+
+	id = strings.ToLower(strings.TrimSpace(id))
+	var matches []EditSession
+	for _, s := range sessions {
+		if s.ID == id {
+			return &s, nil
+		}
+		if strings.HasPrefix(s.ID, id) {
+			matches = append(matches, s)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no edit session found with id '%s'", id)
+	case 1:
+		return &matches[0], nil
+	default:
+		return nil, fmt.Errorf("edit session id '%s' is ambiguous, it matches %d sessions", id, len(matches))
+	}
+}
+
+// linkEditSession sets the conversation id of the edits of this run which were
+// made before the conversation was saved for the first time.
+func linkEditSession(conversationID string) error {
+	s, err := findEditSession(editSessionID)
+	if err != nil {
+		return nil // no edits made in this run
+	}
+
+	var unlinked []Edit
+	for _, e := range s.Edits {
+		if e.ConversationID == "" {
+			e.ConversationID = conversationID
+			unlinked = append(unlinked, e)
+		}
+	}
+	if len(unlinked) == 0 {
+		return nil
+	}
+	return saveEdits(unlinked...)
+}
+
+// undoEdits rolls back the given edits, which have to be in the order they were
+// made: every file is restored to its content before its first edit, and created
+// files are removed. Files changed since their last edit are only restored with
+// force. The paths of the restored files are returned.
+func undoEdits(edits []Edit, force bool) ([]string, error) {
+	first := make(map[string]Edit) // by path
+	last := make(map[string]Edit)
+	var paths []string
+	for _, e := range edits {
+		if _, ok := first[e.Path]; !ok {
+			first[e.Path] = e
+			paths = append(paths, e.Path)
+		}
+		last[e.Path] = e
+	}
+
+	if !force {
+		var changed []string
+		for _, path := range paths {
+			current, err := os.ReadFile(path)
+			if err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			if string(current) != last[path].After {
+				changed = append(changed, path)
+			}
+		}
+		if len(changed) > 0 {
+			return nil, fmt.Errorf("files were changed after they were edited, use --force to restore them anyway: %s", strings.Join(changed, ", "))
+		}
+	}
+
+	for _, path := range paths {
+		e := first[path]
+		var err error
+		if e.Created {
+			err = os.Remove(path)
+			if os.IsNotExist(err) {
+				err = nil
+			}
+		} else {
+			err = os.WriteFile(path, []byte(e.Before), 0o600)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not restore %s: %w", path, err)
+		}
+		logger.Printf("undoEdits: restored %s (created=%t)\n", path, e.Created)
+	}
+
+	for i := range edits {
+		edits[i].Undone = true
+	}
+	if err := saveEdits(edits...); err != nil {
+		return paths, fmt.Errorf("files were restored, but the edit journal could not be updated: %w", err)
+	}
+
+	return paths, nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	editsSession string
+	undoSession  string
+	undoForce    bool
+
+	editsCmd = &cobra.Command{
+		Use:   "edits",
+		Short: "List the file edits made by the text editor tool, grouped by run",
+		Args:  noPrompt(cobra.NoArgs),
+		RunE: func(_ *cobra.Command, _ []string) error {
+			sessions, err := listEditSessions()
+			if err != nil {
+				return bodsError{err, "Could not read the edit journal."}
+			}
+			if editsSession != "" {
+				s, err := findEditSession(editsSession)
+				if err != nil {
+					return bodsError{err, "Could not load edits."}
+				}
+				sessions = []EditSession{*s}
+			}
+			if len(sessions) == 0 {
+				_, _ = fmt.Fprintln(os.Stderr, "No recorded edits.")
+				return nil
+			}
+
+			s := stdoutStyles()
+			for _, session := range sessions {
+				conversation := ""
+				if session.ConversationID != "" {
+					conversation = "conversation " + session.ConversationID[:7] + ", "
+				}
+				fmt.Println(s.ConversationList.Render(
+					s.Bullet.String() +
+						s.SHA1.Render(session.ID[:7]) + " " +
+						s.Comment.Render(fmt.Sprintf("%s%d edit(s)", conversation, len(session.Edits))) + " " +
+						s.Timeago.Render(timeAgo(session.Time())),
+				))
+				for _, e := range session.Edits {
+					line := fmt.Sprintf("    %-11s %s %s", e.Command, e.Path, s.Comment.Render(diffStat(e.Before, e.After)))
+					if e.Undone {
+						line += " " + s.Timeago.Render("(undone)")
+					}
+					fmt.Println(s.ConversationList.Render(line))
+				}
+			}
+			return nil
+		},
+	}
+
+	undoCmd = &cobra.Command{
+		Use:   "undo [file]...",
+		Short: "Roll back the file edits of a run (default: the last one), or only those of the given files",
+		RunE: func(_ *cobra.Command, args []string) error {
+			session, err := findEditSession(undoSession)
+			if err != nil {
+				return bodsError{err, "Could not load edits."}
+			}
+
+			edits := session.Pending()
+			if len(args) > 0 {
+				edits, err = editsOfFiles(edits, args)
+				if err != nil {
+					return bodsError{err, "Invalid file."}
+				}
+			}
+			if len(edits) == 0 {
+				_, _ = fmt.Fprintf(os.Stderr, "Nothing to undo in run %s.\n", session.ID[:7])
+				return nil
+			}
+
+			restored, err := undoEdits(edits, undoForce)
+			if err != nil {
+				return bodsError{err, "Could not undo edits."}
+			}
+			for _, path := range restored {
+				_, _ = fmt.Fprintf(os.Stderr, "Restored %s\n", path)
+			}
+			_, _ = fmt.Fprintf(os.Stderr, "Undid %d edit(s) of run %s.\n", len(edits), session.ID[:7])
+			return nil
+		},
+	}
+)
+
+func initJournalCommands() {
+	editsCmd.Flags().StringVar(&editsSession, "session", "", "Only list the edits of the run with the given id (or unique id prefix)")
+	_ = editsCmd.RegisterFlagCompletionFunc("session", completeEditSessionIDs)
+
+	undoCmd.Flags().StringVar(&undoSession, "session", "", "Id (or unique id prefix) of the run to undo, see 'bods edits' (default: the last run)")
+	undoCmd.Flags().BoolVar(&undoForce, "force", false, "Restore files even if they were changed after they were edited")
+	_ = undoCmd.RegisterFlagCompletionFunc("session", completeEditSessionIDs)
+
+	rootCmd.AddCommand(editsCmd, undoCmd)
+}
+
+func completeEditSessionIDs(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+	sessions, err := listEditSessions()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	var ids []string
+	for _, s := range sessions {
+		ids = append(ids, fmt.Sprintf("%s\t%d edit(s), %s", s.ID[:7], len(s.Edits), timeAgo(s.Time())))
+	}
+	return ids, cobra.ShellCompDirectiveNoFileComp
+}
+
+// editsOfFiles returns the edits of the given files, which may be relative paths.
+func editsOfFiles(edits []Edit, files []string) ([]Edit, error) {
+	paths := make(map[string]bool)
+	for _, f := range files {
+		abs, err := filepath.Abs(f)
+		if err != nil {
+			return nil, err
+		}
+		paths[abs] = false
+	}
+
+	var selected []Edit
+	for _, e := range edits {
+		if _, ok := paths[e.Path]; ok {
+			selected = append(selected, e)
+			paths[e.Path] = true
+		}
+	}
+
+	var notEdited []string
+	for path, edited := range paths {
+		if !edited {
+			notEdited = append(notEdited, path)
+		}
+	}
+	if len(notEdited) > 0 {
+		return nil, errors.New("no edits to undo for " + strings.Join(notEdited, ", "))
+	}
+	return selected, nil
+}
+
+// diffStat returns the number of added and removed lines of an edit e.g. +3 -1.
+func diffStat(before, after string) string {
+	added, removed := 0, 0
+	for _, line := range strings.Split(unifiedDiff("", before, after), "\n") {
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+		case strings.HasPrefix(line, "+"):
+			added++
+		case strings.HasPrefix(line, "-"):
+			removed++
+		}
+	}
+	return fmt.Sprintf("+%d -%d", added, removed)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEditJournal(t *testing.T) {
+	useTempDataHome(t)
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "main.go")
+	created := filepath.Join(dir, "util.go")
+	require.NoError(t, os.WriteFile(existing, []byte("package main\n"), 0o600))
+
+	_, err := findEditSession("")
+	assert.ErrorIs(t, err, errNoEdits)
+
+	tool := NewTextEditorTool()
+	tool.workspace = &Workspace{roots: []string{dir}}
+	tool.journal = NewEditJournal(&Config{})
+	_, err = tool.ExecuteCommand(StrReplaceCommand, existing, map[string]any{"old_str": "main", "new_str": "util"})
+	require.NoError(t, err)
+	_, err = tool.ExecuteCommand(InsertCommand, existing, map[string]any{"insert_line": float64(1), "new_str": "// edited"})
+	require.NoError(t, err)
+	_, err = tool.ExecuteCommand(CreateCommand, created, map[string]any{"file_text": "package util\n"})
+	require.NoError(t, err)
+
+	require.NoError(t, linkEditSession("abcdef0123"))
+	session, err := findEditSession("")
+	require.NoError(t, err)
+	assert.Equal(t, editSessionID, session.ID)
+	assert.Equal(t, "abcdef0123", session.ConversationID)
+	assert.Len(t, session.Edits, 3)
+	assert.Equal(t, "+2 -1", diffStat(session.Edits[0].Before, session.Edits[1].After))
+
+	// files changed after the edit are only restored with force
+	require.NoError(t, os.WriteFile(created, []byte("package changed\n"), 0o600))
+	_, err = undoEdits(session.Pending(), false)
+	assert.Error(t, err)
+
+	restored, err := undoEdits(session.Pending(), true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{existing, created}, restored)
+
+	content, err := os.ReadFile(existing)
+	require.NoError(t, err)
+	assert.Equal(t, "package main\n", string(content))
+	assert.NoFileExists(t, created)
+
+	session, err = findEditSession(editSessionID[:7])
+	require.NoError(t, err)
+	assert.Empty(t, session.Pending())
+}
+
+func TestUndoOverwrittenFile(t *testing.T) {
+	useTempDataHome(t)
+	path := filepath.Join(t.TempDir(), "notes.md")
+	require.NoError(t, os.WriteFile(path, []byte("my notes\n"), 0o600))
+
+	tool := NewTextEditorTool()
+	tool.workspace = &Workspace{roots: []string{filepath.Dir(path)}}
+	tool.journal = NewEditJournal(&Config{})
+	// validatePath rejects create for existing files, but the file can appear after
+	// the check, e.g. while the edit waits for confirmation
+	_, err := tool.create(path, "replaced\n")
+	require.NoError(t, err)
+
+	session, err := findEditSession("")
+	require.NoError(t, err)
+	require.Len(t, session.Edits, 1)
+	assert.False(t, session.Edits[0].Created)
+	assert.Equal(t, "my notes\n", session.Edits[0].Before)
+
+	_, err = undoEdits(session.Pending(), false)
+	require.NoError(t, err)
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "my notes\n", string(content), "the original file is restored, not removed")
+}
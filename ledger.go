@@ -0,0 +1,190 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tidwall/buntdb"
+)
+
+const ledgerKeyPrefix = "usage:"
+
+// Groupings of the usage report, see bods usage --by
+const (
+	usageByModel  = "model"
+	usageByPrompt = "prompt"
+	usageByDay    = "day"
+)
+
+var usageGroupings = []string{usageByModel, usageByPrompt, usageByDay}
+
+// LedgerEntry is the usage of one model invocation as recorded in the local usage
+// ledger, see bods usage.
+type LedgerEntry struct {
+	Time           time.Time `json:"time"`
+	ModelID        string    `json:"model_id"` // model or inference profile id
+	Prompt         string    `json:"prompt,omitempty"`
+	Provider       string    `json:"provider"`
+	Usage          Usage     `json:"usage"`
+	ThinkingTokens int       `json:"thinking_tokens,omitempty"` // estimated
+	TTFBMillis     int64     `json:"ttfb_ms"`
+	LatencyMillis  int64     `json:"latency_ms"`
+	Cost           *float64  `json:"cost,omitempty"` // estimated in USD; nil if the price of the model is unknown
+	CacheSavings   float64   `json:"cache_savings,omitempty"`
+}
+
+func usageDBFilePath() string {
+	return filepath.Join(filepath.Dir(conversationsDBFilePath()), "usage.db")
+}
+
+// recordUsage appends an entry for every round to the usage ledger.
+func recordUsage(cfg *Config, rounds []roundUsage) error {
+	if len(rounds) == 0 {
+		return nil
+	}
+
+	db, err := buntdb.Open(usageDBFilePath())
+	if err != nil {
+		logger.Println("recordUsage() buntdb.Open - ", err)
+		return err
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *buntdb.Tx) error {
+		for i, r := range rounds {
+			entry := LedgerEntry{
+				Time:           r.start,
+				ModelID:        r.ModelID,
+				Prompt:         cfg.PromptTemplate,
+				Provider:       cfg.Provider,
+				Usage:          r.Usage,
+				ThinkingTokens: r.ThinkingTokens,
+				TTFBMillis:     r.TTFB.Milliseconds(),
+				LatencyMillis:  r.Latency.Milliseconds(),
+				CacheSavings:   r.CacheSavings(),
+			}
+			if cost, ok := r.Cost(); ok {
+				entry.Cost = &cost
+			}
+			value, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			// keys sort by time; the index keeps the rounds of the same instant apart
+			key := fmt.Sprintf("%s%020d:%d", ledgerKeyPrefix, r.start.UnixNano(), i)
+			if _, _, err := tx.Set(key, string(value), nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// readLedger returns the ledger entries since the given time, oldest first.
+func readLedger(since time.Time) ([]LedgerEntry, error) {
+	db, err := buntdb.Open(usageDBFilePath())
+	if err != nil {
+		logger.Println("readLedger() buntdb.Open - ", err)
+		return nil, err
+	}
+	defer db.Close()
+
+	var entries []LedgerEntry
+	err = db.View(func(tx *buntdb.Tx) error {
+		var decodeErr error
+		pivot := fmt.Sprintf("%s%020d", ledgerKeyPrefix, max(since.UnixNano(), 0))
+		err := tx.AscendGreaterOrEqual("", pivot, func(key, value string) bool {
+			if !strings.HasPrefix(key, ledgerKeyPrefix) {
+				return false
+			}
+			var e LedgerEntry
+			if decodeErr = json.Unmarshal([]byte(value), &e); decodeErr != nil {
+				decodeErr = fmt.Errorf("could not decode %s: %w", key, decodeErr)
+				return false
+			}
+			entries = append(entries, e)
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		return decodeErr
+	})
+	return entries, err
+}
+
+// UsageSummary is the usage of a group of ledger entries, e.g. of one model.
+type UsageSummary struct {
+	Key            string  `json:"key"` // model id (without region prefix), prompt name or day (YYYY-MM-DD)
+	Calls          int     `json:"calls"`
+	Usage          Usage   `json:"usage"`
+	ThinkingTokens int     `json:"thinking_tokens"`
+	LatencyMillis  int64   `json:"latency_ms"`
+	Cost           float64 `json:"cost"`          // of the entries with a known price
+	CostUnknown    int     `json:"cost_unknown"`  // calls without a known price
+	CacheSavings   float64 `json:"cache_savings"` // negative if caching did not pay off
+}
+
+// CacheHitRate returns the share of input tokens read from the cache.
+func (s UsageSummary) CacheHitRate() float64 {
+	input := s.Usage.InputTokens + s.Usage.CacheReadInputTokens + s.Usage.CacheWriteInputTokens
+	if input == 0 {
+		return 0
+	}
+	return float64(s.Usage.CacheReadInputTokens) / float64(input)
+}
+
+func (s *UsageSummary) add(e LedgerEntry) {
+	s.Calls++
+	s.Usage.Add(e.Usage)
+	s.ThinkingTokens += e.ThinkingTokens
+	s.LatencyMillis += e.LatencyMillis
+	if e.Cost != nil {
+		s.Cost += *e.Cost
+	} else {
+		s.CostUnknown++
+	}
+	s.CacheSavings += e.CacheSavings
+}
+
+// summarizeUsage groups the entries by model, prompt or day. Days are sorted
+// chronologically, models and prompts by cost, the most expensive first.
+func summarizeUsage(entries []LedgerEntry, by string) ([]UsageSummary, error) {
+	var key func(LedgerEntry) string
+	switch by {
+	case usageByModel:
+		key = func(e LedgerEntry) string { return normalizeToModelID(e.ModelID) } // profiles of a model together
+	case usageByPrompt:
+		key = func(e LedgerEntry) string { return cmp.Or(e.Prompt, "(none)") }
+	case usageByDay:
+		key = func(e LedgerEntry) string { return e.Time.Local().Format(time.DateOnly) }
+	default:
+		return nil, fmt.Errorf("unknown grouping '%s', supported are: %s", by, strings.Join(usageGroupings, ", "))
+	}
+
+	groups := make(map[string]*UsageSummary)
+	for _, e := range entries {
+		k := key(e)
+		if groups[k] == nil {
+			groups[k] = &UsageSummary{Key: k}
+		}
+		groups[k].add(e)
+	}
+
+	summaries := make([]UsageSummary, 0, len(groups))
+	for _, s := range groups {
+		summaries = append(summaries, *s)
+	}
+	slices.SortFunc(summaries, func(a, b UsageSummary) int {
+		if by == usageByDay {
+			return cmp.Compare(a.Key, b.Key)
+		}
+		return cmp.Or(cmp.Compare(b.Cost, a.Cost), cmp.Compare(a.Key, b.Key))
+	})
+	return summaries, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsageLedger(t *testing.T) {
+	useTempDataHome(t)
+
+	now := time.Now()
+	cfg := &Config{PromptTemplate: "summarize", Provider: providerBedrock}
+	require.NoError(t, recordUsage(cfg, []roundUsage{
+		{ModelID: "anthropic.claude-opus-4-8", Usage: Usage{InputTokens: 100, OutputTokens: 10}, start: now.Add(-10 * 24 * time.Hour)},
+	}))
+	require.NoError(t, recordUsage(cfg, []roundUsage{
+		{ModelID: "us.anthropic.claude-sonnet-4-6", Usage: Usage{InputTokens: 100, OutputTokens: 10, CacheReadInputTokens: 300}, start: now.Add(-time.Hour)},
+		{ModelID: "anthropic.claude-unknown", Usage: Usage{InputTokens: 50}, start: now.Add(-time.Hour)}, // same instant, another key
+	}))
+	require.NoError(t, recordUsage(&Config{Provider: providerBedrock}, []roundUsage{
+		{ModelID: "us.anthropic.claude-sonnet-4-6", Usage: Usage{InputTokens: 200, OutputTokens: 20}, start: now},
+	}))
+
+	entries, err := readLedger(now.Add(-7 * 24 * time.Hour))
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, "summarize", entries[0].Prompt)
+	require.NotNil(t, entries[0].Cost)
+	assert.InDelta(t, (100*3+10*15+300*0.3)/1e6, *entries[0].Cost, 1e-12)
+	assert.InDelta(t, 300*(3-0.3)/1e6, entries[0].CacheSavings, 1e-12)
+	assert.Nil(t, entries[1].Cost)
+
+	byModel, err := summarizeUsage(entries, usageByModel)
+	require.NoError(t, err)
+	require.Len(t, byModel, 2)
+	assert.Equal(t, "anthropic.claude-sonnet-4-6", byModel[0].Key) // most expensive first
+	assert.Equal(t, 2, byModel[0].Calls)
+	assert.InDelta(t, 0.5, byModel[0].CacheHitRate(), 1e-9) // 300 of 600 input tokens
+	assert.Equal(t, 1, byModel[1].CostUnknown)
+
+	byPrompt, err := summarizeUsage(entries, usageByPrompt)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"(none)", "summarize"}, []string{byPrompt[0].Key, byPrompt[1].Key})
+
+	byDay, err := summarizeUsage(entries, usageByDay)
+	require.NoError(t, err)
+	assert.Equal(t, now.Format(time.DateOnly), byDay[len(byDay)-1].Key)
+
+	_, err = summarizeUsage(entries, "week")
+	assert.Error(t, err)
+}
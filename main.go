@@ -87,19 +87,32 @@ var (
 	logger *log.Logger
 
 	rootCmd = &cobra.Command{
-		Use:           "bods",
+		Use:           "bods [prompt]",
+		Long:          "Send a prompt to Claude. A prompt that starts with the name of a command, e.g. 'show' or 'count',\nruns the command; quote the prompt or put it after --, e.g. bods -- show me the failing test.",
+		Args:          cobra.ArbitraryArgs, // prompt prefix; without this, args would be treated as unknown subcommands
 		SilenceUsage:  true,
 		SilenceErrors: true,
-		RunE: func(_ *cobra.Command, args []string) error {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			config.Prefix = strings.Join(args, " ")
 			logger.Println("main.go config.Prefix: " + config.Prefix)
 
+			config.Output = strings.ToLower(config.Output)
+			if err := validateOutputFormat(config.Output, config.Chat); err != nil {
+				return bodsError{err, "Invalid output format."}
+			}
+
 			opts := []tea.ProgramOption{
 				// tea.WithOutput(stderrRenderer().Output()),
 				tea.WithOutput(os.Stderr),
 			}
 
-			if !isInputTerminal() {
+			switch {
+			case config.Chat:
+				opts = append(opts, tea.WithAltScreen())
+				if !isInputTerminal() {
+					opts = append(opts, tea.WithInputTTY()) // stdin is piped input, read keys from the terminal
+				}
+			case !isInputTerminal():
 				opts = append(opts, tea.WithInput(nil)) // To disable input entirely pass nil
 			}
 
@@ -205,6 +218,15 @@ var (
 				os.Exit(0)
 			}
 
+			conversation, err := loadConversationToContinue(&config)
+			if err != nil {
+				return bodsError{err, "Could not load conversation to continue."}
+			}
+			if conversation != nil {
+				logger.Printf("continuing conversation id=%s with %d messages\n", conversation.ID, len(conversation.Messages))
+				restoreConversation(&config, conversation, cmd.Flags().Changed("think"))
+			}
+
 			logger.Println("creating new tea program...")
 			program = tea.NewProgram(bods, opts...)
 			logger.Println("running new tea program...")
@@ -214,11 +236,47 @@ var (
 			}
 
 			bods = m.(*Bods)
+			if err := recordUsage(&config, bods.rounds); err != nil { // also of failed runs, the tokens are billed
+				logger.Println("could not record usage:", err)
+			}
 			if bods.Error != nil {
 				return *bods.Error
 			}
 
-			if isOutputTerminal() {
+			if bods.count != nil {
+				printTokenCount(*bods.count)
+				return nil
+			}
+
+			if config.Chat { // saved after every turn
+				if config.ConversationID != "" {
+					_, _ = fmt.Fprintf(os.Stderr, "%s %s\n", stderrStyles().Comment.Render("Conversation saved:"), stderrStyles().SHA1.Render(config.ConversationID[:7]))
+				}
+				return nil
+			}
+
+			saved, err := recordConversation(&config, bods.Usage)
+			if err != nil {
+				logger.Println("could not save conversation:", err)
+			}
+			if saved != nil && isOutputTerminal() {
+				defer func() {
+					_, _ = fmt.Fprintf(os.Stderr, "\n%s %s\n", stderrStyles().Comment.Render("Conversation saved:"), stderrStyles().SHA1.Render(saved.ID[:7]))
+				}()
+			}
+
+			switch {
+			case config.Output == outputJSON:
+				response := newJSONResponse(bods, messages[min(bods.responseIdx, len(messages)):])
+				if saved != nil {
+					response.ConversationID = saved.ID
+				}
+				_ = printJSON(response)
+			case config.Output == outputNDJSON:
+				// the events were written while they were received
+			case bods.structured != nil:
+				fmt.Println(string(bods.structured))
+			case isOutputTerminal():
 				logger.Println("rendering output... isOutputTerminal() == true")
 				switch {
 				case bods.glamOutput != "":
@@ -226,13 +284,25 @@ var (
 				case bods.Output != "":
 					fmt.Print(bods.Output)
 				}
-			} else {
+			default:
 				logger.Printf("rendering output... isOutputTerminal() == false -- bods.Output=%s\n", bods.Output)
 				if bods.Output != "" {
 					fmt.Print(bods.Output)
 				}
 			}
 
+			if config.ShowUsage {
+				_, _ = fmt.Fprint(os.Stderr, "\n"+formatUsage(stderrStyles(), bods.rounds))
+			}
+
+			// the response is e.g. the blocked message configured for the guardrail
+			if intervention := bods.intervention; intervention != nil {
+				if intervention.Blocked {
+					return bodsError{intervention, intervention.reason()}
+				}
+				handleError(bodsError{intervention, intervention.reason()})
+			}
+
 			return nil
 		},
 	}
@@ -252,19 +322,34 @@ func initFlags() {
 		flagXMLTagContent  = "tag-content"
 		flagVariableInput  = "variable-input"
 		flagCrossRegion    = "cross-region-inference"
-		flagThink          = "think"       // enable thinking for Claude 3.7
-		flagBudget         = "budget"      // thinking budget
-		flagTextEditor     = "text-editor" // enable text editor tool
+		flagThink          = "think"          // enable thinking for Claude 3.7
+		flagBudget         = "budget"         // thinking budget
+		flagTextEditor     = "text-editor"    // enable text editor tool
+		flagConfirmEdits   = "confirm-edits"  // show diff and ask before the text editor writes files
+		flagAllowPath      = "allow-path"     // additional directories the text editor can access
+		flagMaxCharacters  = "max-characters" // truncation of text editor file views
+		flagBash           = "bash"           // enable bash tool
+		flagYes            = "yes"            // don't ask for confirmation of tool actions
 		flagImages         = "images"
 		flagEffort         = "effort" // effort level for Claude Opus 4.5
+		flagContinue       = "continue"
+		flagConversation   = "conversation"
+		flagAPI            = "api" // Bedrock API to invoke models with
+		flagProvider       = "provider"
+		flagGuardrail      = "guardrail"
+		flagUsage          = "usage"
+		flagCache          = "cache"
+		flagCacheTTL       = "cache-ttl"
+		flagDryRun         = "dry-run"
+		flagOutput         = "output"
+		flagSchema         = "schema"
+		flagSchemaRepair   = "schema-repair"
+		flagRetries        = "retries"
+		flagTimeout        = "timeout"
 	)
 
-	rootCmd.PersistentFlags().StringVarP(&config.ModelID, flagModel, string(flagModel[0]), "", "The specific foundation model to use (default is claude-opus-4.8)")
-	_ = rootCmd.RegisterFlagCompletionFunc(flagModel,
-		func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
-			return AnthrophicModelsIDs, cobra.ShellCompDirectiveDefault
-		},
-	)
+	rootCmd.PersistentFlags().StringVarP(&config.ModelID, flagModel, string(flagModel[0]), "", "The specific foundation model to use, a model id or an alias like opus or sonnet-4.6, see 'bods models --aliases' (default is claude-opus-4.8)")
+	_ = rootCmd.RegisterFlagCompletionFunc(flagModel, completeModels)
 	rootCmd.PersistentFlags().StringVarP(&config.SystemPrompt, flagSystem, "s", "", "The system prompt to use; if given will overwrite template system prompt")
 	rootCmd.PersistentFlags().StringVarP(&config.Assistant, flagAssistant, "a", "", "The message for the assistant role")
 	rootCmd.PersistentFlags().StringVarP(&config.PromptTemplate, flagPrompt, "p", "", "The prompt name (template) to use")
@@ -294,12 +379,58 @@ func initFlags() {
 	rootCmd.PersistentFlags().BoolVarP(&config.Think, flagThink, "k", false, "Enable thinking (extended for 3.7-4.5, adaptive for Opus 4.6/4.7/4.8)")
 	rootCmd.PersistentFlags().IntVarP(&config.BudgetTokens, flagBudget, string(flagBudget[0]), 0, fmt.Sprintf("Thinking token budget for Claude 3.7-4.5; ignored for Opus 4.6/4.7/4.8, use --effort instead (default=%d)", defaultThinkingTokens))
 	rootCmd.PersistentFlags().BoolVarP(&config.EnableTextEditor, flagTextEditor, "e", false, "Enable text editor tool for Claude to view and modify files")
+	rootCmd.PersistentFlags().BoolVar(&config.ConfirmEdits, flagConfirmEdits, false, "Show a diff of each text editor change and ask to accept or reject it before the file is written")
+	rootCmd.PersistentFlags().StringSliceVar(&config.AllowPaths, flagAllowPath, nil, "Additional directory the text editor can access besides the working directory (repeatable)")
+	_ = rootCmd.MarkPersistentFlagDirname(flagAllowPath)
+	rootCmd.PersistentFlags().IntVar(&config.TextEditor.MaxCharacters, flagMaxCharacters, config.TextEditor.MaxCharacters, "Maximum characters of a file returned by a text editor view; longer files are truncated, 0 means no limit (see 'text_editor' in bods.yaml)")
+	rootCmd.PersistentFlags().BoolVar(&config.EnableBash, flagBash, false, "Enable bash tool for Claude to run shell commands in the working directory (see 'bash' in bods.yaml)")
+	rootCmd.PersistentFlags().BoolVarP(&config.Yes, flagYes, "y", false, "Run tool actions like bash commands without asking for confirmation")
 	rootCmd.PersistentFlags().StringVarP(&config.Effort, flagEffort, "E", "", "Effort level (max, xhigh, high, medium, low). 'xhigh' is Opus 4.7/4.8; 'max' is Opus 4.6/4.7/4.8.")
 	_ = rootCmd.RegisterFlagCompletionFunc(flagEffort,
 		func(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
 			return []string{EffortMax, EffortXHigh, EffortHigh, EffortMedium, EffortLow}, cobra.ShellCompDirectiveDefault
 		},
 	)
+	rootCmd.PersistentFlags().BoolVarP(&config.Continue, flagContinue, "C", false, "Continue the last saved conversation with a new prompt")
+	rootCmd.PersistentFlags().StringVar(&config.ConversationID, flagConversation, "", "Continue the saved conversation with the given id (or unique id prefix)")
+	rootCmd.MarkFlagsMutuallyExclusive(flagContinue, flagConversation)
+	rootCmd.PersistentFlags().StringVar(&config.API, flagAPI, config.API, "Bedrock API to invoke the model with: 'invoke' (InvokeModel) or 'converse' (ConverseStream) (see 'api' in bods.yaml)")
+	_ = rootCmd.RegisterFlagCompletionFunc(flagAPI,
+		func(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+			return apis, cobra.ShellCompDirectiveNoFileComp
+		},
+	)
+	rootCmd.PersistentFlags().StringVar(&config.Provider, flagProvider, config.Provider, "The provider of the model: 'bedrock' or 'anthropic' (the Anthropic API, requires "+anthropicAPIKeyEnv+") (see 'provider' in bods.yaml)")
+	_ = rootCmd.RegisterFlagCompletionFunc(flagProvider,
+		func(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+			return providers, cobra.ShellCompDirectiveNoFileComp
+		},
+	)
+	rootCmd.PersistentFlags().BoolVar(&config.ShowUsage, flagUsage, false, "Print token usage, latency and estimated cost on stderr after the response (per round with tools)")
+	rootCmd.PersistentFlags().StringVar(&config.Cache, flagCache, config.Cache, "Prompt caching: 'off', 'auto' (cache breakpoints where the prompt is long enough to be cached) or 'aggressive' (see 'cache' in bods.yaml)")
+	_ = rootCmd.RegisterFlagCompletionFunc(flagCache,
+		func(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+			return cacheModes, cobra.ShellCompDirectiveNoFileComp
+		},
+	)
+	rootCmd.PersistentFlags().StringVar(&config.CacheTTL, flagCacheTTL, config.CacheTTL, "Lifetime of prompt cache entries: '5m' or '1h' (see 'cache_ttl' in bods.yaml)")
+	_ = rootCmd.RegisterFlagCompletionFunc(flagCacheTTL,
+		func(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+			return cacheTTLs, cobra.ShellCompDirectiveNoFileComp
+		},
+	)
+	rootCmd.PersistentFlags().BoolVar(&config.DryRun, flagDryRun, false, "Print the estimated input tokens of the request instead of invoking the model, same as 'bods count'")
+	rootCmd.PersistentFlags().StringVar(&config.Output, flagOutput, outputText, "Output format: 'text', 'json' (one object with text, thinking, tool calls, usage and metrics) or 'ndjson' (every stream event as it is received)")
+	_ = rootCmd.RegisterFlagCompletionFunc(flagOutput,
+		func(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+			return outputFormats, cobra.ShellCompDirectiveNoFileComp
+		},
+	)
+	rootCmd.PersistentFlags().StringVar(&config.Schema, flagSchema, "", "JSON schema file the response must match (overrides 'schema' of the prompt template)")
+	rootCmd.PersistentFlags().BoolVar(&config.SchemaRepair, flagSchemaRepair, false, "Ask the model once to repair a response that does not match the schema")
+	rootCmd.PersistentFlags().IntVar(&config.Retries, flagRetries, config.Retries, "Retries of an invocation that is throttled or the model unavailable or not ready, with exponential backoff; a response that fails midway is invoked again (see 'retries' in bods.yaml)")
+	rootCmd.PersistentFlags().DurationVar(&config.Timeout, flagTimeout, config.Timeout, "Deadline of an invocation including retries and the response, e.g. 5m; 0 for none (see 'timeout' in bods.yaml)")
+	rootCmd.PersistentFlags().StringVar(&config.Guardrail, flagGuardrail, "", "The Bedrock guardrail to apply as id:version, e.g. gr4bc1d2e3:1 (overrides 'guardrail' of the prompt template)")
 }
 
 func main() {
@@ -312,21 +443,35 @@ func main() {
 
 	// must come after creating the config b/c config values used
 	initFlags()
-
-	// With no subcommands, Cobra won't create the default `completion` command.
-	// Force creation of the completion related subcommands by adding a 'fake'
-	// command when completions are being used. e.g. __BODS_CMP_ENABLED=1 bods completion zsh
-	if os.Getenv("__BODS_CMP_ENABLED") == "1" || (len(os.Args) > 1 && os.Args[1] == "__complete") {
-		rootCmd.AddCommand(&cobra.Command{Use: "____fake_command_to_enable_completions"})
-		rootCmd.InitDefaultCompletionCmd()
-	}
+	initConversationCommands()
+	initJournalCommands()
+	initModelsCommands()
+	initUsageCommands()
+	initBatchCommands()
+	rootCmd.AddCommand(chatCmd)
+	rootCmd.AddCommand(countCmd)
 
 	if err := rootCmd.Execute(); err != nil {
 		handleError(err)
+		var intervention *guardrailIntervention
+		if errors.As(err, &intervention) && intervention.Blocked {
+			os.Exit(exitCodeGuardrailBlocked)
+		}
 		os.Exit(1)
 	}
 }
 
+// noPrompt wraps the validation of the args of a command that takes no prompt; too
+// many args are likely an unquoted prompt that starts with the name of the command.
+func noPrompt(validate cobra.PositionalArgs) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		if err := validate(cmd, args); err != nil {
+			return bodsError{err, fmt.Sprintf("To send a prompt that starts with '%s', quote it or put it after --, e.g. bods -- %s %s", cmd.Name(), cmd.Name(), strings.Join(args, " "))}
+		}
+		return nil
+	}
+}
+
 func handleError(err error) {
 	// empty stdin
 	if !isInputTerminal() {
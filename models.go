@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/knadh/koanf/parsers/yaml"
+	"github.com/knadh/koanf/providers/rawbytes"
+	"github.com/knadh/koanf/v2"
+)
+
+// Thinking modes of a model
+const (
+	ThinkingExtended = "extended" // thinking with budget_tokens
+	ThinkingAdaptive = "adaptive" // the model decides how much to think, see --effort
+)
+
+// Sampling parameter rules of a model
+const (
+	SamplingAll              = ""                     // temperature, top_p and top_k can be set
+	SamplingTemperatureOrTop = "temperature_or_top_p" // only temperature or top_p can be set, not both
+	SamplingNone             = "none"                 // any sampling parameter is rejected
+)
+
+// ModelBetas are the anthropic_beta headers a model needs for a feature.
+type ModelBetas struct {
+	TextEditor         []string `koanf:"text_editor"`          // with the text editor tool
+	TextEditorThinking []string `koanf:"text_editor_thinking"` // with the text editor tool and thinking enabled
+	Effort             []string `koanf:"effort"`               // with the effort parameter
+}
+
+// ModelInfo is an entry of the model registry ('models' section of bods.yaml).
+type ModelInfo struct {
+	ID                string     `koanf:"id"` // e.g. anthropic.claude-opus-4-8
+	Name              string     `koanf:"name"`
+	Family            string     `koanf:"family"`  // opus, sonnet or haiku
+	Aliases           []string   `koanf:"aliases"` // e.g. sonnet-4.6, see ResolveAlias
+	Vision            bool       `koanf:"vision"`
+	PDF               bool       `koanf:"pdf"`
+	Caching           bool       `koanf:"caching"`
+	Thinking          string     `koanf:"thinking"` // ThinkingExtended, ThinkingAdaptive or empty if not supported
+	Effort            []string   `koanf:"effort"`   // supported effort levels
+	Sampling          string     `koanf:"sampling"` // SamplingAll, SamplingTemperatureOrTop or SamplingNone
+	TextEditor        string     `koanf:"text_editor"`
+	StructuredOutputs bool       `koanf:"structured_outputs"` // constrains the response to a JSON schema, see --schema
+	Betas             ModelBetas `koanf:"betas"`
+	Price             ModelPrice `koanf:"price"`
+
+	CacheMinTokens  int     `koanf:"cache_min_tokens"`  // shortest prompt prefix that is cached, see planCache
+	CharsPerToken   float64 `koanf:"chars_per_token"`   // of the tokenizer, see EstimateTokens; 4 if not set
+	ContextWindow   int     `koanf:"context_window"`    // input and output tokens, see bods count
+	MaxOutputTokens int     `koanf:"max_output_tokens"` // largest max_tokens, including thinking
+}
+
+// ModelPrice is the price of a model in USD per million tokens, used to estimate the
+// cost shown by --usage.
+type ModelPrice struct {
+	Input      float64 `koanf:"input"`
+	Output     float64 `koanf:"output"`
+	CacheRead  float64 `koanf:"cache_read"`
+	CacheWrite float64 `koanf:"cache_write"`
+}
+
+// EstimateTokens estimates the number of tokens of a text for the model from the
+// characters per token of its tokenizer.
+func (m ModelInfo) EstimateTokens(text string) int {
+	if m.CharsPerToken <= 0 {
+		return estimateTokens(text)
+	}
+	return int(math.Ceil(float64(utf8.RuneCountInString(text)) / m.CharsPerToken))
+}
+
+// SupportsEffort reports whether the model supports the given effort level; with
+// an empty level, whether it supports the effort parameter at all.
+func (m ModelInfo) SupportsEffort(level string) bool {
+	if level == "" {
+		return len(m.Effort) > 0
+	}
+	return slices.Contains(m.Effort, level)
+}
+
+// ModelRegistry holds the Claude models known to bods and their capabilities.
+type ModelRegistry struct {
+	DefaultModel string
+	Models       []ModelInfo
+}
+
+// modelRegistry is loaded from the embedded bods.yaml and merged with the models
+// of the user's bods.yaml by ensureConfig.
+var modelRegistry = mustLoadModelRegistry(bodsConfig)
+
+func mustLoadModelRegistry(yamlConfig []byte) *ModelRegistry {
+	r, err := loadModelRegistry(yamlConfig)
+	if err != nil {
+		panic(fmt.Sprintf("invalid models in embedded bods.yaml: %v", err))
+	}
+	return r
+}
+
+// loadModelRegistry reads the 'default_model' and 'models' settings of a bods.yaml.
+func loadModelRegistry(yamlConfig []byte) (*ModelRegistry, error) {
+	kc := koanf.New(".")
+	if err := kc.Load(rawbytes.Provider(yamlConfig), yaml.Parser()); err != nil {
+		return nil, err
+	}
+	return modelRegistryFromKoanf(kc)
+}
+
+func modelRegistryFromKoanf(kc *koanf.Koanf) (*ModelRegistry, error) {
+	r := &ModelRegistry{DefaultModel: kc.String("default_model")}
+	if err := kc.Unmarshal("models", &r.Models); err != nil {
+		return nil, err
+	}
+	for _, m := range r.Models {
+		if m.ID == "" {
+			return nil, fmt.Errorf("model '%s' has no id", m.Name)
+		}
+	}
+	return r, nil
+}
+
+// Merge adds the models of other to r, replacing models with the same id.
+func (r *ModelRegistry) Merge(other *ModelRegistry) {
+	if other.DefaultModel != "" {
+		r.DefaultModel = other.DefaultModel
+	}
+	for _, m := range other.Models {
+		i := slices.IndexFunc(r.Models, func(existing ModelInfo) bool { return existing.ID == m.ID })
+		if i >= 0 {
+			r.Models[i] = m
+		} else {
+			r.Models = append(r.Models, m)
+		}
+	}
+}
+
+// Lookup returns the model with the given model or inference profile id.
+func (r *ModelRegistry) Lookup(id string) (ModelInfo, bool) {
+	modelID := normalizeToModelID(id)
+	for _, m := range r.Models {
+		if m.ID == modelID {
+			return m, true
+		}
+	}
+	return ModelInfo{}, false
+}
+
+// ModelAlias is a short name of a model which can be used instead of its id.
+type ModelAlias struct {
+	Alias   string `json:"alias"`
+	ModelID string `json:"model_id"`
+}
+
+const latestAliasSuffix = "-latest"
+
+// Aliases returns all aliases that ResolveAlias resolves: the aliases of the models
+// and the names of the model families, e.g. opus and opus-latest for the last Opus
+// model of the registry. If several models have the same alias, the last one wins,
+// so models of the user's bods.yaml can take over an alias.
+func (r *ModelRegistry) Aliases() []ModelAlias {
+	var aliases []ModelAlias
+	index := make(map[string]int)
+	add := func(alias, modelID string) {
+		alias = strings.ToLower(alias)
+		if i, ok := index[alias]; ok {
+			aliases[i].ModelID = modelID
+			return
+		}
+		index[alias] = len(aliases)
+		aliases = append(aliases, ModelAlias{Alias: alias, ModelID: modelID})
+	}
+
+	for _, m := range r.Models {
+		if m.Family != "" {
+			add(m.Family, m.ID)
+			add(m.Family+latestAliasSuffix, m.ID)
+		}
+	}
+	for _, m := range r.Models { // explicit aliases take precedence over family names
+		for _, alias := range m.Aliases {
+			add(alias, m.ID)
+		}
+	}
+
+	slices.SortFunc(aliases, func(a, b ModelAlias) int { return strings.Compare(a.Alias, b.Alias) })
+	return aliases
+}
+
+// ResolveAlias returns the model id for a model alias, e.g. opus or sonnet-4.6; an
+// alias with an inference profile prefix, e.g. eu.sonnet, resolves to the inference
+// profile id. Aliases are case-insensitive; any other name is returned unchanged.
+func (r *ModelRegistry) ResolveAlias(name string) string {
+	prefix, alias := "", strings.ToLower(strings.TrimSpace(name))
+	if p, rest, ok := strings.Cut(alias, "."); ok && (p == "global" || len(p) == 2) {
+		prefix, alias = p+".", rest
+	}
+	for _, a := range r.Aliases() {
+		if a.Alias == alias {
+			logger.Printf("resolved model alias %s to %s%s\n", name, prefix, a.ModelID)
+			return prefix + a.ModelID
+		}
+	}
+	return name
+}
+
+// lookupModel returns the registry entry of the given model or inference profile
+// id; unknown models have no capabilities.
+func lookupModel(id string) ModelInfo {
+	m, _ := modelRegistry.Lookup(id)
+	return m
+}
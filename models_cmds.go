@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	sdkconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/bedrock"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/spf13/cobra"
+)
+
+var (
+	modelsJSON    bool
+	modelsAliases bool
+
+	modelsCmd = &cobra.Command{
+		Use:   "models",
+		Short: "List the Claude models and inference profiles available in the current region, and their capabilities",
+		Long: "List the Claude models offered in the current AWS region, whether the account has access to them,\n" +
+			"their inference profiles, and the capabilities bods knows about. With --aliases, list the model\n" +
+			"aliases that can be used with --model and the 'model_id' of a prompt.",
+		Args: noPrompt(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if modelsAliases {
+				return printModelAliases()
+			}
+
+			client, region, err := newBedrockClient(cmd.Context())
+			if err != nil {
+				return bodsError{err, "Could not load the AWS configuration."}
+			}
+			models, err := listAccountModels(cmd.Context(), client)
+			if err != nil {
+				return bodsError{err, fmt.Sprintf("Could not list the models of region %s. Are your AWS credentials and region correct?", region)}
+			}
+			cacheAccountModels(region, models)
+
+			if modelsJSON {
+				return printJSON(models)
+			}
+			if len(models) == 0 {
+				_, _ = fmt.Fprintf(os.Stderr, "No Claude models found in region %s.\n", region)
+				return nil
+			}
+			printAccountModels(models)
+			return nil
+		},
+	}
+)
+
+func initModelsCommands() {
+	modelsCmd.Flags().BoolVar(&modelsJSON, "json", false, "Print the models as JSON")
+	modelsCmd.Flags().BoolVar(&modelsAliases, "aliases", false, "Print the model aliases instead, without calling AWS")
+	rootCmd.AddCommand(modelsCmd)
+}
+
+func newBedrockClient(ctx context.Context) (*bedrock.Client, string, error) {
+	awsConfig, err := sdkconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	return bedrock.NewFromConfig(awsConfig), awsConfig.Region, nil
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// printTable prints rows as aligned columns below a header; cells of the first
+// column are highlighted.
+func printTable(header []string, rows [][]string) {
+	fmt.Print(formatTable(stdoutStyles(), header, rows))
+}
+
+// formatTable returns the rows as columns aligned to the widest cell, with the
+// header in bold and the first column highlighted.
+func formatTable(s styles, header []string, rows [][]string) string {
+	widths := make([]int, len(header))
+	for _, row := range append([][]string{header}, rows...) {
+		for i, cell := range row {
+			widths[i] = max(widths[i], lipgloss.Width(cell))
+		}
+	}
+
+	line := func(row []string, firstColumn lipgloss.Style) string {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			if i < len(row)-1 {
+				cell += strings.Repeat(" ", widths[i]-lipgloss.Width(cell))
+			}
+			if i == 0 {
+				cell = firstColumn.Render(cell)
+			}
+			cells[i] = cell
+		}
+		return s.ConversationList.Render(strings.Join(cells, "  "))
+	}
+
+	var sb strings.Builder
+	sb.WriteString(s.AppName.Render(line(header, lipgloss.NewStyle())) + "\n")
+	for _, row := range rows {
+		sb.WriteString(line(row, s.Flag) + "\n")
+	}
+	return sb.String()
+}
+
+// printAccountModels prints the models of the account as a table.
+func printAccountModels(models []AccountModel) {
+	var rows [][]string
+	for _, m := range models {
+		name := m.Name
+		if m.Lifecycle != "" && m.Lifecycle != "ACTIVE" {
+			name += " (" + strings.ToLower(m.Lifecycle) + ")"
+		}
+		var profiles []string
+		for _, p := range m.Profiles {
+			profiles = append(profiles, strings.TrimSuffix(p, "."+m.ModelID)) // e.g. global, us
+		}
+		if m.OnDemand {
+			profiles = append([]string{"on-demand"}, profiles...)
+		}
+		capabilities := strings.Join(m.Capabilities, ", ")
+		if !m.Known {
+			capabilities = "unknown to bods, add it to 'models' in bods.yaml"
+		}
+		rows = append(rows, []string{m.ModelID, name, m.Access, strings.Join(profiles, ", "), capabilities})
+	}
+	printTable([]string{"MODEL", "NAME", "ACCESS", "INVOKE WITH", "CAPABILITIES"}, rows)
+}
+
+// printModelAliases prints the alias table of the model registry.
+func printModelAliases() error {
+	if modelsJSON {
+		return printJSON(modelRegistry.Aliases())
+	}
+
+	var rows [][]string
+	for _, a := range modelRegistry.Aliases() {
+		name := lookupModel(a.ModelID).Name
+		if a.ModelID == modelRegistry.DefaultModel {
+			name += ", default"
+		}
+		rows = append(rows, []string{a.Alias, a.ModelID, name})
+	}
+	printTable([]string{"ALIAS", "MODEL", "NAME"}, rows)
+	return nil
+}
+
+// completeModels completes model aliases, model ids and inference profile ids for
+// --model. If the models of the account are known (see 'bods models'), only the
+// usable ones are suggested.
+func completeModels(cmd *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+	models, ok := completionAccountModels(cmd.Context())
+
+	usable := make(map[string]bool)
+	var completions []string
+	for _, m := range models {
+		if !m.Usable() {
+			continue
+		}
+		usable[m.ModelID] = true
+		if m.OnDemand {
+			completions = append(completions, m.ModelID+"\t"+m.Name)
+		}
+		for _, p := range m.Profiles {
+			completions = append(completions, p+"\t"+m.Name)
+		}
+	}
+
+	var aliases []string
+	for _, a := range modelRegistry.Aliases() {
+		if !ok || usable[a.ModelID] {
+			aliases = append(aliases, a.Alias+"\t"+a.ModelID)
+		}
+	}
+	if !ok {
+		for _, m := range modelRegistry.Models {
+			completions = append(completions, m.ID+"\t"+m.Name)
+		}
+	}
+	return append(aliases, completions...), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completionAccountModels returns the models of the account from the cache, or
+// lists them if they are not cached and the listing is fast enough for completion.
+func completionAccountModels(ctx context.Context) ([]AccountModel, bool) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	client, region, err := newBedrockClient(ctx)
+	if err != nil {
+		return nil, false
+	}
+	if models, ok := cachedAccountModels(region); ok {
+		return models, true
+	}
+	models, err := listAccountModels(ctx, client)
+	if err != nil {
+		logger.Println("completionAccountModels() - ", err)
+		return nil, false
+	}
+	cacheAccountModels(region, models)
+	return models, true
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmbeddedModelRegistry(t *testing.T) {
+	r, err := loadModelRegistry(bodsConfig)
+	require.NoError(t, err)
+
+	_, ok := r.Lookup(r.DefaultModel)
+	assert.True(t, ok, "default model must be in the registry")
+
+	for _, m := range r.Models {
+		assert.NotEmpty(t, m.Name, m.ID)
+		assert.Contains(t, []string{"", ThinkingExtended, ThinkingAdaptive}, m.Thinking, m.ID)
+		assert.Contains(t, []string{SamplingAll, SamplingTemperatureOrTop, SamplingNone}, m.Sampling, m.ID)
+		for _, level := range m.Effort {
+			assert.Contains(t, []string{EffortMax, EffortXHigh, EffortHigh, EffortMedium, EffortLow}, level, m.ID)
+		}
+	}
+}
+
+func TestModelRegistryMerge(t *testing.T) {
+	r, err := loadModelRegistry(bodsConfig)
+	require.NoError(t, err)
+
+	user, err := loadModelRegistry([]byte(`
+default_model: anthropic.claude-opus-5
+models:
+  - id: anthropic.claude-opus-5
+    name: Claude Opus 5
+    vision: true
+    thinking: adaptive
+    effort: [high, low]
+  - id: anthropic.claude-3-5-haiku-20241022-v1:0
+    name: Claude 3.5 Haiku
+    vision: true
+`))
+	require.NoError(t, err)
+
+	n := len(r.Models)
+	r.Merge(user)
+	assert.Len(t, r.Models, n+1)
+	assert.Equal(t, "anthropic.claude-opus-5", r.DefaultModel)
+
+	m, ok := r.Lookup("us.anthropic.claude-opus-5")
+	require.True(t, ok)
+	assert.True(t, m.SupportsEffort(EffortHigh))
+	assert.False(t, m.SupportsEffort(EffortMax))
+
+	m, _ = r.Lookup(ClaudeV35Haiku.String())
+	assert.True(t, m.Vision, "user entry replaces the built-in one")
+	assert.False(t, m.Caching)
+}
+
+func TestResolveAlias(t *testing.T) {
+	r, err := loadModelRegistry(bodsConfig)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{name: "opus", expected: "anthropic.claude-opus-4-8"},
+		{name: "Opus-Latest", expected: "anthropic.claude-opus-4-8"},
+		{name: "sonnet-4.6", expected: "anthropic.claude-sonnet-4-6"},
+		{name: "sonnet-3.5", expected: ClaudeV35SonnetV2.String()},
+		{name: "haiku", expected: ClaudeV45Haiku.String()},
+		{name: "eu.sonnet", expected: "eu.anthropic.claude-sonnet-4-6"},
+		{name: "global.opus-4.5", expected: "global." + ClaudeV45Opus.String()},
+		{name: ClaudeV37Sonnet.String(), expected: ClaudeV37Sonnet.String()},
+		{name: "us." + ClaudeV37Sonnet.String(), expected: "us." + ClaudeV37Sonnet.String()},
+		{name: "unknown", expected: "unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, r.ResolveAlias(tt.name))
+		})
+	}
+
+	// a newer model of a family in the user's bods.yaml becomes the family's latest
+	r.Merge(&ModelRegistry{Models: []ModelInfo{{ID: "anthropic.claude-opus-5", Family: "opus"}}})
+	assert.Equal(t, "anthropic.claude-opus-5", r.ResolveAlias("opus"))
+	assert.Equal(t, "anthropic.claude-opus-4-8", r.ResolveAlias("opus-4.8"))
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Output formats of the response, see --output
+const (
+	outputText   = "text"   // the response text, rendered as markdown on a terminal
+	outputJSON   = "json"   // one object with the response, tool calls, usage and metrics
+	outputNDJSON = "ndjson" // every stream event as a line of JSON, as it is received
+)
+
+var outputFormats = []string{outputText, outputJSON, outputNDJSON}
+
+// validateOutputFormat checks the --output format; chat mode has a user interface
+// instead of output.
+func validateOutputFormat(format string, chat bool) error {
+	if !slices.Contains(outputFormats, format) {
+		return fmt.Errorf("unknown output format '%s', supported are: %s", format, strings.Join(outputFormats, ", "))
+	}
+	if chat && format != outputText {
+		return fmt.Errorf("output format '%s' is not supported in chat mode", format)
+	}
+	return nil
+}
+
+// jsonResponse is the result of a run printed with --output json.
+type jsonResponse struct {
+	ModelID           string                 `json:"model_id"` // resolved model or inference profile id
+	Text              string                 `json:"text"`
+	StructuredOutput  json.RawMessage        `json:"structured_output,omitempty"` // validated against --schema
+	Thinking          []string               `json:"thinking,omitempty"`
+	ToolCalls         []jsonToolCall         `json:"tool_calls,omitempty"`
+	StopReason        string                 `json:"stop_reason"`
+	Usage             Usage                  `json:"usage"` // of all invocations
+	InvocationMetrics []jsonInvocation       `json:"invocation_metrics"`
+	Guardrail         *guardrailIntervention `json:"guardrail,omitempty"`
+	ConversationID    string                 `json:"conversation_id,omitempty"`
+}
+
+// jsonToolCall is a tool_use block of the response with the result of the tool.
+type jsonToolCall struct {
+	ID      string          `json:"id"`
+	Name    string          `json:"name"`
+	Input   json.RawMessage `json:"input"`
+	Result  string          `json:"result"`
+	IsError bool            `json:"is_error,omitempty"`
+}
+
+// jsonInvocation are the metrics of one model invocation, e.g. one round of a tool
+// use loop.
+type jsonInvocation struct {
+	ModelID       string             `json:"model_id"`
+	Usage         Usage              `json:"usage"`
+	TTFBMillis    int64              `json:"ttfb_ms"`
+	LatencyMillis int64              `json:"latency_ms"`
+	Bedrock       *InvocationMetrics `json:"bedrock,omitempty"` // as reported by Bedrock, not by the Anthropic API
+}
+
+// newJSONResponse collects the response of the run from the messages the model
+// added to the conversation: text and thinking of the assistant messages and the
+// tool calls with the results sent back in the following user messages.
+func newJSONResponse(b *Bods, response []Message) jsonResponse {
+	r := jsonResponse{
+		ModelID:           b.Config.ModelID,
+		StopReason:        b.stopReason,
+		Usage:             b.Usage,
+		InvocationMetrics: []jsonInvocation{},
+		Guardrail:         b.intervention,
+		StructuredOutput:  b.structured,
+	}
+
+	var texts []string
+	calls := make(map[string]int) // tool_use id to index in ToolCalls
+	for _, m := range response {
+		for _, c := range m.Content {
+			switch c.Type {
+			case MessageContentTypeText:
+				if text := strings.TrimSpace(c.Text); text != "" && m.Role == MessageRoleAssistant {
+					texts = append(texts, text)
+				}
+			case MessageContentTypeThinking:
+				if c.Thinking != "" {
+					r.Thinking = append(r.Thinking, c.Thinking)
+				}
+			case MessageContentTypeToolUse:
+				if c.Name == schemaToolName { // the structured output, not a tool call
+					continue
+				}
+				calls[c.ID] = len(r.ToolCalls)
+				r.ToolCalls = append(r.ToolCalls, jsonToolCall{ID: c.ID, Name: c.Name, Input: c.Input})
+			case MessageContentTypeToolResult:
+				if i, ok := calls[c.ToolUseID]; ok {
+					r.ToolCalls[i].Result = c.Content
+					r.ToolCalls[i].IsError = c.IsError
+				}
+			}
+		}
+	}
+	r.Text = strings.Join(texts, "\n\n")
+
+	for _, round := range b.rounds {
+		r.InvocationMetrics = append(r.InvocationMetrics, jsonInvocation{
+			ModelID:       round.ModelID,
+			Usage:         round.Usage,
+			TTFBMillis:    round.TTFB.Milliseconds(),
+			LatencyMillis: round.Latency.Milliseconds(),
+			Bedrock:       round.Metrics,
+		})
+	}
+	return r
+}
+
+// emitEvent writes v as one line of JSON to stdout, see --output ndjson.
+func emitEvent(v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		logger.Println("emitEvent:", err)
+		return
+	}
+	fmt.Println(string(data))
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewJSONResponse(t *testing.T) {
+	b := &Bods{
+		Config:     &Config{ModelID: "us.anthropic.claude-sonnet-4-6"},
+		Usage:      Usage{InputTokens: 300, OutputTokens: 40},
+		stopReason: "end_turn",
+		rounds: []roundUsage{
+			{ModelID: "us.anthropic.claude-sonnet-4-6", Usage: Usage{InputTokens: 100, OutputTokens: 30}, TTFB: 500 * time.Millisecond, Latency: 2 * time.Second,
+				Metrics: &InvocationMetrics{FirstByteLatency: 480, InputTokenCount: 100, InvocationLatency: 1990, OutputTokenCount: 30}},
+			{ModelID: "us.anthropic.claude-sonnet-4-6", Usage: Usage{InputTokens: 200, OutputTokens: 10}, Latency: time.Second},
+		},
+	}
+	response := []Message{
+		{Role: MessageRoleAssistant, Content: []Content{
+			{Type: MessageContentTypeThinking, Thinking: "list the files first", Signature: "sig"},
+			{Type: MessageContentTypeText, Text: " I will look."},
+			{Type: MessageContentTypeToolUse, ID: "toolu_1", Name: "bash", Input: json.RawMessage(`{"command":"ls"}`)},
+		}},
+		{Role: MessageRoleUser, Content: []Content{
+			{Type: MessageContentTypeToolResult, ToolUseID: "toolu_1", Content: "go.mod", IsError: false},
+		}},
+		{Role: MessageRoleAssistant, Content: []Content{
+			{Type: MessageContentTypeText, Text: " "}, // placeholder of an empty block
+			{Type: MessageContentTypeText, Text: " There is a go.mod."},
+		}},
+	}
+
+	r := newJSONResponse(b, response)
+	assert.Equal(t, "I will look.\n\nThere is a go.mod.", r.Text)
+	assert.Equal(t, []string{"list the files first"}, r.Thinking)
+	require.Len(t, r.ToolCalls, 1)
+	assert.Equal(t, jsonToolCall{ID: "toolu_1", Name: "bash", Input: json.RawMessage(`{"command":"ls"}`), Result: "go.mod"}, r.ToolCalls[0])
+	require.Len(t, r.InvocationMetrics, 2)
+	assert.Equal(t, int64(500), r.InvocationMetrics[0].TTFBMillis)
+	assert.Nil(t, r.InvocationMetrics[1].Bedrock)
+
+	data, err := json.Marshal(r)
+	require.NoError(t, err)
+	var decoded map[string]any
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, "us.anthropic.claude-sonnet-4-6", decoded["model_id"])
+	assert.Equal(t, "end_turn", decoded["stop_reason"])
+	assert.NotContains(t, decoded, "guardrail")
+}
+
+func TestValidateOutputFormat(t *testing.T) {
+	assert.NoError(t, validateOutputFormat(outputNDJSON, false))
+	assert.NoError(t, validateOutputFormat(outputText, true))
+	assert.Error(t, validateOutputFormat("yaml", false))
+	assert.Error(t, validateOutputFormat(outputJSON, true))
+}
@@ -169,6 +169,10 @@ func (h *FileURLContentHandler) processImageFile(filePath string) (*Content, err
 }
 
 func (h *FileURLContentHandler) processPDFFile(filePath string) (*Content, error) {
+	if !IsPDFCapable(h.config.ModelID) {
+		return nil, fmt.Errorf("model does not support PDF documents")
+	}
+
 	pdfBytes, err := os.ReadFile(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read PDF file: %v", err)
@@ -193,10 +197,6 @@ func (h *FileURLContentHandler) processPDFFile(filePath string) (*Content, error
 		},
 	}
 
-	if IsPromptCachingSupported(h.config.ModelID) {
-		content.CacheControl = &CacheControl{Type: CacheControlTypeEphemeral}
-	}
-
 	return &content, nil
 }
 
@@ -212,10 +212,6 @@ func (h *FileURLContentHandler) processTextFile(filePath string) (*Content, erro
 			string(textBytes)),
 	}
 
-	if IsPromptCachingSupported(h.config.ModelID) {
-		content.CacheControl = &CacheControl{Type: CacheControlTypeEphemeral}
-	}
-
 	return &content, nil
 }
 
@@ -284,6 +280,10 @@ func (h *PDFContentHandler) CanHandle(contentType string) bool {
 }
 
 func (h *PDFContentHandler) Handle(contentType string, data []byte) ([]Content, error) {
+	if !IsPDFCapable(h.config.ModelID) {
+		return nil, fmt.Errorf("%s: model does not support PDF documents", h.config.ModelID)
+	}
+
 	if err := validatePDF(data); err != nil {
 		return nil, fmt.Errorf("invalid PDF content: %v", err)
 	}
@@ -303,10 +303,6 @@ func (h *PDFContentHandler) Handle(contentType string, data []byte) ([]Content,
 		},
 	}
 
-	if IsPromptCachingSupported(h.config.ModelID) {
-		content.CacheControl = &CacheControl{Type: CacheControlTypeEphemeral}
-	}
-
 	return []Content{content}, nil
 }
 
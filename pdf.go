@@ -117,3 +117,8 @@ func validatePDF(pdfBytes []byte) error {
 	reader := bytes.NewReader(pdfBytes)
 	return api.Validate(reader, nil)
 }
+
+// pdfPageCount returns the number of pages of a PDF document.
+func pdfPageCount(pdfBytes []byte) (int, error) {
+	return api.PageCount(bytes.NewReader(pdfBytes), nil)
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// schemaToolName is the tool Claude is forced to call with the response as input,
+// for models without structured outputs, see --schema.
+const schemaToolName = "structured_output"
+
+// responseSchema is the JSON schema the response must match, see --schema and
+// 'schema' of a prompt template.
+type responseSchema struct {
+	Name   string // of the schema file or prompt template
+	Raw    json.RawMessage
+	Native bool // structured outputs of the model; otherwise a forced call of schemaTool
+
+	root *jsonSchema
+}
+
+// loadResponseSchema loads a schema from a file, from inline JSON or from a schema
+// defined as YAML in bods.yaml.
+func loadResponseSchema(source any, name string) (*responseSchema, error) {
+	var raw []byte
+	switch s := source.(type) {
+	case string:
+		if strings.HasPrefix(strings.TrimSpace(s), "{") {
+			raw = []byte(s)
+		} else {
+			data, err := os.ReadFile(s)
+			if err != nil {
+				return nil, fmt.Errorf("could not read schema: %w", err)
+			}
+			raw = data
+			name = strings.TrimSuffix(filepath.Base(s), filepath.Ext(s))
+		}
+	case map[string]any:
+		data, err := json.Marshal(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schema: %w", err)
+		}
+		raw = data
+	default:
+		return nil, fmt.Errorf("invalid schema of type %T, expected a file name or a JSON schema", source)
+	}
+
+	var root jsonSchema
+	if err := json.Unmarshal(raw, &root); err != nil {
+		return nil, fmt.Errorf("invalid schema %s: %w", name, err)
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, raw); err != nil {
+		return nil, fmt.Errorf("invalid schema %s: %w", name, err)
+	}
+	return &responseSchema{Name: name, Raw: compact.Bytes(), root: &root}, nil
+}
+
+// Validate returns the violations of the schema by the JSON data, none if it is
+// valid.
+func (s *responseSchema) Validate(data []byte) []string {
+	var v any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&v); err != nil {
+		return []string{"the response is not valid JSON: " + err.Error()}
+	}
+	if decoder.More() {
+		return []string{"the response is not a single JSON value"}
+	}
+	var violations []string
+	s.root.validate(s.root, "$", v, &violations)
+	return violations
+}
+
+// jsonSchema is the part of JSON Schema that is validated locally; the keywords
+// supported by structured outputs. Unknown keywords like format are ignored.
+type jsonSchema struct {
+	Type                 schemaTypes            `json:"type"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	Required             []string               `json:"required"`
+	AdditionalProperties json.RawMessage        `json:"additionalProperties"` // false or a schema
+	Items                *jsonSchema            `json:"items"`
+	Enum                 []any                  `json:"enum"`
+	Const                json.RawMessage        `json:"const"`
+	AnyOf                []*jsonSchema          `json:"anyOf"`
+	AllOf                []*jsonSchema          `json:"allOf"`
+	OneOf                []*jsonSchema          `json:"oneOf"`
+	Ref                  string                 `json:"$ref"`
+	Defs                 map[string]*jsonSchema `json:"$defs"`
+	Definitions          map[string]*jsonSchema `json:"definitions"`
+	MinItems             *int                   `json:"minItems"`
+	MaxItems             *int                   `json:"maxItems"`
+	MinLength            *int                   `json:"minLength"`
+	MaxLength            *int                   `json:"maxLength"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	Pattern              string                 `json:"pattern"`
+}
+
+// schemaTypes is the type keyword, a type name or a list of type names.
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*t = schemaTypes{name}
+		return nil
+	}
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return errors.New("type must be a type name or a list of type names")
+	}
+	*t = names
+	return nil
+}
+
+func (s *jsonSchema) validate(root *jsonSchema, path string, v any, violations *[]string) {
+	fail := func(format string, args ...any) {
+		*violations = append(*violations, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	if s.Ref != "" {
+		ref, ok := root.resolve(s.Ref)
+		if !ok {
+			fail("unresolved $ref %s", s.Ref)
+			return
+		}
+		ref.validate(root, path, v, violations)
+	}
+
+	if len(s.Type) > 0 && !slices.ContainsFunc(s.Type, func(t string) bool { return isSchemaType(v, t) }) {
+		fail("expected %s, got %s", strings.Join(s.Type, " or "), jsonTypeName(v))
+		return
+	}
+	if len(s.Enum) > 0 && !slices.ContainsFunc(s.Enum, func(e any) bool { return reflect.DeepEqual(e, v) }) {
+		fail("%s is not one of the allowed values", jsonText(v))
+	}
+	if len(s.Const) > 0 {
+		var c any
+		if err := json.Unmarshal(s.Const, &c); err == nil && !reflect.DeepEqual(c, v) {
+			fail("expected %s, got %s", string(s.Const), jsonText(v))
+		}
+	}
+
+	switch value := v.(type) {
+	case map[string]any:
+		s.validateObject(root, path, value, violations)
+	case []any:
+		if s.MinItems != nil && len(value) < *s.MinItems {
+			fail("expected at least %d items, got %d", *s.MinItems, len(value))
+		}
+		if s.MaxItems != nil && len(value) > *s.MaxItems {
+			fail("expected at most %d items, got %d", *s.MaxItems, len(value))
+		}
+		if s.Items != nil {
+			for i, item := range value {
+				s.Items.validate(root, fmt.Sprintf("%s[%d]", path, i), item, violations)
+			}
+		}
+	case string:
+		length := len([]rune(value))
+		if s.MinLength != nil && length < *s.MinLength {
+			fail("expected at least %d characters, got %d", *s.MinLength, length)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			fail("expected at most %d characters, got %d", *s.MaxLength, length)
+		}
+		if s.Pattern != "" {
+			if re, err := regexp.Compile(s.Pattern); err == nil && !re.MatchString(value) {
+				fail("%s does not match the pattern %s", jsonText(value), s.Pattern)
+			}
+		}
+	case float64:
+		if s.Minimum != nil && value < *s.Minimum {
+			fail("%v is less than the minimum %v", value, *s.Minimum)
+		}
+		if s.Maximum != nil && value > *s.Maximum {
+			fail("%v is greater than the maximum %v", value, *s.Maximum)
+		}
+	}
+
+	for _, sub := range s.AllOf {
+		sub.validate(root, path, v, violations)
+	}
+	if len(s.AnyOf) > 0 && matching(root, s.AnyOf, v) == 0 {
+		fail("matches none of the schemas of anyOf")
+	}
+	if len(s.OneOf) > 0 {
+		if n := matching(root, s.OneOf, v); n != 1 {
+			fail("matches %d of the schemas of oneOf, expected exactly one", n)
+		}
+	}
+}
+
+func (s *jsonSchema) validateObject(root *jsonSchema, path string, object map[string]any, violations *[]string) {
+	for _, name := range s.Required {
+		if _, ok := object[name]; !ok {
+			*violations = append(*violations, fmt.Sprintf("%s: missing required property '%s'", path, name))
+		}
+	}
+
+	var additional *jsonSchema
+	allowAdditional := true
+	if len(s.AdditionalProperties) > 0 {
+		if err := json.Unmarshal(s.AdditionalProperties, &allowAdditional); err != nil {
+			allowAdditional = true
+			additional = &jsonSchema{}
+			_ = json.Unmarshal(s.AdditionalProperties, additional)
+		}
+	}
+
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names) // violations in a stable order
+	for _, name := range names {
+		propertyPath := path + "." + name
+		switch property, ok := s.Properties[name]; {
+		case ok:
+			property.validate(root, propertyPath, object[name], violations)
+		case !allowAdditional:
+			*violations = append(*violations, fmt.Sprintf("%s: unexpected property '%s'", path, name))
+		case additional != nil:
+			additional.validate(root, propertyPath, object[name], violations)
+		}
+	}
+}
+
+// resolve returns the schema of a local reference like #/$defs/point.
+func (s *jsonSchema) resolve(ref string) (*jsonSchema, bool) {
+	if ref == "#" {
+		return s, true
+	}
+	for prefix, defs := range map[string]map[string]*jsonSchema{"#/$defs/": s.Defs, "#/definitions/": s.Definitions} {
+		if name, ok := strings.CutPrefix(ref, prefix); ok {
+			def, ok := defs[name]
+			return def, ok && def != nil
+		}
+	}
+	return nil, false
+}
+
+// matching returns the number of schemas the value is valid against.
+func matching(root *jsonSchema, schemas []*jsonSchema, v any) int {
+	n := 0
+	for _, s := range schemas {
+		var violations []string
+		s.validate(root, "$", v, &violations)
+		if len(violations) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func isSchemaType(v any, t string) bool {
+	switch t {
+	case "integer":
+		f, ok := v.(float64)
+		return ok && f == math.Trunc(f)
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	default:
+		return jsonTypeName(v) == t
+	}
+}
+
+func jsonTypeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+func jsonText(v any) string {
+	data, _ := json.Marshal(v)
+	return _max100Chars(string(data))
+}
+
+// schemaTool is the tool the response is the input of, for models without
+// structured outputs. Claude is forced to call it with tool_choice; the call ends
+// the response instead of being dispatched.
+type schemaTool struct {
+	schema *responseSchema
+}
+
+func (t *schemaTool) Name() string { return schemaToolName }
+
+func (t *schemaTool) InputSchema() json.RawMessage { return t.schema.Raw }
+
+func (t *schemaTool) Definition(string) ToolDefinition {
+	return ToolDefinition{
+		Name:        schemaToolName,
+		Description: "Respond with the complete result as the input of this tool. The input must match the JSON schema.",
+		InputSchema: t.schema.Raw,
+	}
+}
+
+func (t *schemaTool) Execute(input json.RawMessage) ToolResult {
+	if violations := t.schema.Validate(input); len(violations) > 0 {
+		return ToolResult{Content: strings.Join(violations, "\n"), IsError: true}
+	}
+	return ToolResult{Content: "ok"}
+}
+
+// configureSchema constrains the response to the schema of --schema or of the prompt
+// template: with structured outputs of the model, otherwise by forcing a call of
+// schemaTool, which other tools and thinking cannot be combined with.
+func (b *Bods) configureSchema() error {
+	b.schema = nil
+	var source any = b.Config.Schema
+	name := b.Config.PromptTemplate
+	if b.Config.Schema == "" {
+		source = nil
+		for _, p := range b.Config.Prompts {
+			if p.Name == b.Config.PromptTemplate && p.Schema != nil {
+				source = p.Schema
+			}
+		}
+		if source == nil {
+			return nil
+		}
+	}
+	if b.chat != nil {
+		return errors.New("a response schema is not supported in chat mode")
+	}
+
+	schema, err := loadResponseSchema(source, cmp.Or(name, "response"))
+	if err != nil {
+		return err
+	}
+	schema.Native = lookupModel(b.Config.ModelID).StructuredOutputs
+	b.Config.Format = false // JSON, not markdown
+
+	if schema.Native {
+		if paramsMessagesAPI.OutputConfig == nil {
+			paramsMessagesAPI.OutputConfig = &OutputConfig{}
+		}
+		paramsMessagesAPI.OutputConfig.Format = &OutputFormat{Type: OutputFormatJSONSchema, Schema: schema.Raw}
+		logger.Printf("structured output with schema %s\n", schema.Name)
+	} else {
+		if toolRegistry.Len() > 0 {
+			return fmt.Errorf("%s has no structured outputs, the schema cannot be combined with other tools", b.Config.ModelID)
+		}
+		if paramsMessagesAPI.Thinking != nil {
+			return fmt.Errorf("%s has no structured outputs, the schema cannot be combined with thinking", b.Config.ModelID)
+		}
+		if !slices.Equal(schema.root.Type, schemaTypes{"object"}) {
+			return fmt.Errorf("%s has no structured outputs, the schema must describe an object to be used as tool input", b.Config.ModelID)
+		}
+		toolRegistry.Register(&schemaTool{schema: schema})
+		paramsMessagesAPI.ToolChoice = &ToolChoice{Type: ToolChoiceTool, Name: schemaToolName}
+		logger.Printf("structured output with schema %s as input of the forced tool %s\n", schema.Name, schemaToolName)
+	}
+	b.schema = schema
+	return nil
+}
+
+// structuredOutput returns the JSON of a response: the input of the schemaTool call,
+// or the text of the response with structured outputs.
+func structuredOutput(m Message) (json.RawMessage, *Content) {
+	var text strings.Builder
+	for i, c := range m.Content {
+		switch c.Type {
+		case MessageContentTypeToolUse:
+			if c.Name == schemaToolName {
+				return c.Input, &m.Content[i]
+			}
+		case MessageContentTypeText:
+			text.WriteString(strings.TrimSpace(c.Text))
+		}
+	}
+	return json.RawMessage(text.String()), nil
+}
+
+// checkStructuredOutput validates the response against the schema. If it does not
+// match, the model is asked once to repair it with --schema-repair; otherwise the
+// run fails.
+func (b *Bods) checkStructuredOutput() tea.Cmd {
+	data, toolUse := structuredOutput(messages[len(messages)-1])
+	violations := b.schema.Validate(data)
+	if len(violations) == 0 {
+		b.structured = data
+		return nil
+	}
+	err := fmt.Errorf("the response does not match the schema %s: %s", b.schema.Name, strings.Join(violations, "; "))
+	logger.Println(err)
+	if !b.Config.SchemaRepair || b.schemaRepaired {
+		return func() tea.Msg { return bodsError{err, "Invalid structured output."} }
+	}
+
+	b.schemaRepaired = true
+	b.Output, b.glamOutput = "", ""
+	feedback := "The response does not match the JSON schema:\n" + strings.Join(violations, "\n") + "\nRespond again with the complete, corrected result."
+	repair := Content{Type: MessageContentTypeText, Text: feedback}
+	if toolUse != nil {
+		repair = Content{Type: MessageContentTypeToolResult, ToolUseID: toolUse.ID, Content: feedback, IsError: true}
+	}
+	messages = append(messages, Message{Role: MessageRoleUser, Content: []Content{repair}})
+	logger.Println("asking the model to repair the structured output")
+	return b.invokeModel
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSchema = `{
+	"type": "object",
+	"properties": {
+		"title": {"type": "string", "maxLength": 10},
+		"tags": {"type": "array", "items": {"$ref": "#/$defs/tag"}, "minItems": 1},
+		"rating": {"type": ["integer", "null"], "minimum": 1, "maximum": 5}
+	},
+	"required": ["title", "tags"],
+	"additionalProperties": false,
+	"$defs": {"tag": {"type": "string", "enum": ["go", "aws"]}}
+}`
+
+func TestResponseSchemaValidate(t *testing.T) {
+	schema, err := loadResponseSchema(testSchema, "review")
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		data       string
+		violations []string
+	}{
+		{`{"title": "bods", "tags": ["go"], "rating": 4}`, nil},
+		{`{"title": "bods", "tags": ["go", "aws"], "rating": null}`, nil},
+		{`{"title": "a long title", "tags": [], "rating": 4.5, "extra": true}`, []string{
+			"$: unexpected property 'extra'",
+			"$.rating: expected integer or null, got number",
+			"$.tags: expected at least 1 items, got 0",
+			"$.title: expected at most 10 characters, got 12",
+		}},
+		{`{"tags": ["rust"], "rating": 0}`, []string{
+			"$: missing required property 'title'",
+			"$.rating: 0 is less than the minimum 1",
+			`$.tags[0]: "rust" is not one of the allowed values`,
+		}},
+		{`["go"]`, []string{"$: expected object, got array"}},
+		{`Sure, here is the JSON`, []string{"the response is not valid JSON: invalid character 'S' looking for beginning of value"}},
+	} {
+		assert.Equal(t, tt.violations, schema.Validate([]byte(tt.data)), tt.data)
+	}
+}
+
+func TestLoadResponseSchema(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "review.json")
+	require.NoError(t, os.WriteFile(file, []byte(testSchema), 0o600))
+	schema, err := loadResponseSchema(file, "")
+	require.NoError(t, err)
+	assert.Equal(t, "review", schema.Name)
+	assert.NotContains(t, string(schema.Raw), "\n") // compacted
+
+	// a schema in bods.yaml
+	schema, err = loadResponseSchema(map[string]any{"type": "object", "required": []any{"summary"}}, "summarize-structured")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"$: missing required property 'summary'"}, schema.Validate([]byte(`{}`)))
+
+	_, err = loadResponseSchema(filepath.Join(t.TempDir(), "missing.json"), "")
+	assert.Error(t, err)
+	_, err = loadResponseSchema(`{"type": 1}`, "")
+	assert.Error(t, err)
+}
+
+func TestConfigureSchema(t *testing.T) {
+	b := &Bods{Config: &Config{ModelID: "anthropic.claude-sonnet-4-6", Schema: testSchema, Format: true}}
+	paramsMessagesAPI = NewAnthropicClaudeMessagesInferenceParameters()
+	toolRegistry = NewToolRegistry()
+	require.NoError(t, b.configureSchema())
+	assert.True(t, b.schema.Native)
+	assert.False(t, b.Config.Format)
+	require.NotNil(t, paramsMessagesAPI.OutputConfig)
+	assert.Equal(t, OutputFormatJSONSchema, paramsMessagesAPI.OutputConfig.Format.Type)
+	assert.Nil(t, paramsMessagesAPI.ToolChoice)
+
+	// converse has structured outputs as part of the API
+	input, err := converseStreamInput(modelRequest{ModelID: b.Config.ModelID, Params: paramsMessagesAPI})
+	require.NoError(t, err)
+	structure := input.OutputConfig.TextFormat.Structure.(*types.OutputFormatStructureMemberJsonSchema)
+	assert.JSONEq(t, testSchema, aws.ToString(structure.Value.Schema))
+	assert.Nil(t, input.AdditionalModelRequestFields)
+
+	// without structured outputs the schema is the input of a forced tool call
+	b.Config.ModelID = "anthropic.claude-sonnet-4-20250514-v1:0"
+	paramsMessagesAPI = NewAnthropicClaudeMessagesInferenceParameters()
+	require.NoError(t, b.configureSchema())
+	assert.False(t, b.schema.Native)
+	assert.Nil(t, paramsMessagesAPI.OutputConfig)
+	assert.Equal(t, &ToolChoice{Type: ToolChoiceTool, Name: schemaToolName}, paramsMessagesAPI.ToolChoice)
+	assert.Equal(t, ToolResult{Content: "ok"}, toolRegistry.Dispatch(schemaToolName, []byte(`{"title": "bods", "tags": ["go"]}`)))
+	assert.True(t, toolRegistry.Dispatch(schemaToolName, []byte(`{}`)).IsError)
+
+	input, err = converseStreamInput(modelRequest{ModelID: b.Config.ModelID, Params: paramsMessagesAPI})
+	require.NoError(t, err)
+	assert.Equal(t, schemaToolName, aws.ToString(input.ToolConfig.ToolChoice.(*types.ToolChoiceMemberTool).Value.Name))
+
+	paramsMessagesAPI.Thinking = NewThinkingConfig()
+	toolRegistry = NewToolRegistry()
+	assert.ErrorContains(t, b.configureSchema(), "thinking")
+
+	toolRegistry.Register(NewBashTool(b.Config))
+	paramsMessagesAPI.Thinking = nil
+	assert.ErrorContains(t, b.configureSchema(), "other tools")
+}
+
+func TestStructuredOutput(t *testing.T) {
+	data, toolUse := structuredOutput(Message{Role: MessageRoleAssistant, Content: []Content{
+		{Type: MessageContentTypeThinking, Thinking: "hmm"},
+		{Type: MessageContentTypeText, Text: ` {"title": "bods"}`},
+	}})
+	assert.JSONEq(t, `{"title": "bods"}`, string(data))
+	assert.Nil(t, toolUse)
+
+	data, toolUse = structuredOutput(Message{Role: MessageRoleAssistant, Content: []Content{
+		{Type: MessageContentTypeToolUse, ID: "toolu_1", Name: schemaToolName, Input: []byte(`{"title": "bods"}`)},
+	}})
+	assert.JSONEq(t, `{"title": "bods"}`, string(data))
+	require.NotNil(t, toolUse)
+	assert.Equal(t, "toolu_1", toolUse.ID)
+}
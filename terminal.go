@@ -26,3 +26,12 @@ var stderrRenderer = sync.OnceValue(
 var stderrStyles = sync.OnceValue(func() styles {
 	return makeStyles(stderrRenderer())
 })
+
+var stdoutRenderer = sync.OnceValue(
+	func() *lipgloss.Renderer {
+		return lipgloss.DefaultRenderer()
+	})
+
+var stdoutStyles = sync.OnceValue(func() styles {
+	return makeStyles(stdoutRenderer())
+})
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+// Tool is a client tool that Claude can call. Enabled tools are registered with
+// the toolRegistry, which adds their definitions to the request and dispatches
+// tool_use blocks of the response to the tool by name.
+type Tool interface {
+	// Name is the canonical name of the tool.
+	Name() string
+	// InputSchema returns the JSON schema of the tool input.
+	InputSchema() json.RawMessage
+	// Definition returns the definition sent in paramsMessagesAPI.Tools for the given
+	// model; Anthropic-defined tools like the text editor are versioned per model.
+	Definition(modelID string) ToolDefinition
+	// Execute runs the tool with the input of a tool_use block.
+	Execute(input json.RawMessage) ToolResult
+}
+
+// ToolDefinition is a tool definition as sent to Claude's API. Anthropic-defined
+// tools have a Type and no InputSchema, client tools have no Type but an InputSchema.
+type ToolDefinition struct {
+	Type        string          `json:"type,omitempty"` // e.g. text_editor_20250728
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema,omitempty"`
+
+	MaxCharacters int `json:"max_characters,omitempty"` // text editor 20250728: truncation of file views
+
+	CacheControl *CacheControl `json:"cache_control,omitempty"` // set on the last tool by planCache
+}
+
+// ToolResult is the outcome of a tool call, sent back to Claude as tool_result.
+type ToolResult struct {
+	Content string `json:"content"`
+	IsError bool   `json:"is_error,omitempty"`
+}
+
+// ToolRegistry holds the tools enabled for the current request.
+type ToolRegistry struct {
+	tools         []Tool
+	byName        map[string]Tool // by definition name, which may differ per model
+	declaredTools []Tool          // definitions are sent, but the tools are not executed, see Declare
+	declaredNames map[string]bool // definition names of the declared tools
+}
+
+// toolRegistry holds the enabled tools; rebuilt by configureInferenceParameters.
+var toolRegistry = NewToolRegistry()
+
+func NewToolRegistry() *ToolRegistry {
+	return &ToolRegistry{byName: make(map[string]Tool), declaredNames: make(map[string]bool)}
+}
+
+// Register enables a tool; registering a tool with the same name replaces it.
+func (r *ToolRegistry) Register(t Tool) {
+	for i, registered := range r.tools {
+		if registered.Name() == t.Name() {
+			r.tools[i] = t
+			r.byName[t.Name()] = t
+			return
+		}
+	}
+	r.tools = append(r.tools, t)
+	r.byName[t.Name()] = t
+}
+
+// Declare adds the definition of a tool that is not enabled, e.g. for the tool_use
+// blocks of a continued conversation; a tool_use of it is answered with an error.
+func (r *ToolRegistry) Declare(t Tool) {
+	if !slices.ContainsFunc(r.declaredTools, func(d Tool) bool { return d.Name() == t.Name() }) {
+		r.declaredTools = append(r.declaredTools, t)
+	}
+}
+
+// Get returns the tool with the given canonical or definition name.
+func (r *ToolRegistry) Get(name string) (Tool, bool) {
+	t, ok := r.byName[name]
+	return t, ok
+}
+
+// Len returns the number of registered tools.
+func (r *ToolRegistry) Len() int {
+	return len(r.tools)
+}
+
+// Definitions returns the definitions of all registered and declared tools for the
+// given model.
+func (r *ToolRegistry) Definitions(modelID string) []ToolDefinition {
+	definitions := make([]ToolDefinition, 0, len(r.tools)+len(r.declaredTools))
+	for _, t := range r.sent() {
+		d := t.Definition(modelID)
+		if _, ok := r.byName[t.Name()]; ok {
+			r.byName[d.Name] = t
+		} else {
+			r.declaredNames[d.Name] = true
+		}
+		definitions = append(definitions, d)
+	}
+	return definitions
+}
+
+// sent returns the tools whose definitions are sent: the registered tools followed
+// by the declared ones that are not registered as well.
+func (r *ToolRegistry) sent() []Tool {
+	tools := slices.Clone(r.tools)
+	for _, t := range r.declaredTools {
+		if _, ok := r.byName[t.Name()]; !ok {
+			tools = append(tools, t)
+		}
+	}
+	return tools
+}
+
+// Specs returns the definitions of all registered tools for the given model as
+// client tools with an input schema, for APIs without Anthropic-defined tools
+// like Converse.
+func (r *ToolRegistry) Specs(modelID string) []ToolDefinition {
+	definitions := r.Definitions(modelID)
+	tools := r.sent()
+	for i, d := range definitions {
+		if d.Type == "" {
+			continue
+		}
+		definitions[i] = ToolDefinition{
+			Name:        d.Name,
+			Description: "Anthropic-defined tool " + d.Type,
+			InputSchema: tools[i].InputSchema(),
+		}
+	}
+	return definitions
+}
+
+// Dispatch executes the tool_use block with the given tool name and input.
+func (r *ToolRegistry) Dispatch(name string, input json.RawMessage) ToolResult {
+	t, ok := r.Get(name)
+	if !ok && r.declaredNames[name] {
+		logger.Printf("tool_use for declared tool '%s'\n", name)
+		return ToolResult{Content: fmt.Sprintf("Error: the tool '%s' is not enabled in this run", name), IsError: true}
+	}
+	if !ok {
+		logger.Printf("tool_use for unknown tool '%s'\n", name)
+		return ToolResult{Content: fmt.Sprintf("Error: unknown tool '%s'", name), IsError: true}
+	}
+	if len(input) == 0 {
+		input = json.RawMessage("{}")
+	}
+	logger.Printf("dispatching tool_use to tool '%s' input=%s\n", t.Name(), string(input))
+	return t.Execute(input)
+}
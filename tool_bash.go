@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	BashToolName = "bash"
+
+	defaultBashTimeout   = 2 * time.Minute
+	defaultBashMaxOutput = 30000 // bytes
+)
+
+// BashConfig holds the settings of the bash tool from the 'bash' section of bods.yaml.
+type BashConfig struct {
+	Timeout   time.Duration `koanf:"timeout"`    // commands running longer are killed
+	MaxOutput int           `koanf:"max_output"` // bytes of output returned to Claude
+	Allow     []string      `koanf:"allow"`      // if not empty, only commands starting with one of these are run
+	Deny      []string      `koanf:"deny"`       // commands starting with one of these are never run
+}
+
+// defaultBashDeny are the commands never run unless the 'bash' section of bods.yaml
+// sets its own deny list; they also apply with a bods.yaml without a 'bash' section.
+var defaultBashDeny = []string{
+	"sudo", "su", "doas",
+	"rm -rf", "rm -fr",
+	"mkfs", "dd",
+	"shutdown", "reboot",
+	"git push", "git reset --hard", "git clean",
+}
+
+func newBashConfig() BashConfig {
+	return BashConfig{
+		Timeout:   defaultBashTimeout,
+		MaxOutput: defaultBashMaxOutput,
+		Deny:      slices.Clone(defaultBashDeny),
+	}
+}
+
+const bashInputSchema = `{
+  "type": "object",
+  "properties": {
+    "command": {"type": "string", "description": "The shell command to run"}
+  },
+  "required": ["command"]
+}`
+
+// BashTool lets Claude run shell commands in the working directory, e.g. to build
+// and test its own edits. Every command is checked against the allow/deny list and
+// has to be confirmed by the user unless confirmation is disabled with --yes.
+type BashTool struct {
+	config  BashConfig
+	dir     string
+	confirm bool
+}
+
+func NewBashTool(cfg *Config) *BashTool {
+	dir, err := os.Getwd()
+	if err != nil {
+		logger.Println("NewBashTool: could not get working directory:", err)
+	}
+	return &BashTool{config: cfg.Bash, dir: dir, confirm: !cfg.Yes}
+}
+
+// Name implements Tool.
+func (t *BashTool) Name() string {
+	return BashToolName
+}
+
+// InputSchema implements Tool.
+func (t *BashTool) InputSchema() json.RawMessage {
+	return json.RawMessage(bashInputSchema)
+}
+
+// Definition implements Tool.
+func (t *BashTool) Definition(_ string) ToolDefinition {
+	description := fmt.Sprintf("Run a shell command with bash in the working directory %s and return its combined stdout and stderr. "+
+		"Use it to build, test, lint or search the project. Commands are run non-interactively, time out after %s, "+
+		"and output longer than %d bytes is truncated in the middle. The user may reject a command.",
+		t.dir, t.config.Timeout, t.config.MaxOutput)
+	if len(t.config.Allow) > 0 {
+		description += " Only these commands are allowed: " + strings.Join(t.config.Allow, ", ") + "."
+	}
+	return ToolDefinition{
+		Name:        BashToolName,
+		Description: description,
+		InputSchema: t.InputSchema(),
+	}
+}
+
+// Execute implements Tool.
+func (t *BashTool) Execute(input json.RawMessage) ToolResult {
+	var call struct {
+		Command string `json:"command"`
+	}
+	if err := json.Unmarshal(input, &call); err != nil {
+		return ToolResult{Content: "Error parsing tool call: " + err.Error(), IsError: true}
+	}
+	if strings.TrimSpace(call.Command) == "" {
+		return ToolResult{Content: "Error: parameter 'command' is required", IsError: true}
+	}
+
+	if err := t.checkCommand(call.Command); err != nil {
+		logger.Printf("bash tool: rejected command '%s': %v\n", call.Command, err)
+		return ToolResult{Content: "Error: " + err.Error(), IsError: true}
+	}
+
+	if t.confirm {
+		ok, err := confirm("Run this command?", call.Command)
+		if err != nil {
+			return ToolResult{Content: fmt.Sprintf("Error: the command was not run, confirmation failed: %v. Run bods with --yes to run commands without confirmation.", err), IsError: true}
+		}
+		if !ok {
+			return ToolResult{Content: "The user rejected running this command.", IsError: true}
+		}
+	}
+
+	return t.run(call.Command)
+}
+
+func (t *BashTool) run(command string) ToolResult {
+	ctx, cancel := context.WithTimeout(context.Background(), t.config.Timeout)
+	defer cancel()
+
+	shell := "bash"
+	if _, err := exec.LookPath(shell); err != nil {
+		shell = "sh"
+	}
+
+	start := time.Now()
+	cmd := exec.CommandContext(ctx, shell, "-c", command) // #nosec G204 - running commands is the purpose of this tool
+	cmd.Dir = t.dir
+	cmd.WaitDelay = time.Second // don't wait for background processes still holding the output pipe
+	out, err := cmd.CombinedOutput()
+	logger.Printf("bash tool: ran '%s' in %s, err=%v\n", command, time.Since(start), err)
+
+	content := truncateOutput(string(out), t.config.MaxOutput)
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return ToolResult{Content: fmt.Sprintf("%s\nError: command timed out after %s and was killed", content, t.config.Timeout), IsError: true}
+	case errors.As(err, &exitErr):
+		return ToolResult{Content: fmt.Sprintf("%s\nExit code: %d", content, exitErr.ExitCode()), IsError: true}
+	case err != nil:
+		return ToolResult{Content: fmt.Sprintf("%s\nError: %v", content, err), IsError: true}
+	}
+
+	if strings.TrimSpace(content) == "" {
+		content = "(no output)"
+	}
+	return ToolResult{Content: content}
+}
+
+// checkCommand checks every command of a command line, e.g. both commands of
+// 'go vet ./... && go test ./...', against the deny and allow list.
+func (t *BashTool) checkCommand(commandLine string) error {
+	for _, command := range splitCommandLine(commandLine) {
+		for _, denied := range t.config.Deny {
+			if matchesCommand(command, denied) {
+				return fmt.Errorf("the command '%s' is not allowed (denied by '%s' in the bods.yaml bash settings)", command, denied)
+			}
+		}
+		if len(t.config.Allow) == 0 {
+			continue
+		}
+		allowed := false
+		for _, a := range t.config.Allow {
+			if matchesCommand(command, a) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("the command '%s' is not allowed, allowed commands are: %s", command, strings.Join(t.config.Allow, ", "))
+		}
+	}
+	return nil
+}
+
+// splitCommandLine splits a shell command line into its single commands at
+// operators like ;, &&, || and |, and at subshells and command substitutions,
+// which are checked as commands of their own. Quoted operators are kept and
+// leading environment variable assignments are removed.
+func splitCommandLine(commandLine string) []string {
+	var commands []string
+	var current strings.Builder
+	flush := func() {
+		words := strings.Fields(strings.TrimSuffix(strings.TrimSpace(current.String()), "$"))
+		for len(words) > 0 && strings.Contains(words[0], "=") && !strings.HasPrefix(words[0], "=") {
+			words = words[1:] // e.g. GOFLAGS=-mod=mod go build
+		}
+		if len(words) > 0 {
+			commands = append(commands, strings.Join(words, " "))
+		}
+		current.Reset()
+	}
+
+	runes := []rune(commandLine)
+	var quote rune // ' or " while inside quotes
+	for i, r := range runes {
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			}
+		case r == '\'' && quote == 0, r == '"' && quote == 0:
+			quote = r
+		case r == '"' && quote == '"':
+			quote = 0
+		case r == '`', r == '(' && i > 0 && runes[i-1] == '$', r == ')':
+			flush() // command substitution, also within double quotes
+			continue
+		case quote == '"':
+		case strings.ContainsRune(";|\n(){}", r):
+			flush()
+			continue
+		case r == '&':
+			redirect := (i > 0 && strings.ContainsRune("<>", runes[i-1])) || (i+1 < len(runes) && runes[i+1] == '>')
+			if !redirect { // e.g. 2>&1 or &> file
+				flush()
+				continue
+			}
+		}
+		current.WriteRune(r)
+	}
+	flush()
+
+	return commands
+}
+
+// matchesCommand reports whether command starts with the words of pattern, e.g.
+// 'git push origin main' matches 'git push'. Quotes are ignored and the program
+// is also matched by its base name, so '"/bin/rm" -rf x' matches 'rm'.
+func matchesCommand(command, pattern string) bool {
+	words := strings.Fields(command)
+	patternWords := strings.Fields(pattern)
+	if len(patternWords) == 0 || len(words) < len(patternWords) {
+		return false
+	}
+	for i, p := range patternWords {
+		word := strings.Trim(words[i], `"'`)
+		if i == 0 {
+			word = filepath.Base(word)
+		}
+		if word != p {
+			return false
+		}
+	}
+	return true
+}
+
+// truncateOutput shortens s to about maxLength bytes by cutting out the middle,
+// as both the start and the end (e.g. a summary or the error) are of interest.
+func truncateOutput(s string, maxLength int) string {
+	if maxLength <= 0 || len(s) <= maxLength {
+		return s
+	}
+	head, tail := maxLength/2, len(s)-maxLength/2
+	for head > 0 && !utf8.RuneStart(s[head]) { // don't cut a multi-byte character in half
+		head--
+	}
+	for tail < len(s) && !utf8.RuneStart(s[tail]) {
+		tail++
+	}
+	return fmt.Sprintf("%s\n\n... [%d bytes truncated] ...\n\n%s", s[:head], tail-head, s[tail:])
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitCommandLine(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{input: "go test ./...", expected: []string{"go test ./..."}},
+		{input: "go vet ./... && go test ./... 2>&1 | tail -5", expected: []string{"go vet ./...", "go test ./... 2>&1", "tail -5"}},
+		{input: "GOFLAGS=-mod=mod go build; echo done", expected: []string{"go build", "echo done"}},
+		{input: `grep -n "a|b;c" main.go`, expected: []string{`grep -n "a|b;c" main.go`}},
+		{input: "echo $(rm -rf /tmp/x)", expected: []string{"echo", "rm -rf /tmp/x"}},
+		{input: "(cd sub && make) &", expected: []string{"cd sub", "make"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			assert.Equal(t, tt.expected, splitCommandLine(tt.input))
+		})
+	}
+}
+
+func TestBashToolCheckCommand(t *testing.T) {
+	tool := &BashTool{config: BashConfig{Deny: []string{"sudo", "git push"}}}
+	assert.NoError(t, tool.checkCommand("git status && go test ./..."))
+	assert.Error(t, tool.checkCommand("go build && git push origin main"))
+	assert.Error(t, tool.checkCommand(`"/usr/bin/sudo" ls`))
+
+	tool.config.Allow = []string{"go", "git status"}
+	assert.NoError(t, tool.checkCommand("go vet ./... && git status"))
+	assert.Error(t, tool.checkCommand("go test ./... | tee out.txt"))
+}
+
+func TestBashToolExecute(t *testing.T) {
+	tool := &BashTool{config: BashConfig{Timeout: 500 * time.Millisecond, MaxOutput: 100}, dir: t.TempDir()}
+
+	result := tool.Execute(json.RawMessage(`{"command": "echo hello"}`))
+	assert.False(t, result.IsError)
+	assert.Equal(t, "hello\n", result.Content)
+
+	result = tool.Execute(json.RawMessage(`{"command": "echo failed >&2; exit 3"}`))
+	assert.True(t, result.IsError)
+	assert.Contains(t, result.Content, "failed")
+	assert.Contains(t, result.Content, "Exit code: 3")
+
+	result = tool.Execute(json.RawMessage(`{"command": "sleep 5"}`))
+	assert.True(t, result.IsError)
+	assert.Contains(t, result.Content, "timed out")
+
+	result = tool.Execute(json.RawMessage(`{"command": "seq 1 1000"}`))
+	assert.Contains(t, result.Content, "bytes truncated")
+	assert.True(t, strings.HasSuffix(result.Content, "1000\n"))
+}
+
+func TestTruncateOutput(t *testing.T) {
+	assert.Equal(t, "short", truncateOutput("short", 10))
+
+	out := truncateOutput("a"+strings.Repeat("äö", 20), 10)
+	assert.True(t, utf8.ValidString(out), "a multi-byte character is not cut in half")
+	assert.True(t, strings.HasPrefix(out, "aäö\n"))
+	assert.Contains(t, out, "[72 bytes truncated]")
+	assert.True(t, strings.HasSuffix(out, "\näö"))
+}
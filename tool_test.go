@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type echoTool struct{}
+
+func (echoTool) Name() string { return "echo" }
+
+func (echoTool) InputSchema() json.RawMessage {
+	return json.RawMessage(`{"type":"object","properties":{"text":{"type":"string"}}}`)
+}
+
+func (t echoTool) Definition(_ string) ToolDefinition {
+	return ToolDefinition{Name: t.Name(), InputSchema: t.InputSchema()}
+}
+
+func (echoTool) Execute(input json.RawMessage) ToolResult {
+	var in struct{ Text string }
+	_ = json.Unmarshal(input, &in)
+	return ToolResult{Content: in.Text}
+}
+
+func TestToolRegistry(t *testing.T) {
+	r := NewToolRegistry()
+	r.Register(echoTool{})
+	r.Register(NewTextEditorTool())
+	r.Register(echoTool{}) // replaces, does not add
+
+	defs := r.Definitions(ClaudeV37Sonnet.String())
+	assert.Len(t, defs, 2)
+	assert.Equal(t, "echo", defs[0].Name)
+	assert.Equal(t, TextEditorToolNameLegacy, defs[1].Name)
+
+	assert.Equal(t, ToolResult{Content: "hi"}, r.Dispatch("echo", json.RawMessage(`{"text":"hi"}`)))
+
+	// the text editor is dispatched by its model specific definition name
+	result := r.Dispatch(TextEditorToolNameLegacy, json.RawMessage(`{"command":"view","path":"relative"}`))
+	assert.True(t, result.IsError)
+	assert.Contains(t, result.Content, "not an absolute path")
+
+	result = r.Dispatch("unknown", nil)
+	assert.True(t, result.IsError)
+}
+
+func TestToolRegistryDeclare(t *testing.T) {
+	r := NewToolRegistry()
+	r.Register(echoTool{})
+	r.Declare(echoTool{}) // registered as well, sent once
+	r.Declare(NewTextEditorTool())
+
+	defs := r.Definitions(ClaudeV37Sonnet.String())
+	assert.Len(t, defs, 2)
+	assert.Equal(t, TextEditorToolNameLegacy, defs[1].Name)
+	assert.Equal(t, 1, r.Len())
+
+	result := r.Dispatch(TextEditorToolNameLegacy, json.RawMessage(`{"command":"view","path":"/etc/hosts"}`))
+	assert.True(t, result.IsError)
+	assert.Contains(t, result.Content, "not enabled")
+	assert.Equal(t, ToolResult{Content: "hi"}, r.Dispatch("echo", json.RawMessage(`{"text":"hi"}`)))
+}
@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"unicode/utf8"
 )
 
 //
@@ -29,6 +31,29 @@ const (
 // SnippetLines defines how many lines to show before/after edits
 const SnippetLines = 4
 
+// Defaults of the text editor settings
+const (
+	defaultTextEditorMaxCharacters = 30000 // characters of a file returned by a view
+	defaultTextEditorMaxDepth      = 2     // levels of subdirectories listed by a directory view
+	defaultTextEditorMaxEntries    = 500   // entries listed by a directory view
+)
+
+// TextEditorConfig holds the settings of the text editor tool from the 'text_editor'
+// section of bods.yaml.
+type TextEditorConfig struct {
+	MaxCharacters int `koanf:"max_characters"` // longer file views are truncated; 0 means no limit
+	MaxDepth      int `koanf:"max_depth"`      // levels of subdirectories listed by a directory view
+	MaxEntries    int `koanf:"max_entries"`    // entries listed by a directory view
+}
+
+func newTextEditorConfig() TextEditorConfig {
+	return TextEditorConfig{
+		MaxCharacters: defaultTextEditorMaxCharacters,
+		MaxDepth:      defaultTextEditorMaxDepth,
+		MaxEntries:    defaultTextEditorMaxEntries,
+	}
+}
+
 // Command types supported by the editor
 type EditorCommand string
 
@@ -46,13 +71,12 @@ const (
 
 // TextEditorTool represents the text editor tool that enables Claude to edit files
 type TextEditorTool struct {
-	fileHistory map[string][]string // Path to history of file contents for undo
-}
-
-// TextEditorToolDefinition represents the tool definition to be sent to Claude's API
-type TextEditorToolDefinition struct {
-	Type string `json:"type"` // text_editor_20241022 or text_editor_20250124
-	Name string `json:"name"` // Always "str_replace_editor"
+	config       TextEditorConfig
+	fileHistory  map[string][]string // Path to history of file contents for undo
+	confirmEdits bool                // show a diff and ask the user before writing files (--confirm-edits)
+	workspace    *Workspace          // files outside of the workspace are not accessed
+	journal      *EditJournal        // records all edits for 'bods edits' and 'bods undo'
+	acceptAll    bool                // the user accepted all further edits
 }
 
 // TextEditorToolCall represents a tool call from Claude
@@ -67,11 +91,21 @@ type TextEditorToolCall struct {
 	Parameters map[string]any `json:"-"` // For all other parameters
 }
 
-// TextEditorToolResult represents the result to send back to Claude
-type TextEditorToolResult struct {
-	Content string `json:"content"`
-	IsError bool   `json:"is_error,omitempty"`
-}
+// textEditorInputSchema describes the input of the text editor tool. The schema is
+// built into the model, it is not sent with the tool definition.
+const textEditorInputSchema = `{
+  "type": "object",
+  "properties": {
+    "command": {"type": "string", "enum": ["view", "create", "str_replace", "insert", "undo_edit"]},
+    "path": {"type": "string", "description": "Absolute path to file or directory"},
+    "file_text": {"type": "string"},
+    "view_range": {"type": "array", "items": {"type": "integer"}, "minItems": 2, "maxItems": 2},
+    "old_str": {"type": "string"},
+    "new_str": {"type": "string"},
+    "insert_line": {"type": "integer"}
+  },
+  "required": ["command", "path"]
+}`
 
 //
 // Tool Creation and Global Instance
@@ -80,35 +114,26 @@ type TextEditorToolResult struct {
 // NewTextEditorTool creates a new text editor tool
 func NewTextEditorTool() *TextEditorTool {
 	return &TextEditorTool{
+		config:      newTextEditorConfig(),
 		fileHistory: make(map[string][]string),
 	}
 }
 
 // NewTextEditorToolDefinition creates a new tool definition based on the Claude model
-func NewTextEditorToolDefinition(model string) TextEditorToolDefinition {
-	// Default to Claude 3.7 version
+func NewTextEditorToolDefinition(model string) ToolDefinition {
+	// Default to Claude 3.7 version; the version per model is set in the model registry
 	toolType := TextEditor20250124
-	toolName := TextEditorToolNameLegacy
-
-	// Use specific version based on model
-	modelID := normalizeToModelID(model)
-
-	// Claude 3.5 Sonnet models use the 20241022 version
-	if modelID == ClaudeV35Sonnet.String() || modelID == ClaudeV35SonnetV2.String() {
-		toolType = TextEditor20241022
-		toolName = TextEditorToolNameLegacy
+	if m := lookupModel(model); m.TextEditor != "" {
+		toolType = m.TextEditor
 	}
 
-	// Claude 4.x models (including 4.5, 4.6, 4.7, and 4.8) use the 20250728 version with new name
-	if modelID == ClaudeV4Sonnet.String() || modelID == ClaudeV4Opus.String() ||
-		modelID == ClaudeV45Sonnet.String() || modelID == ClaudeV45Haiku.String() ||
-		modelID == ClaudeV45Opus.String() || modelID == ClaudeV46Opus.String() ||
-		modelID == ClaudeV47Opus.String() || modelID == ClaudeV48Opus.String() {
-		toolType = TextEditor20250728
-		toolName = TextEditorToolNameNew
+	// Claude 3.5 and 3.7 use the legacy name, Claude 4.x models the new one
+	toolName := TextEditorToolNameNew
+	if toolType == TextEditor20241022 || toolType == TextEditor20250124 {
+		toolName = TextEditorToolNameLegacy
 	}
 
-	return TextEditorToolDefinition{
+	return ToolDefinition{
 		Type: toolType,
 		Name: toolName,
 	}
@@ -129,12 +154,32 @@ func GetTextEditorTool() *TextEditorTool {
 // API Integration
 // -----------------------------------------------------------------------------
 
-// HandleTextEditorToolCall processes a tool call from Claude and returns the result
-func HandleTextEditorToolCall(toolCall json.RawMessage) *TextEditorToolResult {
+// Name implements Tool.
+func (t *TextEditorTool) Name() string {
+	return TextEditorToolNameNew
+}
+
+// InputSchema implements Tool.
+func (t *TextEditorTool) InputSchema() json.RawMessage {
+	return json.RawMessage(textEditorInputSchema)
+}
+
+// Definition implements Tool; for text_editor_20250728 the view truncation is
+// announced with max_characters.
+func (t *TextEditorTool) Definition(modelID string) ToolDefinition {
+	definition := NewTextEditorToolDefinition(modelID)
+	if definition.Type == TextEditor20250728 {
+		definition.MaxCharacters = t.config.MaxCharacters
+	}
+	return definition
+}
+
+// Execute implements Tool; it processes a tool call from Claude and returns the result
+func (t *TextEditorTool) Execute(toolCall json.RawMessage) ToolResult {
 	// Parse the tool call
 	var call TextEditorToolCall
 	if err := json.Unmarshal(toolCall, &call); err != nil {
-		return &TextEditorToolResult{
+		return ToolResult{
 			Content: "Error parsing tool call: " + err.Error(),
 			IsError: true,
 		}
@@ -146,7 +191,11 @@ func HandleTextEditorToolCall(toolCall json.RawMessage) *TextEditorToolResult {
 		params["file_text"] = call.FileText
 	}
 	if call.ViewRange != nil {
-		params["view_range"] = call.ViewRange
+		viewRange := make([]any, len(call.ViewRange)) // as decoded into a map[string]any
+		for i, v := range call.ViewRange {
+			viewRange[i] = v
+		}
+		params["view_range"] = viewRange
 	}
 	if call.OldStr != "" {
 		params["old_str"] = call.OldStr
@@ -158,19 +207,16 @@ func HandleTextEditorToolCall(toolCall json.RawMessage) *TextEditorToolResult {
 		params["insert_line"] = call.InsertLine
 	}
 
-	// Create or get the text editor tool
-	tool := GetTextEditorTool()
-
 	// Execute the command
-	result, err := tool.ExecuteCommand(EditorCommand(call.Command), call.Path, params)
+	result, err := t.ExecuteCommand(EditorCommand(call.Command), call.Path, params)
 	if err != nil {
-		return &TextEditorToolResult{
+		return ToolResult{
 			Content: "Error: " + err.Error(),
 			IsError: true,
 		}
 	}
 
-	return &TextEditorToolResult{
+	return ToolResult{
 		Content: result,
 		IsError: false,
 	}
@@ -231,6 +277,18 @@ func (t *TextEditorTool) validatePath(command EditorCommand, path string) error
 		return fmt.Errorf("the path %s is not an absolute path, it should start with '/'. Maybe you meant %s?", path, suggestedPath)
 	}
 
+	// Check if the path is within the workspace and not a secret
+	if t.workspace == nil {
+		workspace, err := NewWorkspace(nil)
+		if err != nil {
+			return err
+		}
+		t.workspace = workspace
+	}
+	if err := t.workspace.Check(path); err != nil {
+		return err
+	}
+
 	// Check if path exists (except for create command)
 	if _, err := os.Stat(path); os.IsNotExist(err) && command != CreateCommand {
 		return fmt.Errorf("the path %s does not exist. Please provide a valid path", path)
@@ -263,23 +321,7 @@ func (t *TextEditorTool) view(path string, params map[string]any) (string, error
 			return "", errors.New("the 'view_range' parameter is not allowed when 'path' points to a directory")
 		}
 
-		// List directory contents
-		files, err := os.ReadDir(path)
-		if err != nil {
-			return "", fmt.Errorf("error reading directory %s: %v", path, err)
-		}
-
-		var sb strings.Builder
-		fmt.Fprintf(&sb, "Directory contents of %s:\n", path)
-		for _, file := range files {
-			filePath := filepath.Join(path, file.Name())
-			fileType := "file"
-			if file.IsDir() {
-				fileType = "dir"
-			}
-			fmt.Fprintf(&sb, "%s (%s)\n", filePath, fileType)
-		}
-		return sb.String(), nil
+		return t.listDirectory(path)
 	}
 
 	// Read file content
@@ -290,6 +332,7 @@ func (t *TextEditorTool) view(path string, params map[string]any) (string, error
 
 	// Handle view_range if provided
 	lines := strings.Split(content, "\n")
+	totalLines := len(lines)
 	startLine := 1
 	var endLine int
 
@@ -335,11 +378,115 @@ func (t *TextEditorTool) view(path string, params map[string]any) (string, error
 		content = strings.Join(lines, "\n")
 	}
 
-	return t.makeOutput(content, path, startLine), nil
+	content, note := t.truncateView(content, startLine, totalLines)
+	return t.makeOutput(content, path, startLine) + note, nil
+}
+
+// truncateView shortens the viewed content to the configured max characters at a
+// line boundary and returns a note telling Claude which lines are shown.
+func (t *TextEditorTool) truncateView(content string, startLine int, totalLines int) (string, string) {
+	maxCharacters := t.config.MaxCharacters
+	if maxCharacters <= 0 || len(content) <= maxCharacters {
+		return content, ""
+	}
+
+	lines := strings.Split(content, "\n")
+	shownLines, length := 0, 0
+	for shownLines < len(lines) && length+len(lines[shownLines])+1 <= maxCharacters {
+		length += len(lines[shownLines]) + 1
+		shownLines++
+	}
+	lineCut := shownLines == 0
+	if lineCut { // a single very long line, e.g. of a minified file
+		shownLines = 1
+		cut := maxCharacters
+		for cut > 0 && !utf8.RuneStart(lines[0][cut]) { // don't cut a multi-byte character in half
+			cut--
+		}
+		lines[0] = lines[0][:cut]
+	}
+	endLine := startLine + shownLines - 1
+
+	note := fmt.Sprintf("[Output truncated: showing lines %d-%d of %d, the output was limited to %d characters. "+
+		"Use the view command with view_range to see the remaining lines, e.g. view_range [%d, %d].]\n",
+		startLine, endLine, totalLines, maxCharacters, endLine+1, min(totalLines, endLine+shownLines))
+	if lineCut {
+		note = fmt.Sprintf("[Output truncated: line %d is longer than %d characters and was cut.]\n", startLine, maxCharacters)
+	}
+	return strings.Join(lines[:shownLines], "\n"), note
+}
+
+// listDirectory lists the files and directories within path up to the configured
+// depth and number of entries, level by level so that a truncated listing still
+// shows the top levels. Hidden directories like .git are not descended into.
+func (t *TextEditorTool) listDirectory(path string) (string, error) {
+	maxDepth := max(t.config.MaxDepth, 1)
+	maxEntries := t.config.MaxEntries
+
+	var listed []string
+	truncated := false
+	level := []string{path}
+	for depth := 1; depth <= maxDepth && len(level) > 0 && !truncated; depth++ {
+		var next []string
+		for _, dir := range level {
+			files, err := os.ReadDir(dir)
+			if err != nil {
+				if dir == path {
+					return "", fmt.Errorf("error reading directory %s: %v", path, err)
+				}
+				logger.Println("listDirectory:", err)
+				continue
+			}
+			for _, file := range files {
+				if maxEntries > 0 && len(listed) >= maxEntries {
+					truncated = true
+					break
+				}
+				filePath := filepath.Join(dir, file.Name())
+				if file.IsDir() {
+					listed = append(listed, filePath+" (dir)")
+					if !strings.HasPrefix(file.Name(), ".") {
+						next = append(next, filePath)
+					}
+				} else {
+					listed = append(listed, filePath+" (file)")
+				}
+			}
+		}
+		level = next
+	}
+	slices.Sort(listed)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Directory contents of %s (up to %d levels deep):\n", path, maxDepth)
+	for _, entry := range listed {
+		sb.WriteString(entry + "\n")
+	}
+	if truncated {
+		fmt.Fprintf(&sb, "[Listing truncated after %d entries. View a subdirectory to see more of its contents.]\n", maxEntries)
+	}
+	return sb.String(), nil
 }
 
 // create implements the create command
 func (t *TextEditorTool) create(path string, fileText string) (string, error) {
+	// an existing file is overwritten; its content is diffed against and kept in the
+	// journal to undo the edit
+	before, existed := "", false
+	if info, err := os.Stat(path); err == nil {
+		if info.IsDir() {
+			return "", fmt.Errorf("%s is a directory, a file can't be created there", path)
+		}
+		if before, err = t.readFile(path); err != nil {
+			return "", err
+		}
+		existed = true
+	}
+
+	if err := t.confirmEdit(path, before, fileText); err != nil {
+		return "", err
+	}
+
 	// Ensure directory exists
 	dir := filepath.Dir(path)
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
@@ -354,6 +501,7 @@ func (t *TextEditorTool) create(path string, fileText string) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	t.recordEdit(CreateCommand, path, before, fileText, !existed)
 
 	// Store content in history
 	t.fileHistory[path] = append(t.fileHistory[path], fileText)
@@ -385,17 +533,22 @@ func (t *TextEditorTool) strReplace(path string, oldStr string, newStr string) (
 		return "", fmt.Errorf("no replacement was performed. Multiple occurrences of old_str '%s' in lines %v. Please ensure it is unique", oldStr, lineNumbers)
 	}
 
-	// Save the current content for undo
-	t.fileHistory[path] = append(t.fileHistory[path], fileContent)
-
 	// Replace oldStr with newStr
 	newFileContent := strings.Replace(fileContent, oldStr, newStr, 1)
 
+	if err := t.confirmEdit(path, fileContent, newFileContent); err != nil {
+		return "", err
+	}
+
+	// Save the current content for undo
+	t.fileHistory[path] = append(t.fileHistory[path], fileContent)
+
 	// Write the new content to the file
 	err = t.writeFile(path, newFileContent)
 	if err != nil {
 		return "", err
 	}
+	t.recordEdit(StrReplaceCommand, path, fileContent, newFileContent, false)
 
 	// Create a snippet of the edited section
 	replacementLine := strings.Count(strings.Split(fileContent, oldStr)[0], "\n")
@@ -431,9 +584,6 @@ func (t *TextEditorTool) insert(path string, insertLine int, newStr string) (str
 		return "", fmt.Errorf("invalid 'insert_line' parameter: %d. It should be within the range of lines of the file: [0, %d]", insertLine, len(lines))
 	}
 
-	// Save the current content for undo
-	t.fileHistory[path] = append(t.fileHistory[path], fileContent)
-
 	// Insert new string at the specified location
 	newLines := strings.Split(newStr, "\n")
 
@@ -445,11 +595,19 @@ func (t *TextEditorTool) insert(path string, insertLine int, newStr string) (str
 
 	newFileContent := strings.Join(resultLines, "\n")
 
+	if err := t.confirmEdit(path, fileContent, newFileContent); err != nil {
+		return "", err
+	}
+
+	// Save the current content for undo
+	t.fileHistory[path] = append(t.fileHistory[path], fileContent)
+
 	// Write the new content to the file
 	err = t.writeFile(path, newFileContent)
 	if err != nil {
 		return "", err
 	}
+	t.recordEdit(InsertCommand, path, fileContent, newFileContent, false)
 
 	// Create snippet for output
 	snippetStartLine := max(0, insertLine-SnippetLines)
@@ -475,14 +633,23 @@ func (t *TextEditorTool) undoEdit(path string) (string, error) {
 	historyLen := len(t.fileHistory[path])
 	oldText := t.fileHistory[path][historyLen-1]
 
+	currentText, err := t.readFile(path)
+	if err != nil {
+		return "", err
+	}
+	if err := t.confirmEdit(path, currentText, oldText); err != nil {
+		return "", err
+	}
+
 	// Remove the last item from history
 	t.fileHistory[path] = t.fileHistory[path][:historyLen-1]
 
 	// Write the old content back to the file
-	err := t.writeFile(path, oldText)
+	err = t.writeFile(path, oldText)
 	if err != nil {
 		return "", err
 	}
+	t.recordEdit(UndoEditCommand, path, currentText, oldText, false)
 
 	return fmt.Sprintf("Last edit to %s undone successfully.\n%s", path, t.makeOutput(oldText, path, 1)), nil
 }
@@ -500,6 +667,39 @@ func (t *TextEditorTool) readFile(path string) (string, error) {
 	return string(data), nil
 }
 
+// confirmEdit shows the diff of a proposed change of path and asks the user to
+// accept it if edits have to be confirmed. The returned error, e.g. that the user
+// rejected the edit and why, is sent back to Claude.
+func (t *TextEditorTool) confirmEdit(path string, oldContent string, newContent string) error {
+	if !t.confirmEdits || t.acceptAll {
+		return nil
+	}
+
+	decision, reason, err := confirmEdit(path, unifiedDiff(path, oldContent, newContent))
+	if err != nil {
+		return fmt.Errorf("the edit of %s was not applied, confirmation failed: %v", path, err)
+	}
+
+	switch decision {
+	case editAcceptAll:
+		t.acceptAll = true
+	case editReject:
+		logger.Printf("text editor: user rejected edit of %s, reason='%s'\n", path, reason)
+		if reason == "" {
+			return fmt.Errorf("the user rejected the edit of %s", path)
+		}
+		return fmt.Errorf("the user rejected the edit of %s: %s", path, reason)
+	}
+	return nil
+}
+
+// recordEdit records a written edit in the edit journal, if enabled
+func (t *TextEditorTool) recordEdit(command EditorCommand, path string, before string, after string, created bool) {
+	if t.journal != nil {
+		t.journal.Record(command, path, before, after, created)
+	}
+}
+
 // writeFile writes content to a file
 func (t *TextEditorTool) writeFile(path string, content string) error {
 	err := os.WriteFile(path, []byte(content), 0o600) // before: 0o644) but G306 Expect 0600 or less
@@ -1,7 +1,15 @@
 package main
 
 import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewTextEditorToolDefinition(t *testing.T) {
@@ -99,3 +107,57 @@ func TestNewTextEditorToolDefinition(t *testing.T) {
 		})
 	}
 }
+
+func TestUnifiedDiff(t *testing.T) {
+	diff := unifiedDiff("/tmp/main.go", "package main\n\nfunc a() {}\n", "package main\n\nfunc b() {}\n")
+	assert.Equal(t, "--- /tmp/main.go\n+++ /tmp/main.go\n@@ -1,3 +1,3 @@\n package main\n \n-func a() {}\n+func b() {}\n", diff)
+
+	diff = unifiedDiff("/tmp/new.go", "", "package main\n")
+	assert.Contains(t, diff, "--- /dev/null\n+++ /tmp/new.go\n")
+	assert.Contains(t, diff, "+package main\n")
+}
+
+func TestTextEditorViewLimits(t *testing.T) {
+	dir := t.TempDir()
+	tool := NewTextEditorTool()
+	tool.workspace = &Workspace{roots: []string{dir}}
+	tool.config = TextEditorConfig{MaxCharacters: 100, MaxDepth: 2, MaxEntries: 4}
+
+	assert.Equal(t, 100, tool.Definition(ClaudeV46Opus.String()).MaxCharacters)
+	assert.Zero(t, tool.Definition(ClaudeV37Sonnet.String()).MaxCharacters, "max_characters is only supported by text_editor_20250728")
+
+	file := filepath.Join(dir, "large.txt")
+	require.NoError(t, os.WriteFile(file, []byte(strings.Repeat("0123456789\n", 50)), 0o600))
+
+	out, err := tool.ExecuteCommand(ViewCommand, file, map[string]any{})
+	require.NoError(t, err)
+	assert.Equal(t, 9, strings.Count(out, "0123456789"))
+	assert.Contains(t, out, "showing lines 1-9 of 51")
+	assert.Contains(t, out, "view_range [10, 18]")
+
+	minified := filepath.Join(dir, "minified.txt")
+	require.NoError(t, os.WriteFile(minified, []byte("a"+strings.Repeat("äö", 80)), 0o600))
+	out, err = tool.ExecuteCommand(ViewCommand, minified, map[string]any{})
+	require.NoError(t, err)
+	assert.True(t, utf8.ValidString(out), "a multi-byte character is not cut in half")
+	assert.Contains(t, out, "line 1 is longer than 100 characters and was cut")
+	require.NoError(t, os.Remove(minified))
+
+	result := tool.Execute(json.RawMessage(`{"command": "view", "path": "` + file + `", "view_range": [20, 25]}`))
+	assert.False(t, result.IsError)
+	assert.Equal(t, 6, strings.Count(result.Content, "0123456789"))
+	assert.NotContains(t, result.Content, "truncated")
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "a", "b", "c"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, ".git", "objects"), 0o755))
+	out, err = tool.ExecuteCommand(ViewCommand, dir, map[string]any{})
+	require.NoError(t, err)
+	assert.Contains(t, out, filepath.Join(dir, "a", "b")+" (dir)")
+	assert.NotContains(t, out, filepath.Join(dir, "a", "b", "c"), "deeper than max depth")
+	assert.NotContains(t, out, filepath.Join(dir, ".git", "objects"), "hidden directories are not descended into")
+
+	tool.config.MaxEntries = 2
+	out, err = tool.ExecuteCommand(ViewCommand, dir, map[string]any{})
+	require.NoError(t, err)
+	assert.Contains(t, out, "Listing truncated after 2 entries")
+}
@@ -2,10 +2,11 @@ package main
 
 import (
 	"encoding/json"
-	"slices"
 	"strings"
 )
 
+// AnthropicModel names the built-in models of the model registry, see modelRegistry;
+// their capabilities are defined in bods.yaml.
 type AnthropicModel int
 
 const (
@@ -36,11 +37,12 @@ const (
 
 // The type of the content. Valid values are image and text.
 const (
-	MessageContentTypeText     = "text"
-	MessageContentTypeImage    = "image"
-	MessageContentTypeDocument = "document"
-	MessageContentTypeToolUse  = "tool_use" // "type": "tool_use"
-	MessageContentTypeThinking = "thinking"
+	MessageContentTypeText       = "text"
+	MessageContentTypeImage      = "image"
+	MessageContentTypeDocument   = "document"
+	MessageContentTypeToolUse    = "tool_use" // "type": "tool_use"
+	MessageContentTypeToolResult = "tool_result"
+	MessageContentTypeThinking   = "thinking"
 )
 
 // SourceTypeBase64 is the Source.Type value for base64-encoded image/document data.
@@ -76,14 +78,6 @@ var MessageContentTypes = []string{
 	MessageContentTypeMediaTypePDF,
 }
 
-func (m AnthropicModel) IsClaude3OrHigherModel() bool {
-	if m == ClaudeV3Sonnet || m == ClaudeV3Haiku || m == ClaudeV3Opus || m == ClaudeV35Sonnet || m == ClaudeV35SonnetV2 || m == ClaudeV37Sonnet || m == ClaudeV4Sonnet || m == ClaudeV4Opus || m == ClaudeV45Sonnet || m == ClaudeV45Haiku || m == ClaudeV45Opus || m == ClaudeV46Opus || m == ClaudeV47Opus || m == ClaudeV46Sonnet || m == ClaudeV48Opus {
-		return true
-	}
-
-	return false
-}
-
 func normalizeToModelID(id string) string {
 	// remove region/global prefix if an inference profile id is given
 	// handles both regional prefixes (eu., us.) and global prefix (global.)
@@ -103,63 +97,32 @@ func normalizeToModelID(id string) string {
 	return id
 }
 
+// IsClaude3OrHigherModelID returns true for the models of the model registry,
+// which all use the Messages API with system prompts.
 func IsClaude3OrHigherModelID(id string) bool {
-	v3IDs := []string{
-		ClaudeV3Sonnet.String(),
-		ClaudeV3Haiku.String(),
-		ClaudeV3Opus.String(),
-		ClaudeV35Sonnet.String(),
-		ClaudeV35SonnetV2.String(),
-		ClaudeV35Haiku.String(),
-		ClaudeV37Sonnet.String(),
-		ClaudeV4Sonnet.String(),
-		ClaudeV4Opus.String(),
-		ClaudeV45Sonnet.String(),
-		ClaudeV45Haiku.String(),
-		ClaudeV45Opus.String(),
-		ClaudeV46Opus.String(),
-		ClaudeV47Opus.String(),
-		ClaudeV46Sonnet.String(),
-		ClaudeV48Opus.String(),
-	}
-	modelID := normalizeToModelID(id)
-	return slices.Contains(v3IDs, modelID)
+	_, ok := modelRegistry.Lookup(id)
+	return ok
 }
 
 func IsVisionCapable(id string) bool {
-	modelID := normalizeToModelID(id)
-	return IsClaude3OrHigherModelID(modelID) && modelID != ClaudeV35Haiku.String()
+	return lookupModel(id).Vision
+}
+
+// IsPDFCapable returns true if the given model ID supports PDF document content.
+func IsPDFCapable(id string) bool {
+	return lookupModel(id).PDF
 }
 
 // IsPromptCachingSupported returns true if the given model ID supports prompt caching.
-// Prompt caching is generally available with Claude 3.7 Sonnet, Claude 3.5 Haiku, Claude 4, Claude 4.5, Claude 4.6, Claude 4.7, and Claude 4.8.
 // See: https://docs.aws.amazon.com/bedrock/latest/userguide/prompt-caching.html#prompt-caching-models
 func IsPromptCachingSupported(id string) bool {
-	modelID := normalizeToModelID(id)
-	cachingSupportedModels := []string{
-		ClaudeV35Haiku.String(),  // Claude 3.5 Haiku
-		ClaudeV37Sonnet.String(), // Claude 3.7 Sonnet
-		ClaudeV4Sonnet.String(),  // Claude 4 Sonnet
-		ClaudeV4Opus.String(),    // Claude 4 Opus
-		ClaudeV45Sonnet.String(), // Claude 4.5 Sonnet
-		ClaudeV45Opus.String(),   // Claude 4.5 Opus
-		ClaudeV45Haiku.String(),  // Claude 4.5 Haiku
-		ClaudeV46Opus.String(),   // Claude 4.6 Opus
-		ClaudeV47Opus.String(),   // Claude 4.7 Opus
-		ClaudeV46Sonnet.String(), // Claude 4.6 Sonnet
-		ClaudeV48Opus.String(),   // Claude 4.8 Opus
-	}
-	return slices.Contains(cachingSupportedModels, modelID)
+	return lookupModel(id).Caching
 }
 
 // IsEffortParamSupported returns true if the given model ID supports the effort parameter.
-// The effort parameter is supported by Claude Opus 4.5, Claude Opus 4.6, Claude Opus 4.7, Claude Opus 4.8,
-// and Claude Sonnet 4.6 (which defaults to effort "high"). Note that "xhigh"/"max" remain
-// Opus-only; Sonnet 4.6 accepts "high"/"medium"/"low".
-// See: opus47vision.md ("Migrating to Claude Sonnet 4.6").
+// The supported levels differ per model, e.g. "xhigh"/"max" are Opus-only.
 func IsEffortParamSupported(id string) bool {
-	modelID := normalizeToModelID(id)
-	return modelID == ClaudeV45Opus.String() || modelID == ClaudeV46Opus.String() || modelID == ClaudeV47Opus.String() || modelID == ClaudeV46Sonnet.String() || modelID == ClaudeV48Opus.String()
+	return lookupModel(id).SupportsEffort("")
 }
 
 // IsSamplingParamsRejected returns true for models that reject ANY non-default
@@ -168,49 +131,25 @@ func IsEffortParamSupported(id string) bool {
 // must be omitted from the request entirely.
 // See: opus47vision.md ("Sampling parameters removed").
 func IsSamplingParamsRejected(id string) bool {
-	modelID := normalizeToModelID(id)
-	return modelID == ClaudeV47Opus.String() || modelID == ClaudeV48Opus.String()
+	return lookupModel(id).Sampling == SamplingNone
 }
 
 // IsClaude45OrHigherModel returns true if the given model ID is Claude 4.5+ (Sonnet, Haiku, Opus, or Opus 4.6).
 // Claude 4.5+ models have a breaking change where only temperature OR top_p can be specified, not both.
 func IsClaude45OrHigherModel(id string) bool {
-	modelID := normalizeToModelID(id)
-	claude45PlusModels := []string{
-		ClaudeV45Sonnet.String(), // Claude 4.5 Sonnet
-		ClaudeV45Haiku.String(),  // Claude 4.5 Haiku
-		ClaudeV45Opus.String(),   // Claude 4.5 Opus
-		ClaudeV46Opus.String(),   // Claude 4.6 Opus
-		ClaudeV47Opus.String(),   // Claude 4.7 Opus
-		ClaudeV46Sonnet.String(), // Claude 4.6 Sonnet
-		ClaudeV48Opus.String(),   // Claude 4.8 Opus
-	}
-	return slices.Contains(claude45PlusModels, modelID)
-}
-
-// IsOpus46Model returns true if the given model ID is Claude Opus 4.6.
-func IsOpus46Model(id string) bool {
-	modelID := normalizeToModelID(id)
-	return modelID == ClaudeV46Opus.String()
-}
-
-// IsOpus47Model returns true if the given model ID is Claude Opus 4.7.
-func IsOpus47Model(id string) bool {
-	modelID := normalizeToModelID(id)
-	return modelID == ClaudeV47Opus.String()
+	sampling := lookupModel(id).Sampling
+	return sampling == SamplingTemperatureOrTop || sampling == SamplingNone
 }
 
-// IsOpus48Model returns true if the given model ID is Claude Opus 4.8.
-func IsOpus48Model(id string) bool {
-	modelID := normalizeToModelID(id)
-	return modelID == ClaudeV48Opus.String()
+// IsThinkingSupported returns true for models that support extended or adaptive thinking.
+func IsThinkingSupported(id string) bool {
+	return lookupModel(id).Thinking != ""
 }
 
 // IsAdaptiveThinkingModel returns true for models that use adaptive thinking
 // rather than manual budget_tokens (Opus 4.6, Opus 4.7, Opus 4.8, and Sonnet 4.6).
 func IsAdaptiveThinkingModel(id string) bool {
-	modelID := normalizeToModelID(id)
-	return modelID == ClaudeV46Opus.String() || modelID == ClaudeV47Opus.String() || modelID == ClaudeV46Sonnet.String() || modelID == ClaudeV48Opus.String()
+	return lookupModel(id).Thinking == ThinkingAdaptive
 }
 
 func (m AnthropicModel) String() string {
@@ -252,27 +191,6 @@ func (m AnthropicModel) String() string {
 	}
 }
 
-var AnthrophicModelsIDs = []string{
-	// ClaudeV2.String(),
-	// ClaudeV21.String(),
-	ClaudeV3Sonnet.String(),
-	ClaudeV3Haiku.String(),
-	ClaudeV3Opus.String(),
-	ClaudeV35Sonnet.String(),
-	ClaudeV35SonnetV2.String(),
-	ClaudeV35Haiku.String(),
-	ClaudeV37Sonnet.String(),
-	ClaudeV4Sonnet.String(),
-	ClaudeV4Opus.String(),
-	ClaudeV45Sonnet.String(),
-	ClaudeV45Haiku.String(),
-	ClaudeV45Opus.String(),
-	ClaudeV46Opus.String(),
-	ClaudeV47Opus.String(),
-	ClaudeV46Sonnet.String(),
-	ClaudeV48Opus.String(),
-}
-
 // --- anthropic.claude ----------------------------
 // see https://docs.aws.amazon.com/bedrock/latest/userguide/model-parameters.html
 
@@ -296,6 +214,7 @@ type Source struct {
 }
 type CacheControl struct {
 	Type string `json:"type,omitempty"`
+	TTL  string `json:"ttl,omitempty"` // "5m" (default) or "1h", see --cache-ttl
 }
 type Citations struct {
 	Enabled bool `json:"enabled"`
@@ -307,7 +226,8 @@ type Content struct {
 	ID        string `json:"id,omitempty"`          // tool_use
 	ToolUseID string `json:"tool_use_id,omitempty"` // tool_use
 	Name      string `json:"name,omitempty"`        // tool_use
-	Content   string `json:"content,omitempty"`     // tool_use
+	Content   string `json:"content,omitempty"`     // tool_result
+	IsError   bool   `json:"is_error,omitempty"`    // tool_result
 	// Input     string `json:"input,omitempty"`       // if Type='tool_use', json string
 	Input        json.RawMessage `json:"input,omitempty"`     // if Type='tool_use'
 	Source       *Source         `json:"source,omitempty"`    // if Type = 'image' or 'document'
@@ -317,19 +237,87 @@ type Content struct {
 	Citations    *Citations      `json:"citations,omitempty"`
 }
 
+// SystemPrompt is the system prompt of a request. It is sent as a string, or as a
+// text block if it carries a cache breakpoint, see planCache.
+type SystemPrompt struct {
+	Text         string
+	CacheControl *CacheControl
+}
+
+func (s SystemPrompt) MarshalJSON() ([]byte, error) {
+	if s.CacheControl == nil {
+		return json.Marshal(s.Text)
+	}
+	return json.Marshal([]Content{{Type: MessageContentTypeText, Text: s.Text, CacheControl: s.CacheControl}})
+}
+
+// UnmarshalJSON accepts both the string and the text block form.
+func (s *SystemPrompt) UnmarshalJSON(data []byte) error {
+	*s = SystemPrompt{}
+	if err := json.Unmarshal(data, &s.Text); err == nil {
+		return nil
+	}
+	var blocks []Content
+	if err := json.Unmarshal(data, &blocks); err != nil {
+		return err
+	}
+	texts := make([]string, 0, len(blocks))
+	for _, b := range blocks {
+		texts = append(texts, b.Text)
+		if b.CacheControl != nil {
+			s.CacheControl = b.CacheControl
+		}
+	}
+	s.Text = strings.Join(texts, "\n")
+	return nil
+}
+
+// Usage holds the token counts reported by the model for one or more requests.
+type Usage struct {
+	InputTokens           int `json:"input_tokens"` // without the cached input tokens
+	OutputTokens          int `json:"output_tokens"`
+	CacheReadInputTokens  int `json:"cache_read_input_tokens,omitempty"`
+	CacheWriteInputTokens int `json:"cache_creation_input_tokens,omitempty"`
+}
+
+// Add adds the token counts of u2 to u.
+func (u *Usage) Add(u2 Usage) {
+	u.InputTokens += u2.InputTokens
+	u.OutputTokens += u2.OutputTokens
+	u.CacheReadInputTokens += u2.CacheReadInputTokens
+	u.CacheWriteInputTokens += u2.CacheWriteInputTokens
+}
+
 type ThinkingConfig struct {
 	Type         string `json:"type"`                    // "enabled" or "adaptive"
 	BudgetTokens int    `json:"budget_tokens,omitempty"` // budget_tokens is 1024 tokens (omitted for adaptive thinking)
 }
 
 type OutputConfig struct {
-	Effort string `json:"effort"` // "max" | "xhigh" | "high" | "medium" | "low"
+	Effort string        `json:"effort,omitempty"` // "max" | "xhigh" | "high" | "medium" | "low"
+	Format *OutputFormat `json:"format,omitempty"` // structured outputs, see --schema
+}
+
+// OutputFormatJSONSchema constrains the response to a JSON schema.
+const OutputFormatJSONSchema = "json_schema"
+
+type OutputFormat struct {
+	Type   string          `json:"type"` // "json_schema"
+	Schema json.RawMessage `json:"schema"`
+}
+
+// ToolChoiceTool forces Claude to call the named tool.
+const ToolChoiceTool = "tool"
+
+type ToolChoice struct {
+	Type string `json:"type"` // "auto" | "any" | "tool" | "none"
+	Name string `json:"name,omitempty"`
 }
 
 type AnthropicClaudeMessagesInferenceParameters struct {
 	AnthropicVersion string          `json:"anthropic_version"`
 	Messages         []Message       `json:"messages"`
-	System           string          `json:"system,omitempty"`
+	System           SystemPrompt    `json:"system,omitzero"`
 	Temperature      *float64        `json:"temperature,omitempty"` // pointer allows omitting for models that reject sampling params (Opus 4.7+)
 	MaxTokens        int             `json:"max_tokens"`
 	TopP             *float64        `json:"top_p,omitempty"` // pointer allows omitting for Claude 4.5 models
@@ -337,7 +325,8 @@ type AnthropicClaudeMessagesInferenceParameters struct {
 	StopSequences    []string        `json:"stop_sequences,omitempty"`
 	Thinking         *ThinkingConfig `json:"thinking,omitempty"`
 	OutputConfig     *OutputConfig   `json:"output_config,omitempty"`
-	Tools            []any           `json:"tools,omitempty"`          // Tools for Claude (e.g., text editor)
+	Tools            []any           `json:"tools,omitempty"` // Tools for Claude (e.g., text editor)
+	ToolChoice       *ToolChoice     `json:"tool_choice,omitempty"`
 	AnthropicBeta    []string        `json:"anthropic_beta,omitempty"` // "anthropic_beta": ["computer-use-2024-10-22"] or ["token-efficient-tools-2025-02-19"]
 }
 
@@ -394,54 +383,69 @@ type AnthropicClaudeMessagesResponse struct {
 	Type string `json:"type"`
 
 	// type: ""message_start""
-	Message *struct {
-		Content      []any  `json:"content"`
-		ID           string `json:"id"`
-		Model        string `json:"model"`
-		Role         string `json:"role"`
-		StopReason   any    `json:"stop_reason"`
-		StopSequence any    `json:"stop_sequence"`
-		Type         string `json:"type"`
-		Usage        struct {
-			InputTokens  int `json:"input_tokens"`
-			OutputTokens int `json:"output_tokens"`
-		} `json:"usage"`
-	} `json:"message,omitempty"`
-
-	// type: "message_start""
-	Usage *struct {
-		OutputTokens int `json:"output_tokens"`
-	} `json:"usage,omitempty"`
+	Message *ResponseMessage `json:"message,omitempty"`
+
+	// type: "message_delta"; cumulative usage of the message
+	Usage *ResponseUsage `json:"usage,omitempty"`
 
 	// type: "content_block"
-	ContentBlock *struct {
-		Text  string `json:"text"`
-		Type  string `json:"type"`
-		Index int    `json:"index,omitempty"`
-		ID    string `json:"id,omitempty"`
-		Name  string `json:"name,omitempty"`
-	} `json:"content_block,omitempty"`
+	ContentBlock *ResponseContentBlock `json:"content_block,omitempty"`
 
 	// type: "content_block_delta"
-	Delta *struct {
-		StopReason   string `json:"stop_reason,omitempty"`
-		StopSequence any    `json:"stop_sequence,omitempty"`
-		Type         string `json:"type,omitempty"`
-		Text         string `json:"text,omitempty"`
-		Thinking     string `json:"thinking,omitempty"`
-		PartialJSON  string `json:"partial_json,omitempty"`
-		Signature    string `json:"signature,omitempty"`
-	} `json:"delta,omitempty"`
+	Delta *ResponseDelta `json:"delta,omitempty"`
 
 	Index int `json:"index,omitempty"`
 
 	// type: "message_stop"
-	AmazonBedrockInvocationMetrics *struct {
-		FirstByteLatency  int `json:"firstByteLatency"`
-		InputTokenCount   int `json:"inputTokenCount"`
-		InvocationLatency int `json:"invocationLatency"`
-		OutputTokenCount  int `json:"outputTokenCount"`
-	} `json:"amazon-bedrock-invocationMetrics,omitempty"`
+	AmazonBedrockInvocationMetrics *InvocationMetrics `json:"amazon-bedrock-invocationMetrics,omitempty"`
+
+	// with a guardrail, see --guardrail; the action is INTERVENED or NONE
+	AmazonBedrockGuardrailAction string          `json:"amazon-bedrock-guardrailAction,omitempty"`
+	AmazonBedrockTrace           json.RawMessage `json:"amazon-bedrock-trace,omitempty"`
+}
+
+type ResponseMessage struct {
+	Content      []any         `json:"content"`
+	ID           string        `json:"id"`
+	Model        string        `json:"model"`
+	Role         string        `json:"role"`
+	StopReason   any           `json:"stop_reason"`
+	StopSequence any           `json:"stop_sequence"`
+	Type         string        `json:"type"`
+	Usage        ResponseUsage `json:"usage"`
+}
+
+type ResponseUsage struct {
+	InputTokens              int `json:"input_tokens,omitempty"` // in message_delta only if not reported by message_start
+	OutputTokens             int `json:"output_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens,omitempty"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitempty"`
+}
+
+type ResponseContentBlock struct {
+	Text  string `json:"text"`
+	Type  string `json:"type"`
+	Index int    `json:"index,omitempty"`
+	ID    string `json:"id,omitempty"`
+	Name  string `json:"name,omitempty"`
+}
+
+type ResponseDelta struct {
+	StopReason   string `json:"stop_reason,omitempty"`
+	StopSequence any    `json:"stop_sequence,omitempty"`
+	Type         string `json:"type,omitempty"`
+	Text         string `json:"text,omitempty"`
+	Thinking     string `json:"thinking,omitempty"`
+	PartialJSON  string `json:"partial_json,omitempty"`
+	Signature    string `json:"signature,omitempty"`
+}
+
+// InvocationMetrics are added to the message_stop event by Bedrock.
+type InvocationMetrics struct {
+	FirstByteLatency  int `json:"firstByteLatency"`
+	InputTokenCount   int `json:"inputTokenCount"`
+	InvocationLatency int `json:"invocationLatency"`
+	OutputTokenCount  int `json:"outputTokenCount"`
 }
 
 type ResponseEventType int
@@ -455,6 +459,7 @@ const (
 	EventContentBlockStop                    // content_block_stop
 	EventMessageDelta                        // message_delta
 	EventMessageStop                         // message_stop
+	EventRetry                               // retry, of bods: the response failed and is invoked again, its content so far is discarded
 )
 
 func (r ResponseEventType) String() string {
@@ -473,6 +478,8 @@ func (r ResponseEventType) String() string {
 		return "message_delta"
 	case EventMessageStop:
 		return "message_stop"
+	case EventRetry:
+		return "retry"
 	default:
 		return "unknown ResponseEventType"
 	}
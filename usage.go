@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// roundUsage is the usage of one model invocation, e.g. one round of a tool use loop.
+type roundUsage struct {
+	ModelID        string
+	Usage          Usage
+	ThinkingTokens int                // estimated from the length of the thinking text, see estimateTokens
+	TTFB           time.Duration      // time to the first event of the response
+	Latency        time.Duration      // time to the end of the response
+	CachePlan      cachePlan          // cache breakpoints of the request
+	Metrics        *InvocationMetrics // reported by Bedrock with message_stop, nil for the Anthropic API
+	Batch          bool               // invoked by a batch inference job, at a discount, see bods batch submit
+
+	start time.Time
+}
+
+// Cost returns the estimated cost in USD from the price of the model, see
+// ModelInfo.Price; false if the price of the model is unknown.
+func (r roundUsage) Cost() (float64, bool) {
+	price := lookupModel(r.ModelID).Price
+	if price.Input == 0 && price.Output == 0 {
+		return 0, false
+	}
+	u := r.Usage
+	cost := (float64(u.InputTokens)*price.Input +
+		float64(u.OutputTokens)*price.Output +
+		float64(u.CacheReadInputTokens)*price.CacheRead +
+		float64(u.CacheWriteInputTokens)*price.CacheWrite) / 1_000_000
+	if r.Batch {
+		cost *= batchJobPriceFactor
+	}
+	return cost, true
+}
+
+// CacheSavings returns the estimated cost in USD saved by prompt caching: cache reads
+// cost less than input tokens, cache writes cost more. It is negative if caching did
+// not pay off.
+func (r roundUsage) CacheSavings() float64 {
+	price := lookupModel(r.ModelID).Price
+	u := r.Usage
+	return (float64(u.CacheReadInputTokens)*(price.Input-price.CacheRead) -
+		float64(u.CacheWriteInputTokens)*(price.CacheWrite-price.Input)) / 1_000_000
+}
+
+// estimateTokens estimates the number of tokens of a text, about four characters
+// per token for English text and code.
+func estimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+// formatUsage returns the usage summary of --usage as a table with a row per round
+// if there is more than one, and the total, followed by the cache hits and misses.
+func formatUsage(s styles, rounds []roundUsage) string {
+	if len(rounds) == 0 {
+		return ""
+	}
+
+	var total roundUsage
+	totalCost, totalCostKnown := 0.0, true
+	row := func(name string, r roundUsage, cost float64, costKnown bool) []string {
+		thinking := "0"
+		if r.ThinkingTokens > 0 {
+			thinking = "~" + strconv.Itoa(r.ThinkingTokens)
+		}
+		costText := "unknown"
+		if costKnown {
+			costText = fmt.Sprintf("$%.4f", cost)
+		}
+		return []string{
+			name,
+			strconv.Itoa(r.Usage.InputTokens),
+			strconv.Itoa(r.Usage.OutputTokens),
+			strconv.Itoa(r.Usage.CacheReadInputTokens),
+			strconv.Itoa(r.Usage.CacheWriteInputTokens),
+			thinking,
+			formatSeconds(r.TTFB),
+			formatSeconds(r.Latency),
+			costText,
+		}
+	}
+
+	var rows [][]string
+	for i, r := range rounds {
+		cost, known := r.Cost()
+		if len(rounds) > 1 {
+			rows = append(rows, row(strconv.Itoa(i+1), r, cost, known))
+		}
+		total.Usage.Add(r.Usage)
+		total.ThinkingTokens += r.ThinkingTokens
+		total.Latency += r.Latency
+		totalCost += cost
+		totalCostKnown = totalCostKnown && known
+	}
+	total.TTFB = rounds[0].TTFB
+	rows = append(rows, row("total", total, totalCost, totalCostKnown))
+
+	header := []string{"ROUND", "INPUT", "OUTPUT", "CACHE READ", "CACHE WRITE", "THINKING", "TTFB", "LATENCY", "COST"}
+	return formatTable(s, header, rows) + formatCacheEffectiveness(s, rounds)
+}
+
+// formatCacheEffectiveness returns the prompt cache hits and misses of the rounds: a
+// round is a hit if it read from the cache, and a miss if it had cache breakpoints
+// but read nothing. It is empty if the cache was not used.
+func formatCacheEffectiveness(s styles, rounds []roundUsage) string {
+	var hits, misses int
+	var total Usage
+	for _, r := range rounds {
+		switch {
+		case r.Usage.CacheReadInputTokens > 0:
+			hits++
+		case r.CachePlan.Breakpoints() > 0 || r.Usage.CacheWriteInputTokens > 0:
+			misses++
+		}
+		total.Add(r.Usage)
+	}
+	if hits+misses == 0 {
+		return ""
+	}
+	input := total.InputTokens + total.CacheReadInputTokens + total.CacheWriteInputTokens
+	text := fmt.Sprintf("prompt cache: %d hit(s), %d miss(es), %.0f%% of the input tokens read from the cache",
+		hits, misses, 100*float64(total.CacheReadInputTokens)/float64(max(input, 1)))
+	return s.ConversationList.Render(text) + "\n"
+}
+
+func formatSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.1fs", d.Seconds())
+}
+
+// startRound starts the usage round of a model invocation whose request was sent
+// at the given time.
+func (b *Bods) startRound(start time.Time) {
+	b.rounds = append(b.rounds, roundUsage{ModelID: b.Config.ModelID, CachePlan: b.cachePlan, start: start})
+}
+
+// round returns the usage round of the current model invocation.
+func (b *Bods) round() *roundUsage {
+	if len(b.rounds) == 0 {
+		b.startRound(time.Now())
+	}
+	return &b.rounds[len(b.rounds)-1]
+}
+
+// addUsage adds token counts reported by the model to the current round and the
+// total of this run.
+func (b *Bods) addUsage(u Usage) {
+	b.round().Usage.Add(u)
+	b.Usage.Add(u)
+}
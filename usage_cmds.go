@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	usageSince string
+	usageBy    string
+	usageJSON  bool
+
+	usageCmd = &cobra.Command{
+		Use:   "usage",
+		Short: "Summarize the token usage and estimated cost of past model invocations",
+		Args:  noPrompt(cobra.NoArgs),
+		RunE: func(_ *cobra.Command, _ []string) error {
+			age, err := parseAge(usageSince)
+			if err != nil {
+				return bodsError{err, "Invalid value for --since."}
+			}
+			entries, err := readLedger(time.Now().Add(-age))
+			if err != nil {
+				return bodsError{err, "Could not read the usage ledger."}
+			}
+			summaries, err := summarizeUsage(entries, strings.ToLower(usageBy))
+			if err != nil {
+				return bodsError{err, "Invalid value for --by."}
+			}
+
+			if usageJSON {
+				return printJSON(summaries)
+			}
+			if len(summaries) == 0 {
+				_, _ = fmt.Fprintf(os.Stderr, "No usage recorded in the last %s.\n", usageSince)
+				return nil
+			}
+			printUsageSummaries(summaries, strings.ToLower(usageBy))
+			return nil
+		},
+	}
+)
+
+func initUsageCommands() {
+	usageCmd.Flags().StringVar(&usageSince, "since", "30d", "Summarize the usage within the given age, e.g. 7d, 2w or 12h")
+	usageCmd.Flags().StringVar(&usageBy, "by", usageByModel, "Group the usage by "+strings.Join(usageGroupings, ", "))
+	usageCmd.Flags().BoolVar(&usageJSON, "json", false, "Print the summary as JSON")
+	_ = usageCmd.RegisterFlagCompletionFunc("by",
+		func(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+			return usageGroupings, cobra.ShellCompDirectiveNoFileComp
+		},
+	)
+	rootCmd.AddCommand(usageCmd)
+}
+
+// printUsageSummaries prints the summaries as a table with a total row.
+func printUsageSummaries(summaries []UsageSummary, by string) {
+	var total UsageSummary
+	total.Key = "total"
+
+	row := func(s UsageSummary) []string {
+		key := s.Key
+		if by == usageByModel && key != total.Key {
+			key = shortModelName(key)
+		}
+		cost := fmt.Sprintf("$%.4f", s.Cost)
+		if s.CostUnknown > 0 {
+			cost += fmt.Sprintf(" (%d unpriced)", s.CostUnknown)
+		}
+		return []string{
+			key,
+			strconv.Itoa(s.Calls),
+			formatTokens(s.Usage.InputTokens),
+			formatTokens(s.Usage.OutputTokens),
+			formatTokens(s.Usage.CacheReadInputTokens),
+			formatTokens(s.Usage.CacheWriteInputTokens),
+			fmt.Sprintf("%.0f%%", 100*s.CacheHitRate()),
+			fmt.Sprintf("$%.4f", s.CacheSavings),
+			cost,
+		}
+	}
+
+	var rows [][]string
+	for _, s := range summaries {
+		rows = append(rows, row(s))
+		total.Calls += s.Calls
+		total.Usage.Add(s.Usage)
+		total.Cost += s.Cost
+		total.CostUnknown += s.CostUnknown
+		total.CacheSavings += s.CacheSavings
+	}
+	rows = append(rows, row(total))
+
+	header := []string{strings.ToUpper(by), "CALLS", "INPUT", "OUTPUT", "CACHE READ", "CACHE WRITE", "CACHE HITS", "CACHE SAVINGS", "COST"}
+	printTable(header, rows)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoundUsageCost(t *testing.T) {
+	r := roundUsage{
+		ModelID: "us.anthropic.claude-opus-4-8",
+		Usage:   Usage{InputTokens: 1_000_000, OutputTokens: 100_000, CacheReadInputTokens: 200_000, CacheWriteInputTokens: 10_000},
+	}
+	cost, ok := r.Cost()
+	assert.True(t, ok)
+	assert.InDelta(t, 5+2.5+0.1+0.0625, cost, 1e-9)
+
+	_, ok = roundUsage{ModelID: "anthropic.claude-unknown", Usage: r.Usage}.Cost()
+	assert.False(t, ok)
+}
+
+func TestFormatUsage(t *testing.T) {
+	s := makeStyles(lipgloss.DefaultRenderer())
+	rounds := []roundUsage{
+		{ModelID: "anthropic.claude-sonnet-4-6", Usage: Usage{InputTokens: 1200, OutputTokens: 80}, ThinkingTokens: 40, TTFB: 800 * time.Millisecond, Latency: 2 * time.Second},
+		{ModelID: "anthropic.claude-sonnet-4-6", Usage: Usage{InputTokens: 1400, OutputTokens: 20, CacheReadInputTokens: 1000}, TTFB: 500 * time.Millisecond, Latency: time.Second},
+	}
+	lines := strings.Split(strings.TrimSpace(formatUsage(s, rounds)), "\n")
+	assert.Len(t, lines, 5) // header, two rounds, the total and the cache hits
+	assert.Equal(t, []string{"total", "2600", "100", "1000", "0", "~40", "0.8s", "3.0s", "$0.0096"}, strings.Fields(lines[3]))
+	assert.Equal(t, "prompt cache: 1 hit(s), 0 miss(es), 28% of the input tokens read from the cache", strings.TrimSpace(lines[4]))
+
+	lines = strings.Split(strings.TrimSpace(formatUsage(s, rounds[:1])), "\n")
+	assert.Len(t, lines, 2) // no rows per round for a single round, no cache use
+
+	rounds[0].CachePlan = cachePlan{System: true}
+	lines = strings.Split(strings.TrimSpace(formatUsage(s, rounds[:1])), "\n")
+	assert.Contains(t, lines[len(lines)-1], "0 hit(s), 1 miss(es)")
+
+	assert.Empty(t, formatUsage(s, nil))
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// deniedDirs are directories holding credentials and secrets; the text editor
+// never accesses files within them, even inside the workspace.
+var deniedDirs = []string{".ssh", ".aws", ".gnupg", ".kube", ".docker", ".azure", "gcloud", ".password-store"}
+
+// deniedFiles are file name patterns (see filepath.Match) of credential and secret
+// files the text editor never accesses, even inside the workspace.
+var deniedFiles = []string{
+	".env", ".env.*", ".envrc", ".netrc", ".git-credentials", ".npmrc", ".pypirc", ".htpasswd",
+	"credentials", "credentials.json", "*.pem", "*.key", "*.p12", "*.pfx", "*.keystore", "*.jks",
+	"id_rsa*", "id_dsa*", "id_ecdsa*", "id_ed25519*",
+}
+
+// Workspace restricts file access to a set of root directories, by default the
+// current working directory, and denies access to credential and secret files.
+type Workspace struct {
+	roots []string // absolute and symlink-resolved
+}
+
+// NewWorkspace returns a workspace rooted at the current working directory and
+// the additional paths given with --allow-path.
+func NewWorkspace(allowPaths []string) (*Workspace, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("could not get working directory: %w", err)
+	}
+
+	w := &Workspace{}
+	for _, p := range append([]string{wd}, allowPaths...) {
+		if strings.HasPrefix(p, "~/") {
+			if home, err := os.UserHomeDir(); err == nil {
+				p = filepath.Join(home, p[2:])
+			}
+		}
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path '%s': %w", p, err)
+		}
+		resolved, err := filepath.EvalSymlinks(abs)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path '%s': %w", p, err)
+		}
+		w.roots = append(w.roots, resolved)
+	}
+	logger.Printf("workspace roots: %v\n", w.roots)
+
+	return w, nil
+}
+
+// Roots returns the root directories of the workspace.
+func (w *Workspace) Roots() []string {
+	return w.roots
+}
+
+// Check returns an error if the absolute path is outside of the workspace roots,
+// after resolving symlinks, or is a credential or secret file.
+func (w *Workspace) Check(path string) error {
+	path = filepath.Clean(path)
+	resolved, err := resolvePath(path)
+	if err != nil {
+		return fmt.Errorf("could not resolve path %s: %v", path, err)
+	}
+
+	for _, p := range []string{path, resolved} {
+		if pattern, denied := isSecretPath(p); denied {
+			return fmt.Errorf("access to %s is denied, it matches '%s' of the built-in list of credential and secret files", path, pattern)
+		}
+	}
+
+	for _, root := range w.roots {
+		if isWithin(root, resolved) {
+			return nil
+		}
+	}
+	if resolved != path {
+		return fmt.Errorf("the path %s (resolved to %s) is outside of the workspace %s", path, resolved, strings.Join(w.roots, ", "))
+	}
+	return fmt.Errorf("the path %s is outside of the workspace %s", path, strings.Join(w.roots, ", "))
+}
+
+// resolvePath resolves the symlinks of an absolute path. For a path that does not
+// exist yet, e.g. a file to create, the longest existing parent is resolved.
+func resolvePath(path string) (string, error) {
+	var rest []string
+	for {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, rest...)...), nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		if _, lerr := os.Lstat(path); lerr == nil {
+			return "", fmt.Errorf("%s is a broken symlink", path) // writing would create its target
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return "", err
+		}
+		rest = append([]string{filepath.Base(path)}, rest...)
+		path = parent
+	}
+}
+
+// isWithin reports whether path is root or within root.
+func isWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// isSecretPath reports whether path is a credential or secret file or within a
+// directory of such files, and returns the matching pattern.
+func isSecretPath(path string) (string, bool) {
+	parts := strings.Split(path, string(filepath.Separator))
+	for _, part := range parts {
+		for _, d := range deniedDirs {
+			if part == d {
+				return d, true
+			}
+		}
+	}
+
+	base := parts[len(parts)-1]
+	for _, pattern := range deniedFiles {
+		if ok, _ := filepath.Match(pattern, base); ok {
+			return pattern, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkspaceCheck(t *testing.T) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	require.NoError(t, err)
+	outside, err := filepath.EvalSymlinks(t.TempDir())
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(filepath.Join(root, "main.go"), []byte("package main\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret\n"), 0o600))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(root, "link.txt")))
+	require.NoError(t, os.Symlink(outside, filepath.Join(root, "linkdir")))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "missing.txt"), filepath.Join(root, "dangling.txt")))
+
+	w := &Workspace{roots: []string{root}}
+
+	tests := []struct {
+		path    string
+		allowed bool
+	}{
+		{path: root, allowed: true},
+		{path: filepath.Join(root, "main.go"), allowed: true},
+		{path: filepath.Join(root, "sub", "new.go"), allowed: true}, // does not exist yet
+		{path: root + "/../" + filepath.Base(outside) + "/secret.txt", allowed: false},
+		{path: filepath.Join(outside, "secret.txt"), allowed: false},
+		{path: filepath.Join(root, "link.txt"), allowed: false},
+		{path: filepath.Join(root, "linkdir", "new.txt"), allowed: false},
+		{path: filepath.Join(root, "dangling.txt"), allowed: false},
+		{path: filepath.Join(root, ".env"), allowed: false},
+		{path: filepath.Join(root, "certs", "server.pem"), allowed: false},
+		{path: filepath.Join(root, ".aws", "config"), allowed: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			err := w.Check(tt.path)
+			if tt.allowed {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+
+	w.roots = append(w.roots, outside) // --allow-path
+	assert.NoError(t, w.Check(filepath.Join(root, "link.txt")))
+}